@@ -0,0 +1,176 @@
+package sis_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"sis"
+	"sis/internal/crud/crudos"
+	"sis/internal/pk"
+	"testing"
+)
+
+func TestBatchCommit(t *testing.T) {
+	ctx := t.Context()
+	sisInstance := newTestSIS(t)
+
+	for _, key := range []string{"a", "b", "c"} {
+		err := sisInstance.Create(ctx, pk.New(key), []byte(key))
+		if err != nil {
+			t.Fatalf("error creating '%s': %s", key, err.Error())
+		}
+	}
+
+	batch := sisInstance.Batch()
+	for _, err := range []error{
+		batch.Create(ctx, pk.New("new"), []byte("new")),
+		batch.Update(ctx, pk.New("a"), []byte("a2")),
+		batch.Delete(pk.New("b")),
+		batch.Copy(pk.New("c"), pk.New("c2")),
+	} {
+		if err != nil {
+			t.Fatalf("error building batch: %s", err.Error())
+		}
+	}
+	if batch.Update(ctx, pk.New("new"), []byte("again")) == nil {
+		t.Fatalf("expected a key written twice to conflict")
+	}
+	if batch.Copy(pk.New("a"), pk.New("d")) == nil {
+		t.Fatalf("expected a copy of a written key to conflict")
+	}
+
+	err := batch.Commit(ctx)
+	if err != nil {
+		t.Fatalf("error committing batch: %s", err.Error())
+	}
+	if batch.Commit(ctx) == nil {
+		t.Fatalf("expected a second commit to fail")
+	}
+
+	expected := map[string]string{"new": "new", "a": "a2", "c": "c", "c2": "c"}
+	for key, contents := range expected {
+		blob, err := sisInstance.Read(ctx, pk.New(key))
+		if err != nil || string(blob) != contents {
+			t.Fatalf("unexpected contents of '%s': %q %v", key, blob, err)
+		}
+	}
+	exists, err := sisInstance.Exists(ctx, pk.New("b"))
+	if err != nil || exists {
+		t.Fatalf("expected 'b' to be deleted")
+	}
+
+	problems, err := sisInstance.Check(ctx)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("unexpected check result: %v %v", problems, err)
+	}
+}
+
+func TestBatchFailureAndAbort(t *testing.T) {
+	ctx := t.Context()
+	root := t.TempDir()
+	crudOs, err := crudos.New(root)
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+
+	err = sisInstance.Create(ctx, pk.New("a"), []byte("a"))
+	if err != nil {
+		t.Fatalf("error creating 'a': %s", err.Error())
+	}
+
+	// the create of an existing key fails the whole batch
+	batch := sisInstance.Batch()
+	_ = batch.Create(ctx, pk.New("fresh"), []byte("fresh"))
+	_ = batch.Create(ctx, pk.New("a"), []byte("other"))
+	if batch.Commit(ctx) == nil {
+		t.Fatalf("expected commit to fail")
+	}
+	exists, err := sisInstance.Exists(ctx, pk.New("fresh"))
+	if err != nil || exists {
+		t.Fatalf("expected failed batch to leave no keys")
+	}
+
+	batch = sisInstance.Batch()
+	_ = batch.Create(ctx, pk.New("staged"), []byte("staged"))
+	err = batch.Abort()
+	if err != nil {
+		t.Fatalf("error aborting batch: %s", err.Error())
+	}
+	entries, _ := os.ReadDir(filepath.Join(root, "sys", "staging"))
+	if len(entries) != 0 {
+		t.Fatalf("expected abort to drop staged blobs, found %d", len(entries))
+	}
+
+	blob, err := sisInstance.Read(ctx, pk.New("a"))
+	if err != nil || string(blob) != "a" {
+		t.Fatalf("unexpected contents of 'a': %q %v", blob, err)
+	}
+}
+
+func TestBatchCrashRecovery(t *testing.T) {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	armed := false
+	faulty := faultyCrud{Crud: crudOs, fail: func(ctx context.Context, op string, key []string) error {
+		// the first header of the intent is written, its reference on the blob metadata is not
+		if !armed || op != "update" || key[len(key)-1] != "metadata" {
+			return nil
+		}
+		armed = false
+		return errCrash
+	}}
+	sisInstance, err := sis.New(ctx, sha256.New(), faulty)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+	for _, key := range []string{"a", "b"} {
+		err = sisInstance.Create(ctx, pk.New(key), []byte(key))
+		if err != nil {
+			t.Fatalf("error creating '%s': %s", key, err.Error())
+		}
+	}
+
+	batch := sisInstance.Batch()
+	for _, err := range []error{
+		batch.Create(ctx, pk.New("new"), []byte("new")),
+		batch.Copy(pk.New("a"), pk.New("a2")),
+		batch.Delete(pk.New("b")),
+	} {
+		if err != nil {
+			t.Fatalf("error adding to batch: %s", err.Error())
+		}
+	}
+	armed = true
+	err = batch.Commit(ctx)
+	if !errors.Is(err, errCrash) {
+		t.Fatalf("expected the commit to crash, got %v", err)
+	}
+
+	sisInstance, err = sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error reopening sis instance: %s", err.Error())
+	}
+	exists, err := sisInstance.Exists(ctx, pk.New("b"))
+	if err != nil || exists {
+		t.Fatalf("expected 'b' to be deleted after recovery")
+	}
+	for key, expected := range map[string]string{"new": "new", "a2": "a"} {
+		blob, err := sisInstance.Read(ctx, pk.New(key))
+		if err != nil || string(blob) != expected {
+			t.Fatalf("expected '%s' in '%s' after recovery, got '%s' (%v)", expected, key, blob, err)
+		}
+	}
+	problems, err := sisInstance.Check(ctx)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v (%v)", problems, err)
+	}
+}
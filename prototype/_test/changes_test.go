@@ -0,0 +1,169 @@
+package sis_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"sis"
+	"sis/internal/crud/crudos"
+	"sis/internal/pk"
+	"testing"
+	"time"
+)
+
+func TestChangeFeed(t *testing.T) {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha256.New(), crudOs, sis.WithChangeFeed(sis.ChangeFeedOptions{MaxEvents: 3}))
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+
+	ops := []func() error{
+		func() error { return sisInstance.Create(ctx, pk.New("a"), []byte("one")) },
+		func() error { return sisInstance.Create(ctx, pk.New("b"), []byte("one")) },
+		func() error { return sisInstance.Update(ctx, pk.New("a"), []byte("two")) },
+		func() error { return sisInstance.Delete(ctx, pk.New("b")) },
+	}
+	for _, op := range ops {
+		err = op()
+		if err != nil {
+			t.Fatalf("error running operation: %s", err.Error())
+		}
+	}
+
+	for _, err := range sisInstance.Changes(ctx, 0) {
+		if !errors.Is(err, sis.ErrChangesDropped) {
+			t.Fatalf("expected the first change to be dropped, got %v", err)
+		}
+	}
+
+	var changes []sis.Change
+	for change, err := range sisInstance.Changes(ctx, 1) {
+		if err != nil {
+			t.Fatalf("error reading changes: %s", err.Error())
+		}
+		changes = append(changes, change)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %+v", changes)
+	}
+	created, updated, deleted := changes[0], changes[1], changes[2]
+	if created.Seq != 2 || created.Kind != sis.ChangeCreate || created.NewBlob || created.Size != 3 {
+		t.Fatalf("expected a deduplicated create of 3B, got %+v", created)
+	}
+	if updated.Kind != sis.ChangeUpdate || updated.OldDigest != created.NewDigest || !updated.NewBlob {
+		t.Fatalf("expected an update from %s to a new blob, got %+v", created.NewDigest, updated)
+	}
+	if deleted.Kind != sis.ChangeDelete || deleted.OldDigest != created.NewDigest || deleted.Size != 3 {
+		t.Fatalf("expected a delete of %s, got %+v", created.NewDigest, deleted)
+	}
+
+	// numbering survives a reopen
+	reopened, err := sis.New(ctx, sha256.New(), crudOs, sis.WithChangeFeed(sis.ChangeFeedOptions{}))
+	if err != nil {
+		t.Fatalf("error reopening sis instance: %s", err.Error())
+	}
+	subCtx, cancel := context.WithCancel(ctx)
+	sub, err := reopened.Subscribe(subCtx, deleted.Seq, 0)
+	if err != nil {
+		t.Fatalf("error subscribing: %s", err.Error())
+	}
+	err = reopened.Create(ctx, pk.New("c"), []byte("three"))
+	if err != nil {
+		t.Fatalf("error creating 'c': %s", err.Error())
+	}
+	select {
+	case change := <-sub.C:
+		if change.Seq != 5 || change.PK.Path() != "c" {
+			t.Fatalf("expected change 5 on 'c', got %+v", change)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the subscription")
+	}
+	cancel()
+	if sub.Err() != nil {
+		t.Fatalf("expected a cancelled subscription to end cleanly, got %s", sub.Err())
+	}
+
+	for range 2 {
+		err = reopened.Update(ctx, pk.New("c"), []byte(time.Now().String()))
+		if err != nil {
+			t.Fatalf("error updating 'c': %s", err.Error())
+		}
+	}
+	removed, err := reopened.CompactChanges(ctx)
+	if err != nil {
+		t.Fatalf("error compacting changes: %s", err.Error())
+	}
+	// the create of 'b' is superseded by its delete, the create and first update of 'c' by the last update
+	if removed != 3 {
+		t.Fatalf("expected 3 changes to be compacted, got %d", removed)
+	}
+}
+
+func TestChangeCrashRecovery(t *testing.T) {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	failChange := false
+	faulty := faultyCrud{Crud: crudOs, fail: func(ctx context.Context, op string, key []string) error {
+		// sys/changes/<seq>, the pending change under sys/changes/pending is written
+		if failChange && op == "create" && len(key) == 3 && key[1] == "changes" {
+			return errCrash
+		}
+		return nil
+	}}
+	sisInstance, err := sis.New(ctx, sha256.New(), faulty, sis.WithChangeFeed(sis.ChangeFeedOptions{}))
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+
+	// the change of 'a' is not written, but 'a' is
+	failChange = true
+	err = sisInstance.Create(ctx, pk.New("a"), []byte("one"))
+	if err != nil {
+		t.Fatalf("expected the create to succeed without its change, got %v", err)
+	}
+	failChange = false
+
+	// every write fails from the blob of 'b' on, rollbacks included
+	crashed := false
+	faulty.fail = func(ctx context.Context, op string, key []string) error {
+		if op == "create" && key[len(key)-1] == "blob" {
+			crashed = true
+		}
+		if crashed {
+			return errCrash
+		}
+		return nil
+	}
+	sisInstance, err = sis.New(ctx, sha256.New(), faulty, sis.WithChangeFeed(sis.ChangeFeedOptions{}))
+	if err != nil {
+		t.Fatalf("error reopening sis instance: %s", err.Error())
+	}
+	err = sisInstance.Create(ctx, pk.New("b"), []byte("two"))
+	if !errors.Is(err, errCrash) {
+		t.Fatalf("expected the create to crash, got %v", err)
+	}
+
+	sisInstance, err = sis.New(ctx, sha256.New(), crudOs, sis.WithChangeFeed(sis.ChangeFeedOptions{}))
+	if err != nil {
+		t.Fatalf("error reopening sis instance: %s", err.Error())
+	}
+	var changes []sis.Change
+	for change, err := range sisInstance.Changes(ctx, 0) {
+		if err != nil {
+			t.Fatalf("error reading changes: %s", err.Error())
+		}
+		changes = append(changes, change)
+	}
+	if len(changes) != 1 || changes[0].PK.Path() != "a" || changes[0].Kind != sis.ChangeCreate || changes[0].Size != 3 {
+		t.Fatalf("expected only the create of 'a', got %+v", changes)
+	}
+}
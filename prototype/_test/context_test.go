@@ -0,0 +1,118 @@
+package sis_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sis"
+	"sis/internal/crud/crudos"
+	"sis/internal/pk"
+	"strings"
+	"testing"
+)
+
+// cancellingReader cancels its context after the first read, in the middle of a write
+type cancellingReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (c *cancellingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p[:min(len(p), 1024)])
+	c.cancel()
+	return n, err
+}
+
+func TestCancellation(t *testing.T) {
+	ctx := t.Context()
+	root := t.TempDir()
+	crudOs, err := crudos.New(root)
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = sisInstance.Create(cancelled, pk.New("early"), []byte("contents"))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	reader := &cancellingReader{r: strings.NewReader(strings.Repeat("x", 64*1024)), cancel: cancel}
+	err = sisInstance.CreateFrom(streamCtx, pk.New("streamed"), reader)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	for _, key := range []string{"early", "streamed"} {
+		exists, err := sisInstance.Exists(ctx, pk.New(key))
+		if err != nil || exists {
+			t.Fatalf("expected cancelled create of '%s' to leave no key", key)
+		}
+	}
+	entries, _ := os.ReadDir(filepath.Join(root, "sys", "staging"))
+	if len(entries) != 0 {
+		t.Fatalf("expected cancelled create to leave no staged blob, found %d", len(entries))
+	}
+	usage, err := sisInstance.Usage(ctx)
+	if err != nil || usage.Blobs != 0 {
+		t.Fatalf("expected no blobs, got %+v %v", usage, err)
+	}
+	problems, err := sisInstance.Check(ctx)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("unexpected check result: %v %v", problems, err)
+	}
+}
+
+func TestCancellationBetweenBlobAndMetadata(t *testing.T) {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	var cancel context.CancelFunc
+	faulty := faultyCrud{Crud: crudOs, fail: func(ctx context.Context, op string, key []string) error {
+		// the blob is written, its metadata is not
+		if cancel == nil || op != "create" || key[len(key)-1] != "metadata" {
+			return nil
+		}
+		cancel()
+		return ctx.Err()
+	}}
+	sisInstance, err := sis.New(ctx, sha256.New(), faulty)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+
+	cancelCtx, cancelFunc := context.WithCancel(ctx)
+	cancel = cancelFunc
+	err = sisInstance.Create(cancelCtx, pk.New("a"), []byte("contents"))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the create to be cancelled, got %v", err)
+	}
+	cancel = nil
+
+	// the cancelled create left nothing behind, so the same contents can be stored again
+	for _, key := range []string{"a", "b"} {
+		err = sisInstance.Create(ctx, pk.New(key), []byte("contents"))
+		if err != nil {
+			t.Fatalf("error creating '%s': %s", key, err.Error())
+		}
+	}
+	err = sisInstance.Delete(ctx, pk.New("a"))
+	if err != nil {
+		t.Fatalf("error deleting 'a': %s", err.Error())
+	}
+	problems, err := sisInstance.Check(ctx)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v (%v)", problems, err)
+	}
+}
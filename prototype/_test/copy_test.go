@@ -0,0 +1,192 @@
+package sis_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sis"
+	"sis/internal/crud"
+	"sis/internal/crud/crudos"
+	"sis/internal/pk"
+	"testing"
+)
+
+func TestCopyAndMove(t *testing.T) {
+	ctx := t.Context()
+	sisInstance := newTestSIS(t)
+
+	for _, key := range []string{"src/a", "src/dir/b", "other"} {
+		err := sisInstance.Create(ctx, pk.New(key), []byte(key))
+		if err != nil {
+			t.Fatalf("error creating '%s': %s", key, err.Error())
+		}
+	}
+	err := sisInstance.SetMetadata(ctx, pk.New("src/a"), map[string]any{"owner": "ops"})
+	if err != nil {
+		t.Fatalf("error setting metadata: %s", err.Error())
+	}
+
+	err = sisInstance.Copy(ctx, pk.New("other"), pk.New("copy"))
+	if err != nil {
+		t.Fatalf("error copying: %s", err.Error())
+	}
+	err = sisInstance.Move(ctx, pk.New("copy"), pk.New("moved"))
+	if err != nil {
+		t.Fatalf("error moving: %s", err.Error())
+	}
+	err = sisInstance.Copy(ctx, pk.New("other"), pk.New("moved"))
+	if err == nil {
+		t.Fatalf("expected copy onto an existing key to fail")
+	}
+	// refused before the intent is journaled, like creates of the same key
+	err = sisInstance.Copy(ctx, pk.New("other"), pk.PK{"bad", "data-header"})
+	if !errors.Is(err, sis.ErrInvalidKey) {
+		t.Fatalf("expected a copy to an invalid key to be refused, got %v", err)
+	}
+
+	n, err := sisInstance.MovePrefix(ctx, pk.New("src"), pk.New("dst"))
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 keys moved, got %d (%v)", n, err)
+	}
+	_, err = sisInstance.CopyPrefix(ctx, pk.New("dst"), pk.New("dst/nested"))
+	if err == nil {
+		t.Fatalf("expected overlapping prefixes to be refused")
+	}
+
+	info, err := sisInstance.Stat(ctx, pk.New("dst/a"))
+	if err != nil || info.Metadata["owner"] != "ops" {
+		t.Fatalf("expected metadata to move along, got %+v (%v)", info, err)
+	}
+	blob, err := sisInstance.Read(ctx, pk.New("dst/dir/b"))
+	if err != nil || string(blob) != "src/dir/b" {
+		t.Fatalf("expected 'src/dir/b' in 'dst/dir/b', got '%s' (%v)", blob, err)
+	}
+	exists, err := sisInstance.Exists(ctx, pk.New("src/a"))
+	if err != nil || exists {
+		t.Fatalf("expected 'src/a' to be gone after the move")
+	}
+
+	// no blob was written for copies and moves
+	usage, err := sisInstance.Usage(ctx)
+	if err != nil || usage.Blobs != 3 || usage.Keys != 4 {
+		t.Fatalf("expected 3 blobs for 4 keys, got %+v (%v)", usage, err)
+	}
+	problems, err := sisInstance.Check(ctx)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v (%v)", problems, err)
+	}
+}
+
+func TestMoveRecovery(t *testing.T) {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+	err = sisInstance.Create(ctx, pk.New("a"), []byte("contents"))
+	if err != nil {
+		t.Fatalf("error creating 'a': %s", err.Error())
+	}
+	info, err := sisInstance.Stat(ctx, pk.New("a"))
+	if err != nil {
+		t.Fatalf("error on stat: %s", err.Error())
+	}
+
+	// a move interrupted right after its intent was journaled is completed on the next open
+	intent := fmt.Sprintf(`{"id":"interrupted","ops":[{"kind":"put","pk":["b"],"digest":"%s"},{"kind":"delete","pk":["a"]}]}`, info.Digest)
+	err = crudOs.Create(ctx, pk.New("sys/journal/interrupted"), []byte(intent))
+	if err != nil {
+		t.Fatalf("error writing intent: %s", err.Error())
+	}
+
+	sisInstance, err = sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error reopening sis instance: %s", err.Error())
+	}
+	keys, err := sisInstance.List(ctx, pk.PK{})
+	if err != nil || len(keys) != 1 || keys[0].Path() != "b" {
+		t.Fatalf("expected only 'b' after recovery, got %v (%v)", keys, err)
+	}
+	problems, err := sisInstance.Check(ctx)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v (%v)", problems, err)
+	}
+}
+
+var errCrash = errors.New("crash")
+
+// faultyCrud fails the writes fail returns an error for, to stop an operation halfway like a crash
+type faultyCrud struct {
+	crud.Crud
+	fail func(ctx context.Context, op string, key []string) error
+}
+
+func (c faultyCrud) Create(ctx context.Context, key []string, blob []byte) error {
+	if err := c.fail(ctx, "create", key); err != nil {
+		return err
+	}
+	return c.Crud.Create(ctx, key, blob)
+}
+
+func (c faultyCrud) Update(ctx context.Context, key []string, blob []byte) error {
+	if err := c.fail(ctx, "update", key); err != nil {
+		return err
+	}
+	return c.Crud.Update(ctx, key, blob)
+}
+
+func (c faultyCrud) Delete(ctx context.Context, key []string) error {
+	if err := c.fail(ctx, "delete", key); err != nil {
+		return err
+	}
+	return c.Crud.Delete(ctx, key)
+}
+
+func TestMoveCrashRecovery(t *testing.T) {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	armed := false
+	faulty := faultyCrud{Crud: crudOs, fail: func(ctx context.Context, op string, key []string) error {
+		// the header of 'b' is written, its reference on the blob metadata is not
+		if !armed || op != "update" || key[len(key)-1] != "metadata" {
+			return nil
+		}
+		armed = false
+		return errCrash
+	}}
+	sisInstance, err := sis.New(ctx, sha256.New(), faulty)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+	err = sisInstance.Create(ctx, pk.New("a"), []byte("contents"))
+	if err != nil {
+		t.Fatalf("error creating 'a': %s", err.Error())
+	}
+
+	armed = true
+	err = sisInstance.Move(ctx, pk.New("a"), pk.New("b"))
+	if !errors.Is(err, errCrash) {
+		t.Fatalf("expected the move to crash, got %v", err)
+	}
+
+	sisInstance, err = sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error reopening sis instance: %s", err.Error())
+	}
+	blob, err := sisInstance.Read(ctx, pk.New("b"))
+	if err != nil || string(blob) != "contents" {
+		t.Fatalf("expected 'contents' in 'b' after recovery, got '%s' (%v)", blob, err)
+	}
+	problems, err := sisInstance.Check(ctx)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v (%v)", problems, err)
+	}
+}
@@ -0,0 +1,87 @@
+package sis_test
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sis"
+	"sis/internal/crud/crudos"
+	"sis/internal/pk"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	ctx := t.Context()
+	root := t.TempDir()
+	crudOs, err := crudos.New(root)
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+
+	err = sisInstance.Create(ctx, pk.New("a"), []byte("a"))
+	if err != nil {
+		t.Fatalf("error creating 'a': %s", err.Error())
+	}
+
+	err = sisInstance.Create(ctx, pk.New("a"), []byte("other"))
+	var keyErr *sis.KeyError
+	if !errors.Is(err, sis.ErrAlreadyExists) || !errors.As(err, &keyErr) || keyErr.Op != "create" {
+		t.Fatalf("expected a create KeyError wrapping ErrAlreadyExists, got %v", err)
+	}
+
+	_, err = sisInstance.Read(ctx, pk.New("missing"))
+	if !errors.Is(err, sis.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound on read, got %v", err)
+	}
+	for name, err := range map[string]error{
+		"update": sisInstance.Update(ctx, pk.New("missing"), []byte("x")),
+		"delete": sisInstance.Delete(ctx, pk.New("missing")),
+		"copy":   sisInstance.Copy(ctx, pk.New("missing"), pk.New("b")),
+	} {
+		if !errors.Is(err, sis.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound on %s, got %v", name, err)
+		}
+	}
+
+	err = sisInstance.Create(ctx, pk.PK{"..", "escape"}, []byte("x"))
+	if !errors.Is(err, sis.ErrInvalidKey) {
+		t.Fatalf("expected ErrInvalidKey, got %v", err)
+	}
+
+	err = sis.Chain(&sisInstance, sis.ReadOnly()).Delete(ctx, pk.New("a"))
+	if !errors.Is(err, sis.ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+
+	batch := sisInstance.Batch()
+	_ = batch.Delete(pk.New("a"))
+	if err := batch.Delete(pk.New("a")); !errors.Is(err, sis.ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	batch.Abort()
+
+	_, err = crudOs.Read(ctx, []string{"missing"})
+	if !errors.Is(err, sis.ErrNotFound) || !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected crudos to map fs.ErrNotExist to ErrNotFound, got %v", err)
+	}
+
+	// a key whose blob is gone is corrupted, not missing
+	info, err := sisInstance.Stat(ctx, pk.New("a"))
+	if err != nil {
+		t.Fatalf("error reading info of 'a': %s", err.Error())
+	}
+	err = os.Remove(filepath.Join(root, "sys", "data", info.Digest, "blob"))
+	if err != nil {
+		t.Fatalf("error removing blob: %s", err.Error())
+	}
+	_, err = sisInstance.Read(ctx, pk.New("a"))
+	if !errors.Is(err, sis.ErrCorrupted) || errors.Is(err, sis.ErrNotFound) {
+		t.Fatalf("expected ErrCorrupted alone, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package sis_test
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sis"
+	"sis/internal/crud/crudos"
+	"sis/internal/pk"
+	"slices"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+
+	keys := []string{
+		"images/2024/01/thumb-a.jpg",
+		"images/2024/01/full-a.jpg",
+		"images/2024/02/thumb-b.jpg",
+		"images/2023/12/thumb-c.jpg",
+		"images/thumb-d.jpg",
+		"docs/readme.md",
+	}
+	for _, key := range keys {
+		parsed, _ := pk.Parse(key)
+		err = sisInstance.Create(ctx, parsed, []byte(key))
+		if err != nil {
+			t.Fatalf("error creating '%s': %s", key, err.Error())
+		}
+	}
+
+	cases := map[string][]string{
+		"images/2024/*/thumb-*.jpg": {"images/2024/01/thumb-a.jpg", "images/2024/02/thumb-b.jpg"},
+		"images/**/thumb-*.jpg":     {"images/2023/12/thumb-c.jpg", "images/2024/01/thumb-a.jpg", "images/2024/02/thumb-b.jpg", "images/thumb-d.jpg"},
+		"**/*.md":                   {"docs/readme.md"},
+		"docs/readme.md":            {"docs/readme.md"},
+		"images/2025/**":            nil,
+	}
+	for pattern, expected := range cases {
+		infos, err := sisInstance.Find(ctx, pattern)
+		if err != nil {
+			t.Fatalf("error finding '%s': %s", pattern, err.Error())
+		}
+		var found []string
+		for _, info := range infos {
+			found = append(found, info.PK.Format())
+			if int(info.Size) != len(info.PK.Format()) || info.Digest == "" {
+				t.Fatalf("unexpected info %+v", info)
+			}
+		}
+		if !slices.Equal(found, expected) {
+			t.Fatalf("'%s': expected %v, found %v", pattern, expected, found)
+		}
+	}
+
+	_, err = sisInstance.Find(ctx, "a/../b")
+	if !errors.Is(err, sis.ErrInvalidKey) {
+		t.Fatalf("expected ErrInvalidKey, got %v", err)
+	}
+}
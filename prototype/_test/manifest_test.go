@@ -0,0 +1,125 @@
+package sis_test
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"sis"
+	"sis/internal/crud/crudos"
+	sishash "sis/internal/hash"
+	"sis/internal/pk"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+
+	sisInstance, err := sis.New(ctx, sha1.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+
+	manifest, err := sisInstance.Manifest(ctx)
+	if err != nil {
+		t.Fatalf("error reading manifest: %s", err.Error())
+	}
+	if manifest.FormatVersion != sis.FormatVersion || manifest.Hash.Algorithm != "sha1" {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+
+	_, err = sis.New(ctx, sha256.New(), crudOs)
+	if err == nil {
+		t.Fatalf("expected store written with sha1 to refuse sha256")
+	}
+
+	err = sisInstance.Create(ctx, pk.New("hello/world"), []byte("hello"))
+	if err != nil {
+		t.Fatalf("error creating 'hello/world': %s", err.Error())
+	}
+
+	err = sisInstance.NewRehasher(sha256.New(), nil).Run(ctx)
+	if err != nil {
+		t.Fatalf("error rehashing: %s", err.Error())
+	}
+
+	reopened, err := sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error reopening store with its new hash: %s", err.Error())
+	}
+
+	blob, err := reopened.Read(ctx, pk.New("hello/world"))
+	if err != nil {
+		t.Fatalf("error reading 'hello/world': %s", err.Error())
+	}
+	if string(blob) != "hello" {
+		t.Fatalf("'hello/world' has '%s', expected 'hello'", blob)
+	}
+}
+
+func TestManifestLegacyStore(t *testing.T) {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+
+	// a store written before the manifest existed
+	err = crudOs.Create(ctx, pk.New("user/data/hello/data-header"), []byte(`{"pk":["hello"],"digest":"aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"}`))
+	if err != nil {
+		t.Fatalf("error creating legacy header: %s", err.Error())
+	}
+
+	sisInstance, err := sis.New(ctx, sha1.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error opening legacy store: %s", err.Error())
+	}
+
+	manifest, err := sisInstance.Manifest(ctx)
+	if err != nil {
+		t.Fatalf("error reading manifest: %s", err.Error())
+	}
+	if manifest.FormatVersion != sis.FormatVersion {
+		t.Fatalf("expected legacy store to be upgraded to version %d, got %d", sis.FormatVersion, manifest.FormatVersion)
+	}
+}
+
+func TestManifestNewerVersion(t *testing.T) {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+
+	// a version 2 store, with keys written as arrays
+	err = crudOs.Create(ctx, pk.New("sys/manifest"), []byte(`{"formatVersion":2,"storeId":"x","hash":{"algorithm":"sha1","size":20,"probe":"`+sishash.Probe(sha1.New())+`"},"chunking":"none"}`))
+	if err != nil {
+		t.Fatalf("error creating manifest: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha1.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error opening version 2 store: %s", err.Error())
+	}
+	manifest, err := sisInstance.Manifest(ctx)
+	if err != nil || manifest.FormatVersion != 3 {
+		t.Fatalf("expected the store to be upgraded to version 3, got %+v (%v)", manifest, err)
+	}
+
+	// a binary of version 2 refuses the store the same way this one refuses a newer version
+	manifest.FormatVersion = sis.FormatVersion + 1
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("error writing manifest: %s", err.Error())
+	}
+	err = crudOs.Update(ctx, pk.New("sys/manifest"), manifestBytes)
+	if err != nil {
+		t.Fatalf("error updating manifest: %s", err.Error())
+	}
+	_, err = sis.New(ctx, sha1.New(), crudOs)
+	if err == nil {
+		t.Fatalf("expected a store of a newer format version to be refused")
+	}
+}
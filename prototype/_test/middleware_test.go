@@ -0,0 +1,87 @@
+package sis_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"log/slog"
+	"sis"
+	"sis/internal/crud/crudos"
+	"sis/internal/pk"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+
+	var logs bytes.Buffer
+	counters := sis.NewCounters()
+	store := sis.Chain(&sisInstance, sis.Logging(slog.New(slog.NewTextHandler(&logs, nil))), counters.Middleware())
+
+	err = store.Create(ctx, pk.New("hello/world"), []byte("hello"))
+	if err != nil {
+		t.Fatalf("error creating 'hello/world': %s", err.Error())
+	}
+	_, err = store.Read(ctx, pk.New("hello/world"))
+	if err != nil {
+		t.Fatalf("error reading 'hello/world': %s", err.Error())
+	}
+	_, err = store.Read(ctx, pk.New("missing"))
+	if err == nil {
+		t.Fatalf("expected error reading missing key")
+	}
+
+	snapshot := counters.Snapshot()
+	if snapshot[sis.OpCreate].Calls != 1 || snapshot[sis.OpCreate].Bytes != 5 {
+		t.Fatalf("unexpected create counters %+v", snapshot[sis.OpCreate])
+	}
+	if snapshot[sis.OpRead].Calls != 2 || snapshot[sis.OpRead].Errors != 1 || snapshot[sis.OpRead].Bytes != 5 {
+		t.Fatalf("unexpected read counters %+v", snapshot[sis.OpRead])
+	}
+	if !strings.Contains(logs.String(), "op=create") || !strings.Contains(logs.String(), "level=ERROR") {
+		t.Fatalf("expected create and failed read to be logged, got:\n%s", logs.String())
+	}
+
+	readOnly := sis.Chain(&sisInstance, sis.ReadOnly())
+	err = readOnly.Delete(ctx, pk.New("hello/world"))
+	if err == nil {
+		t.Fatalf("expected read-only store to refuse delete")
+	}
+	_, err = readOnly.Read(ctx, pk.New("hello/world"))
+	if err != nil {
+		t.Fatalf("error reading through read-only store: %s", err.Error())
+	}
+
+	batch := readOnly.Batch()
+	writes := map[string]func() error{
+		"batch create": func() error { return batch.Create(ctx, pk.New("batched"), []byte("batched")) },
+		"batch delete": func() error { return batch.Delete(pk.New("hello/world")) },
+		"copy":         func() error { return readOnly.Copy(ctx, pk.New("hello/world"), pk.New("copy")) },
+		"move":         func() error { return readOnly.Move(ctx, pk.New("hello/world"), pk.New("moved")) },
+		"batch":        func() error { return batch.Commit(ctx) },
+		"snapshot":     func() error { _, err := readOnly.Snapshot(ctx, "nightly"); return err },
+		"prune":        func() error { _, err := readOnly.PruneVersions(ctx); return err },
+	}
+	for name, write := range writes {
+		if !errors.Is(write(), sis.ErrReadOnly) {
+			t.Fatalf("expected read-only store to refuse %s", name)
+		}
+	}
+	keys, err := sisInstance.List(ctx, pk.PK{})
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("expected the store to be left as it was, got %v (%v)", keys, err)
+	}
+	staged, err := crudOs.List(ctx, pk.New("sys/staging"))
+	if err != nil || len(staged) != 0 {
+		t.Fatalf("expected nothing to be staged, got %v (%v)", staged, err)
+	}
+}
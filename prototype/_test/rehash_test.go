@@ -0,0 +1,187 @@
+package sis_test
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"sis"
+	"sis/internal/crud/crudos"
+	"sis/internal/pk"
+	"testing"
+)
+
+func TestRehash(t *testing.T) {
+	ctx := t.Context()
+	h := sha1.New()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, h, crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+
+	contents := map[string][]byte{
+		"hello/world":  []byte("hello"),
+		"hello/world2": []byte("hello"),
+		"bye/world":    []byte("byebye"),
+	}
+	for key, content := range contents {
+		err = sisInstance.Create(ctx, pk.New(key), content)
+		if err != nil {
+			t.Fatalf("error creating '%s': %s", key, err.Error())
+		}
+	}
+
+	var lastProgress sis.RehashProgress
+	rehasher := sisInstance.NewRehasher(sha256.New(), func(p sis.RehashProgress) {
+		lastProgress = p
+	})
+	err = rehasher.Run(ctx)
+	if err != nil {
+		t.Fatalf("error rehashing: %s", err.Error())
+	}
+
+	if lastProgress.Done != 2 || lastProgress.Total != 2 {
+		t.Fatalf("expected 2/2 digests migrated, got %d/%d", lastProgress.Done, lastProgress.Total)
+	}
+	if len(lastProgress.NewDigest) != 64 {
+		t.Fatalf("expected a sha256 digest, got '%s'", lastProgress.NewDigest)
+	}
+
+	for key, content := range contents {
+		blob, err := sisInstance.Read(ctx, pk.New(key))
+		if err != nil {
+			t.Fatalf("error reading '%s': %s", key, err.Error())
+		}
+		if string(blob) != string(content) {
+			t.Fatalf("'%s' has '%s', expected '%s'", key, blob, content)
+		}
+	}
+
+	for key := range contents {
+		err = sisInstance.Delete(ctx, pk.New(key))
+		if err != nil {
+			t.Fatalf("error deleting '%s': %s", key, err.Error())
+		}
+	}
+}
+
+func TestRehashResume(t *testing.T) {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha1.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		err = sisInstance.Create(ctx, pk.New(key), []byte(key))
+		if err != nil {
+			t.Fatalf("error creating '%s': %s", key, err.Error())
+		}
+	}
+	// copies of the instance switch hash along with it
+	copied := sisInstance
+
+	runCtx, cancel := context.WithCancel(ctx)
+	err = sisInstance.NewRehasher(sha256.New(), func(p sis.RehashProgress) {
+		cancel()
+	}).Run(runCtx)
+	if err == nil {
+		t.Fatalf("expected the cancelled rehash to fail")
+	}
+
+	err = copied.Create(ctx, pk.New("new"), []byte("new"))
+	if err != nil {
+		t.Fatalf("error creating 'new': %s", err.Error())
+	}
+	info, err := copied.Stat(ctx, pk.New("new"))
+	if err != nil || len(info.Digest) != 64 {
+		t.Fatalf("expected a sha256 digest during the migration, got %+v (%v)", info, err)
+	}
+
+	// while the migration is unfinished, the store only opens with the new hash
+	_, err = sis.New(ctx, sha1.New(), crudOs)
+	if err == nil {
+		t.Fatalf("expected the old hash to be refused during the migration")
+	}
+	sisInstance, err = sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error reopening with the new hash: %s", err.Error())
+	}
+
+	err = sisInstance.NewRehasher(md5.New(), nil).Run(ctx)
+	if err == nil {
+		t.Fatalf("expected resuming with another hash to be refused")
+	}
+	err = sisInstance.NewRehasher(sha256.New(), nil).Run(ctx)
+	if err != nil {
+		t.Fatalf("error resuming rehash: %s", err.Error())
+	}
+	for _, key := range []string{"a", "b", "c", "new"} {
+		blob, err := sisInstance.Read(ctx, pk.New(key))
+		if err != nil || string(blob) != key {
+			t.Fatalf("expected '%s' in '%s', got '%s' (%v)", key, key, blob, err)
+		}
+	}
+}
+
+func TestCheckDuringRehash(t *testing.T) {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha1.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		err = sisInstance.Create(ctx, pk.New(key), []byte(key))
+		if err != nil {
+			t.Fatalf("error creating '%s': %s", key, err.Error())
+		}
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	err = sisInstance.NewRehasher(sha256.New(), func(p sis.RehashProgress) {
+		cancel()
+	}).Run(runCtx)
+	if err == nil {
+		t.Fatalf("expected the cancelled rehash to fail")
+	}
+	sisInstance, err = sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error reopening with the new hash: %s", err.Error())
+	}
+
+	// digests of both hashes are verified, each with its own
+	problems, err := sisInstance.Check(ctx)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("expected no problems partway through the rehash, got %v (%v)", problems, err)
+	}
+
+	var pending string
+	for _, key := range []string{"a", "b", "c"} {
+		info, err := sisInstance.Stat(ctx, pk.New(key))
+		if err != nil {
+			t.Fatalf("error on stat: %s", err.Error())
+		}
+		if len(info.Digest) == 40 {
+			pending = info.Digest
+		}
+	}
+	err = crudOs.Update(ctx, pk.New("sys/data/"+pending+"/blob"), []byte("corrupted"))
+	if err != nil {
+		t.Fatalf("error corrupting blob: %s", err.Error())
+	}
+	problems, err = sisInstance.Check(ctx)
+	if err != nil || len(problems) != 1 || problems[0].Kind != sis.CorruptedBlob || problems[0].Digest != pending {
+		t.Fatalf("expected the corrupted blob to be reported, got %v (%v)", problems, err)
+	}
+}
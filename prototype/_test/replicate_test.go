@@ -0,0 +1,131 @@
+package sis_test
+
+import (
+	"crypto/sha256"
+	"sis"
+	"sis/internal/crud/crudos"
+	"sis/internal/pk"
+	"testing"
+)
+
+func newTestSIS(t *testing.T, options ...sis.Option) *sis.SIS {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha256.New(), crudOs, options...)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+	return &sisInstance
+}
+
+func TestReplicate(t *testing.T) {
+	ctx := t.Context()
+	src, dst := newTestSIS(t, sis.WithChangeFeed(sis.ChangeFeedOptions{})), newTestSIS(t)
+
+	for _, key := range []string{"a/1", "a/2", "b/1"} {
+		err := src.Create(ctx, pk.New(key), []byte("shared"))
+		if err != nil {
+			t.Fatalf("error creating '%s': %s", key, err.Error())
+		}
+	}
+	err := src.SetMetadata(ctx, pk.New("b/1"), map[string]any{"owner": "ops"})
+	if err != nil {
+		t.Fatalf("error setting metadata: %s", err.Error())
+	}
+	err = dst.Create(ctx, pk.New("stale"), []byte("stale"))
+	if err != nil {
+		t.Fatalf("error creating 'stale': %s", err.Error())
+	}
+
+	res, err := sis.Replicate(ctx, src, dst, sis.ReplicationOptions{})
+	if err != nil {
+		t.Fatalf("error replicating: %s", err.Error())
+	}
+	if res.Keys != 3 || res.Deleted != 1 || res.Blobs != 1 || res.Bytes != 6 {
+		t.Fatalf("expected 3 keys, 1 deletion and 1 blob of 6B, got %+v", res)
+	}
+
+	info, err := dst.Stat(ctx, pk.New("b/1"))
+	if err != nil || info.Metadata["owner"] != "ops" {
+		t.Fatalf("expected metadata to be replicated, got %+v (%v)", info, err)
+	}
+
+	// incremental replication only touches what changed since the cursor, so a key written to dst
+	// alone is left as it is
+	err = dst.Create(ctx, pk.New("dst-only"), []byte("dst"))
+	if err != nil {
+		t.Fatalf("error creating 'dst-only': %s", err.Error())
+	}
+	err = src.SetMetadata(ctx, pk.New("a/2"), map[string]any{"owner": "dev"})
+	if err != nil {
+		t.Fatalf("error setting metadata: %s", err.Error())
+	}
+	err = src.Update(ctx, pk.New("a/2"), []byte("changed"))
+	if err != nil {
+		t.Fatalf("error updating 'a/2': %s", err.Error())
+	}
+	err = src.Delete(ctx, pk.New("a/1"))
+	if err != nil {
+		t.Fatalf("error deleting 'a/1': %s", err.Error())
+	}
+
+	res, err = sis.Replicate(ctx, src, dst, sis.ReplicationOptions{Cursor: &res.Cursor})
+	if err != nil {
+		t.Fatalf("error replicating incrementally: %s", err.Error())
+	}
+	if res.Keys != 1 || res.Deleted != 1 || res.Blobs != 1 {
+		t.Fatalf("expected 1 key, 1 deletion and 1 blob, got %+v", res)
+	}
+	if _, err = dst.Read(ctx, pk.New("dst-only")); err != nil {
+		t.Fatalf("expected 'dst-only' to be left alone, got %v", err)
+	}
+	info, err = dst.Stat(ctx, pk.New("a/2"))
+	if err != nil || info.Metadata["owner"] != "dev" {
+		t.Fatalf("expected metadata of 'a/2' to be replicated, got %+v (%v)", info, err)
+	}
+
+	// nothing changed since the last run
+	res, err = sis.Replicate(ctx, src, dst, sis.ReplicationOptions{Cursor: &res.Cursor})
+	if err != nil || res.Keys != 0 || res.Deleted != 0 {
+		t.Fatalf("expected nothing to replicate, got %+v (%v)", res, err)
+	}
+	err = dst.Delete(ctx, pk.New("dst-only"))
+	if err != nil {
+		t.Fatalf("error deleting 'dst-only': %s", err.Error())
+	}
+
+	mismatches, err := sis.VerifyReplica(ctx, src, dst)
+	if err != nil {
+		t.Fatalf("error verifying replica: %s", err.Error())
+	}
+	if len(mismatches) != 0 {
+		t.Fatalf("expected no mismatches, got %+v", mismatches)
+	}
+
+	problems, err := dst.Check(ctx)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("expected a consistent replica, got %+v (%v)", problems, err)
+	}
+
+	err = dst.Delete(ctx, pk.New("b/1"))
+	if err != nil {
+		t.Fatalf("error deleting 'b/1': %s", err.Error())
+	}
+	mismatches, err = sis.VerifyReplica(ctx, src, dst)
+	if err != nil || len(mismatches) != 1 || mismatches[0].DstDigest != "" {
+		t.Fatalf("expected 'b/1' to be reported missing, got %+v (%v)", mismatches, err)
+	}
+}
+
+func TestReplicateIncrementalWithoutFeed(t *testing.T) {
+	ctx := t.Context()
+	src, dst := newTestSIS(t), newTestSIS(t)
+
+	_, err := sis.Replicate(ctx, src, dst, sis.ReplicationOptions{Cursor: &sis.ReplicationCursor{}})
+	if err == nil {
+		t.Fatalf("expected incremental replication to need the change feed of the source")
+	}
+}
@@ -0,0 +1,191 @@
+package sis_test
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"sis"
+	"sis/internal/crud/crudos"
+	"sis/internal/pk"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	ctx := t.Context()
+	sisInstance := newTestSIS(t)
+
+	err := sisInstance.Create(ctx, pk.New("kept"), []byte("kept"))
+	if err != nil {
+		t.Fatalf("error creating 'kept': %s", err.Error())
+	}
+	err = sisInstance.Create(ctx, pk.New("changed"), []byte("before"))
+	if err != nil {
+		t.Fatalf("error creating 'changed': %s", err.Error())
+	}
+
+	info, err := sisInstance.Snapshot(ctx, "nightly")
+	if err != nil {
+		t.Fatalf("error taking snapshot: %s", err.Error())
+	}
+	if info.Keys != 2 {
+		t.Fatalf("expected a snapshot of 2 keys, got %+v", info)
+	}
+
+	err = sisInstance.Update(ctx, pk.New("changed"), []byte("after"))
+	if err != nil {
+		t.Fatalf("error updating 'changed': %s", err.Error())
+	}
+	err = sisInstance.Delete(ctx, pk.New("kept"))
+	if err != nil {
+		t.Fatalf("error deleting 'kept': %s", err.Error())
+	}
+	err = sisInstance.Create(ctx, pk.New("new"), []byte("new"))
+	if err != nil {
+		t.Fatalf("error creating 'new': %s", err.Error())
+	}
+
+	// the blobs only the snapshot holds survive GC
+	_, err = sisInstance.GC(ctx)
+	if err != nil {
+		t.Fatalf("error on GC: %s", err.Error())
+	}
+	problems, err := sisInstance.Check(ctx)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v (%v)", problems, err)
+	}
+	blob, err := sisInstance.ReadAt(ctx, "nightly", pk.New("changed"))
+	if err != nil || string(blob) != "before" {
+		t.Fatalf("expected 'before' in the snapshot, got '%s' (%v)", blob, err)
+	}
+
+	err = sisInstance.RestoreSnapshot(ctx, "nightly")
+	if err != nil {
+		t.Fatalf("error restoring snapshot: %s", err.Error())
+	}
+	keys, err := sisInstance.List(ctx, pk.PK{})
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("expected the 2 snapshot keys back, got %v (%v)", keys, err)
+	}
+	for key, expected := range map[string]string{"kept": "kept", "changed": "before"} {
+		blob, err := sisInstance.Read(ctx, pk.New(key))
+		if err != nil || string(blob) != expected {
+			t.Fatalf("expected '%s' in '%s', got '%s' (%v)", expected, key, blob, err)
+		}
+	}
+
+	// once the snapshot and the keys are gone, so are the blobs
+	err = sisInstance.DeleteSnapshot(ctx, "nightly")
+	if err != nil {
+		t.Fatalf("error deleting snapshot: %s", err.Error())
+	}
+	snapshots, err := sisInstance.ListSnapshots(ctx)
+	if err != nil || len(snapshots) != 0 {
+		t.Fatalf("expected no snapshots, got %v (%v)", snapshots, err)
+	}
+	for _, key := range keys {
+		err = sisInstance.Delete(ctx, key)
+		if err != nil {
+			t.Fatalf("error deleting '%s': %s", key.Path(), err.Error())
+		}
+	}
+	usage, err := sisInstance.Usage(ctx)
+	if err != nil || usage.Blobs != 0 {
+		t.Fatalf("expected no blobs left, got %+v (%v)", usage, err)
+	}
+}
+
+func TestSnapshotSurvivesRehash(t *testing.T) {
+	ctx := t.Context()
+	sisInstance := newTestSIS(t)
+
+	err := sisInstance.Create(ctx, pk.New("key"), []byte("contents"))
+	if err != nil {
+		t.Fatalf("error creating 'key': %s", err.Error())
+	}
+	_, err = sisInstance.Snapshot(ctx, "before-rehash")
+	if err != nil {
+		t.Fatalf("error taking snapshot: %s", err.Error())
+	}
+	err = sisInstance.Delete(ctx, pk.New("key"))
+	if err != nil {
+		t.Fatalf("error deleting 'key': %s", err.Error())
+	}
+
+	rehasher := sisInstance.NewRehasher(sha1.New(), nil)
+	err = rehasher.Run(ctx)
+	if err != nil {
+		t.Fatalf("error rehashing: %s", err.Error())
+	}
+
+	blob, err := sisInstance.ReadAt(ctx, "before-rehash", pk.New("key"))
+	if err != nil || string(blob) != "contents" {
+		t.Fatalf("expected 'contents' in the snapshot, got '%s' (%v)", blob, err)
+	}
+	problems, err := sisInstance.Check(ctx)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v (%v)", problems, err)
+	}
+}
+
+func TestSnapshotAfterInterruptedSnapshot(t *testing.T) {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	armed := false
+	faulty := faultyCrud{Crud: crudOs, fail: func(ctx context.Context, op string, key []string) error {
+		// the headers and refs of the snapshot are written, its info is not
+		if armed && op == "create" && key[len(key)-1] == "info" {
+			return errCrash
+		}
+		return nil
+	}}
+	sisInstance, err := sis.New(ctx, sha256.New(), faulty)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+	for _, key := range []string{"a", "b"} {
+		err = sisInstance.Create(ctx, pk.New(key), []byte(key))
+		if err != nil {
+			t.Fatalf("error creating '%s': %s", key, err.Error())
+		}
+	}
+
+	armed = true
+	_, err = sisInstance.Snapshot(ctx, "nightly")
+	if !errors.Is(err, errCrash) {
+		t.Fatalf("expected the snapshot to crash, got %v", err)
+	}
+	armed = false
+
+	// the header of 'a' was written, but the snapshot was never finished
+	_, err = sisInstance.ReadAt(ctx, "nightly", pk.New("a"))
+	if !errors.Is(err, sis.ErrNotFound) {
+		t.Fatalf("expected the interrupted snapshot not to be readable, got %v", err)
+	}
+
+	err = sisInstance.Delete(ctx, pk.New("b"))
+	if err != nil {
+		t.Fatalf("error deleting 'b': %s", err.Error())
+	}
+	info, err := sisInstance.Snapshot(ctx, "nightly")
+	if err != nil || info.Keys != 1 {
+		t.Fatalf("expected a snapshot of 1 key, got %+v (%v)", info, err)
+	}
+	_, err = sisInstance.ReadAt(ctx, "nightly", pk.New("b"))
+	if !errors.Is(err, sis.ErrNotFound) {
+		t.Fatalf("expected 'b' to be left out of the snapshot, got %v", err)
+	}
+
+	// no ref of the interrupted snapshot holds the blob of 'b'
+	_, err = sisInstance.GC(ctx)
+	if err != nil {
+		t.Fatalf("error on GC: %s", err.Error())
+	}
+	usage, err := sisInstance.Usage(ctx)
+	if err != nil || usage.Blobs != 1 {
+		t.Fatalf("expected only the blob of 'a', got %+v (%v)", usage, err)
+	}
+}
@@ -0,0 +1,73 @@
+package sis_test
+
+import (
+	"crypto/sha256"
+	"sis"
+	"sis/internal/crud/crudos"
+	"sis/internal/pk"
+	"testing"
+)
+
+func TestVersioning(t *testing.T) {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha256.New(), crudOs, sis.WithVersioning(sis.VersionRetention{KeepLast: 2}))
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+
+	key := pk.New("doc")
+	err = sisInstance.Create(ctx, key, []byte("v1"))
+	if err != nil {
+		t.Fatalf("error creating 'doc': %s", err.Error())
+	}
+	for _, contents := range []string{"v2", "v3"} {
+		err = sisInstance.Update(ctx, key, []byte(contents))
+		if err != nil {
+			t.Fatalf("error updating 'doc': %s", err.Error())
+		}
+	}
+
+	versions, err := sisInstance.ListVersions(ctx, key)
+	if err != nil {
+		t.Fatalf("error listing versions: %s", err.Error())
+	}
+	if len(versions) != 2 {
+		t.Fatalf("expected the last 2 versions to be kept, got %+v", versions)
+	}
+	blob, err := sisInstance.ReadVersion(ctx, key, versions[0].ID)
+	if err != nil || string(blob) != "v2" {
+		t.Fatalf("expected 'v2' in the oldest version, got '%s' (%v)", blob, err)
+	}
+	blob, err = sisInstance.ReadVersion(ctx, key, "")
+	if err != nil || string(blob) != "v3" {
+		t.Fatalf("expected 'v3' as the latest version, got '%s' (%v)", blob, err)
+	}
+
+	// the pruned version took its blob with it, while v2 is only held by its version
+	usage, err := sisInstance.Usage(ctx)
+	if err != nil || usage.Blobs != 2 {
+		t.Fatalf("expected 2 blobs, got %+v (%v)", usage, err)
+	}
+	problems, err := sisInstance.Check(ctx)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v (%v)", problems, err)
+	}
+
+	// versions outlive the key
+	err = sisInstance.Delete(ctx, key)
+	if err != nil {
+		t.Fatalf("error deleting 'doc': %s", err.Error())
+	}
+	blob, err = sisInstance.ReadVersion(ctx, key, versions[1].ID)
+	if err != nil || string(blob) != "v3" {
+		t.Fatalf("expected 'v3' after deletion, got '%s' (%v)", blob, err)
+	}
+	result, err := sisInstance.GC(ctx)
+	if err != nil || result.RemovedBlobs != 0 {
+		t.Fatalf("expected GC to keep versioned blobs, got %+v (%v)", result, err)
+	}
+}
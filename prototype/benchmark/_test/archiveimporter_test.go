@@ -0,0 +1,88 @@
+package benchmark_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"sis"
+	"sis/benchmark"
+	"sis/internal/crud/crudos"
+	"sis/internal/data"
+	"sis/internal/pk"
+	"testing"
+	"time"
+)
+
+func TestImportArchives(t *testing.T) {
+	ctx := t.Context()
+	modTime := time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC)
+	members := map[string]string{
+		"docs/a.txt": "shared contents",
+		"docs/b.txt": "only in the tar",
+	}
+
+	var tarGz bytes.Buffer
+	gzipWriter := gzip.NewWriter(&tarGz)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for name, contents := range members {
+		err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0600, ModTime: modTime, Size: int64(len(contents))})
+		if err != nil {
+			t.Fatalf("error writing tar header: %s", err.Error())
+		}
+		_, err = tarWriter.Write([]byte(contents))
+		if err != nil {
+			t.Fatalf("error writing tar member: %s", err.Error())
+		}
+	}
+	if tarWriter.Close() != nil || gzipWriter.Close() != nil {
+		t.Fatalf("error closing tar.gz")
+	}
+
+	var zipped bytes.Buffer
+	zipWriter := zip.NewWriter(&zipped)
+	member, err := zipWriter.Create("copy/a.txt")
+	if err != nil {
+		t.Fatalf("error creating zip member: %s", err.Error())
+	}
+	_, err = member.Write([]byte(members["docs/a.txt"]))
+	if err != nil || zipWriter.Close() != nil {
+		t.Fatalf("error writing zip")
+	}
+
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+
+	tarReport, err := benchmark.ImportTar(ctx, &sisInstance, pk.PK{"in"}, "a.tar.gz", &tarGz)
+	if err != nil {
+		t.Fatalf("error importing tar: %s", err.Error())
+	}
+	if tarReport.Files != 2 || tarReport.Bytes != 30 || tarReport.SavedBytes() != 0 {
+		t.Fatalf("unexpected tar report %+v", tarReport)
+	}
+
+	zipReport, err := benchmark.ImportZip(ctx, &sisInstance, pk.PK{"in"}, "b.zip", bytes.NewReader(zipped.Bytes()), int64(zipped.Len()))
+	if err != nil {
+		t.Fatalf("error importing zip: %s", err.Error())
+	}
+	if zipReport.Files != 1 || zipReport.StoredBytes != 0 || zipReport.SavedBytes() != 15 {
+		t.Fatalf("expected the zip to be fully deduplicated, got %+v", zipReport)
+	}
+
+	info, err := sisInstance.Stat(ctx, pk.PK{"in", "docs", "a.txt"})
+	if err != nil {
+		t.Fatalf("error on stat: %s", err.Error())
+	}
+	mode, modeOk := data.FileMode(info.Metadata)
+	storedModTime, modTimeOk := data.ModTime(info.Metadata)
+	if !modeOk || mode != 0600 || !modTimeOk || !storedModTime.Equal(modTime) {
+		t.Fatalf("expected mode 0600 and mtime %s, got metadata %v", modTime, info.Metadata)
+	}
+}
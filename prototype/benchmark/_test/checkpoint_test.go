@@ -0,0 +1,157 @@
+package benchmark_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sis"
+	"sis/benchmark"
+	"sis/internal/crud/crudos"
+	"sis/internal/pk"
+	"testing"
+)
+
+func TestCrawlResume(t *testing.T) {
+	ctx := t.Context()
+	srcDir := t.TempDir()
+	for i := range 10 {
+		err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("file%d", i)), []byte(fmt.Sprintf("content %d", i%3)), 0666)
+		if err != nil {
+			t.Fatalf("error writing source file: %s", err.Error())
+		}
+	}
+
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint")
+
+	// the first crawl is interrupted after a few files
+	crawler, err := benchmark.NewSISCrawler(&sisInstance, srcDir)
+	if err != nil {
+		t.Fatalf("error creating crawler: %s", err.Error())
+	}
+	err = crawler.EnableCheckpoint(checkpointPath, false)
+	if err != nil {
+		t.Fatalf("error enabling checkpoint: %s", err.Error())
+	}
+	for range 4 {
+		err = crawler.Crawl(ctx)
+		if err != nil {
+			t.Fatalf("error crawling: %s", err.Error())
+		}
+	}
+
+	// without SkipIfIdentical a plain restart would fail on the first stored key
+	crawler, err = benchmark.NewSISCrawler(&sisInstance, srcDir)
+	if err != nil {
+		t.Fatalf("error creating crawler: %s", err.Error())
+	}
+	err = crawler.EnableCheckpoint(checkpointPath, true)
+	if err != nil {
+		t.Fatalf("error enabling checkpoint: %s", err.Error())
+	}
+	err = crawler.CrawlAll(ctx)
+	if err != nil {
+		t.Fatalf("error resuming crawl: %s", err.Error())
+	}
+
+	keys, err := sisInstance.List(ctx, pk.New(srcDir))
+	if err != nil {
+		t.Fatalf("error listing keys: %s", err.Error())
+	}
+	if len(keys) != 10 {
+		t.Fatalf("expected 10 keys, got %d", len(keys))
+	}
+
+	// with SkipIfIdentical a full re-crawl is idempotent
+	skipping, err := sis.New(ctx, sha256.New(), crudOs, sis.WithExistsPolicy(sis.SkipIfIdentical))
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+	crawler, err = benchmark.NewSISCrawler(&skipping, srcDir)
+	if err != nil {
+		t.Fatalf("error creating crawler: %s", err.Error())
+	}
+	err = crawler.CrawlAll(ctx)
+	if err != nil {
+		t.Fatalf("error re-crawling: %s", err.Error())
+	}
+
+	err = skipping.Create(ctx, pk.New(filepath.Join(srcDir, "file0")), []byte("changed"))
+	if err == nil {
+		t.Fatalf("expected create with different contents to fail")
+	}
+}
+
+func TestCrawlResumeAfterTornLine(t *testing.T) {
+	ctx := t.Context()
+	srcDir := t.TempDir()
+	for i := range 10 {
+		err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("file%d", i)), []byte(fmt.Sprintf("content %d", i)), 0666)
+		if err != nil {
+			t.Fatalf("error writing source file: %s", err.Error())
+		}
+	}
+
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint")
+
+	// every run stores a few files and crashes halfway through appending the next entry
+	for run := range 2 {
+		crawler, err := benchmark.NewSISCrawler(&sisInstance, srcDir)
+		if err != nil {
+			t.Fatalf("error creating crawler: %s", err.Error())
+		}
+		err = crawler.EnableCheckpoint(checkpointPath, run > 0)
+		if err != nil {
+			t.Fatalf("error enabling checkpoint: %s", err.Error())
+		}
+		for range 3 {
+			err = crawler.Crawl(ctx)
+			if err != nil {
+				t.Fatalf("error crawling: %s", err.Error())
+			}
+		}
+		f, err := os.OpenFile(checkpointPath, os.O_APPEND|os.O_WRONLY, 0666)
+		if err != nil {
+			t.Fatalf("error opening checkpoint: %s", err.Error())
+		}
+		_, err = f.WriteString(`"/torn/pa`)
+		f.Close()
+		if err != nil {
+			t.Fatalf("error tearing checkpoint: %s", err.Error())
+		}
+	}
+
+	// the files of both runs are skipped, or their creates would fail
+	crawler, err := benchmark.NewSISCrawler(&sisInstance, srcDir)
+	if err != nil {
+		t.Fatalf("error creating crawler: %s", err.Error())
+	}
+	err = crawler.EnableCheckpoint(checkpointPath, true)
+	if err != nil {
+		t.Fatalf("error enabling checkpoint: %s", err.Error())
+	}
+	err = crawler.CrawlAll(ctx)
+	if err != nil {
+		t.Fatalf("error resuming crawl: %s", err.Error())
+	}
+	keys, err := sisInstance.List(ctx, pk.New(srcDir))
+	if err != nil || len(keys) != 10 {
+		t.Fatalf("expected 10 keys, got %d (%v)", len(keys), err)
+	}
+}
@@ -0,0 +1,114 @@
+package benchmark_test
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"sis"
+	"sis/benchmark"
+	"sis/internal/crud/crudos"
+	"sis/internal/data"
+	"sis/internal/metrics"
+	"sis/internal/pk"
+	"slices"
+	"testing"
+)
+
+func TestCrawlerOptions(t *testing.T) {
+	ctx := t.Context()
+	srcDir := t.TempDir()
+	files := map[string]string{
+		"keep.txt":            "keep",
+		"skip.log":            "skip",
+		"big.txt":             "this file is too large",
+		".hidden/secret.txt":  "secret",
+		"nested/deep/doc.txt": "doc",
+		"build/out.txt":       "out",
+	}
+	for name, contents := range files {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), 0777)
+		if err != nil {
+			t.Fatalf("error creating source dir: %s", err.Error())
+		}
+		err = os.WriteFile(path, []byte(contents), 0640)
+		if err != nil {
+			t.Fatalf("error writing source file: %s", err.Error())
+		}
+	}
+	err := os.Symlink("keep.txt", filepath.Join(srcDir, "link.txt"))
+	if err != nil {
+		t.Fatalf("error creating symlink: %s", err.Error())
+	}
+	// a link back to srcDir must not make the crawl loop
+	err = os.Symlink(srcDir, filepath.Join(srcDir, "nested", "loop"))
+	if err != nil {
+		t.Fatalf("error creating symlink: %s", err.Error())
+	}
+
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+
+	crawler, err := benchmark.NewSISCrawler(&sisInstance, srcDir,
+		benchmark.WithDestPrefix(pk.PK{"import"}),
+		benchmark.WithRelativeKeys(),
+		benchmark.WithInclude("*.txt"),
+		benchmark.WithExclude("build"),
+		benchmark.WithSizeLimits(0, metrics.Byte(10)),
+		benchmark.WithSymlinks(benchmark.StoreSymlinks),
+		benchmark.WithHidden(benchmark.SkipHidden),
+		benchmark.WithFileMetadata(),
+	)
+	if err != nil {
+		t.Fatalf("error creating crawler: %s", err.Error())
+	}
+	err = crawler.CrawlAll(ctx)
+	if err != nil {
+		t.Fatalf("error crawling: %s", err.Error())
+	}
+
+	keys, err := sisInstance.List(ctx, pk.PK{"import"})
+	if err != nil {
+		t.Fatalf("error listing keys: %s", err.Error())
+	}
+	var paths []string
+	for _, key := range keys {
+		paths = append(paths, key.String())
+	}
+	slices.Sort(paths)
+	expected := []string{
+		filepath.Join("import", "keep.txt"),
+		filepath.Join("import", "link.txt"),
+		filepath.Join("import", "nested", "deep", "doc.txt"),
+	}
+	if !slices.Equal(paths, expected) {
+		t.Fatalf("expected keys %v, got %v", expected, paths)
+	}
+
+	info, err := sisInstance.Stat(ctx, pk.PK{"import", "link.txt"})
+	if err != nil {
+		t.Fatalf("error on stat: %s", err.Error())
+	}
+	target, ok := data.SymlinkTarget(info.Metadata)
+	if !ok || target != "keep.txt" {
+		t.Fatalf("expected link.txt to be stored as a link to keep.txt, got metadata %v", info.Metadata)
+	}
+
+	info, err = sisInstance.Stat(ctx, pk.PK{"import", "keep.txt"})
+	if err != nil {
+		t.Fatalf("error on stat: %s", err.Error())
+	}
+	mode, ok := data.FileMode(info.Metadata)
+	if !ok || mode != 0640 {
+		t.Fatalf("expected mode 0640 in metadata, got %v", info.Metadata)
+	}
+	if _, ok := data.ModTime(info.Metadata); !ok {
+		t.Fatalf("expected mtime in metadata, got %v", info.Metadata)
+	}
+}
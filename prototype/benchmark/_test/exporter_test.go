@@ -0,0 +1,155 @@
+package benchmark_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sis"
+	"sis/benchmark"
+	"sis/internal/crud/crudos"
+	"sis/internal/data"
+	"sis/internal/pk"
+	"testing"
+	"time"
+)
+
+func TestExport(t *testing.T) {
+	ctx := t.Context()
+	srcDir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(srcDir, "dir"), 0777)
+	if err != nil {
+		t.Fatalf("error creating source dir: %s", err.Error())
+	}
+	filePath := filepath.Join(srcDir, "dir", "file")
+	err = os.WriteFile(filePath, []byte("contents"), 0600)
+	if err != nil {
+		t.Fatalf("error writing source file: %s", err.Error())
+	}
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = os.Chtimes(filePath, modTime, modTime)
+	if err != nil {
+		t.Fatalf("error setting modification time: %s", err.Error())
+	}
+	err = os.Symlink("dir/file", filepath.Join(srcDir, "link"))
+	if err != nil {
+		t.Fatalf("error creating symlink: %s", err.Error())
+	}
+
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+	crawler, err := benchmark.NewSISCrawler(&sisInstance, srcDir,
+		benchmark.WithDestPrefix(pk.PK{"dataset"}),
+		benchmark.WithRelativeKeys(),
+		benchmark.WithSymlinks(benchmark.StoreSymlinks),
+		benchmark.WithFileMetadata(),
+	)
+	if err != nil {
+		t.Fatalf("error creating crawler: %s", err.Error())
+	}
+	err = crawler.CrawlAll(ctx)
+	if err != nil {
+		t.Fatalf("error crawling: %s", err.Error())
+	}
+
+	options := benchmark.ExportOptions{RestoreMetadata: true, Gzip: true}
+	targetDir := t.TempDir()
+	res, err := benchmark.ExportDir(ctx, &sisInstance, pk.PK{"dataset"}, targetDir, options)
+	if err != nil {
+		t.Fatalf("error exporting to dir: %s", err.Error())
+	}
+	if res.Files != 2 || res.Bytes != 8 {
+		t.Fatalf("expected 2 files and 8 bytes, got %+v", res)
+	}
+
+	info, err := os.Stat(filepath.Join(targetDir, "dir", "file"))
+	if err != nil {
+		t.Fatalf("error on exported file stat: %s", err.Error())
+	}
+	if info.Mode().Perm() != 0600 || !info.ModTime().Equal(modTime) {
+		t.Fatalf("expected mode 0600 and mtime %s, got %s and %s", modTime, info.Mode().Perm(), info.ModTime())
+	}
+	target, err := os.Readlink(filepath.Join(targetDir, "link"))
+	if err != nil || target != "dir/file" {
+		t.Fatalf("expected link to dir/file, got '%s' (%v)", target, err)
+	}
+
+	var archive bytes.Buffer
+	_, err = benchmark.ExportTar(ctx, &sisInstance, pk.PK{"dataset"}, &archive, options)
+	if err != nil {
+		t.Fatalf("error exporting to tar: %s", err.Error())
+	}
+	gzipReader, err := gzip.NewReader(&archive)
+	if err != nil {
+		t.Fatalf("error opening gzip stream: %s", err.Error())
+	}
+	tarReader := tar.NewReader(gzipReader)
+	entries := make(map[string]*tar.Header)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("error reading tar: %s", err.Error())
+		}
+		entries[header.Name] = header
+	}
+	file, link := entries["dir/file"], entries["link"]
+	if file == nil || file.Size != 8 || file.Mode != 0600 || !file.ModTime.Equal(modTime) {
+		t.Fatalf("unexpected tar header for dir/file: %+v", file)
+	}
+	if link == nil || link.Typeflag != tar.TypeSymlink || link.Linkname != "dir/file" {
+		t.Fatalf("unexpected tar header for link: %+v", link)
+	}
+}
+
+func TestExportStaysInTarget(t *testing.T) {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+
+	// 'x' is a link out of the target, and 'x/y' would be written through it
+	outsideDir := t.TempDir()
+	for _, key := range []string{"out/x", "out/x/y", "a", "a/a"} {
+		err = sisInstance.Create(ctx, pk.New(key), []byte(key))
+		if err != nil {
+			t.Fatalf("error creating '%s': %s", key, err.Error())
+		}
+	}
+	err = sisInstance.SetMetadata(ctx, pk.New("out/x"), map[string]any{data.MetadataSymlink: outsideDir})
+	if err != nil {
+		t.Fatalf("error setting metadata: %s", err.Error())
+	}
+
+	_, err = benchmark.ExportDir(ctx, &sisInstance, pk.New("out"), t.TempDir(), benchmark.ExportOptions{})
+	if err == nil {
+		t.Fatalf("expected the export through a link to fail")
+	}
+	entries, err := os.ReadDir(outsideDir)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("expected nothing written outside the target, got %v (%v)", entries, err)
+	}
+
+	// the prefix key 'a' and its child 'a/a' map to the same path
+	_, err = benchmark.ExportDir(ctx, &sisInstance, pk.New("a"), t.TempDir(), benchmark.ExportOptions{})
+	if !errors.Is(err, sis.ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package benchmark_test
+
+import (
+	"bytes"
+	"sis/benchmark"
+	"sis/internal/metrics"
+	"testing"
+)
+
+func TestGeneratorIsDeterministic(t *testing.T) {
+	config := benchmark.GeneratorConfig{
+		Seed: 7,
+		Size: benchmark.SizeConfig{
+			Distribution: benchmark.ZipfSize,
+			Min:          metrics.KB(1),
+			Max:          metrics.KB(64),
+			ZipfS:        1.5,
+		},
+		ContentWeights: map[benchmark.ContentType]float64{
+			benchmark.RandomContent:        2,
+			benchmark.TextContent:          1,
+			benchmark.OverlappingContent:   1,
+			benchmark.NearDuplicateContent: 1,
+		},
+		DuplicateRate:   0.3,
+		OverlapFraction: 0.5,
+		Edits:           2,
+	}
+
+	first, err := benchmark.NewGenerator(config)
+	if err != nil {
+		t.Fatalf("error creating generator: %s", err.Error())
+	}
+	second, err := benchmark.NewGenerator(config)
+	if err != nil {
+		t.Fatalf("error creating generator: %s", err.Error())
+	}
+
+	seen := make(map[string][]byte)
+	var duplicates int
+	for range 200 {
+		file := first.Next()
+		other := second.Next()
+		if file.Id != other.Id || !bytes.Equal(file.Blob, other.Blob) {
+			t.Fatalf("generators with the same seed diverged at '%s'/'%s'", file.Id, other.Id)
+		}
+		if previous, ok := seen[file.Id]; ok {
+			duplicates++
+			if !bytes.Equal(previous, file.Blob) {
+				t.Fatalf("duplicate of '%s' has different contents", file.Id)
+			}
+		}
+		seen[file.Id] = file.Blob
+		if len(file.Blob) < int(config.Size.Min) || len(file.Blob) > int(config.Size.Max) {
+			t.Fatalf("'%s' has size %d outside of [%d, %d]", file.Id, len(file.Blob), config.Size.Min, config.Size.Max)
+		}
+	}
+
+	if duplicates == 0 {
+		t.Fatalf("expected some exact duplicates with duplicate rate %.2f", config.DuplicateRate)
+	}
+}
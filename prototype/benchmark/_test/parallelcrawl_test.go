@@ -0,0 +1,90 @@
+package benchmark_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sis"
+	"sis/benchmark"
+	"sis/internal/crud/crudos"
+	"sis/internal/metrics"
+	"sis/internal/pk"
+	"testing"
+)
+
+func TestCrawlParallel(t *testing.T) {
+	ctx := t.Context()
+	srcDir := t.TempDir()
+	for i := range 50 {
+		dir := filepath.Join(srcDir, fmt.Sprintf("dir%d", i%5))
+		err := os.MkdirAll(dir, 0777)
+		if err != nil {
+			t.Fatalf("error creating source dir: %s", err.Error())
+		}
+		err = os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d", i)), []byte(fmt.Sprintf("content %d", i%10)), 0666)
+		if err != nil {
+			t.Fatalf("error writing source file: %s", err.Error())
+		}
+	}
+
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+
+	// a key already taken makes a single file fail
+	takenPath := filepath.Join(srcDir, "dir0", "file0")
+	takenKey, err := pk.FromPath(takenPath)
+	if err != nil {
+		t.Fatalf("error mapping taken path: %s", err.Error())
+	}
+	err = sisInstance.Create(ctx, takenKey, []byte("taken"))
+	if err != nil {
+		t.Fatalf("error creating taken key: %s", err.Error())
+	}
+
+	crawler, err := benchmark.NewSISCrawler(&sisInstance, srcDir)
+	if err != nil {
+		t.Fatalf("error creating crawler: %s", err.Error())
+	}
+
+	var last benchmark.CrawlProgress
+	result, err := crawler.CrawlParallel(ctx, benchmark.CrawlOptions{
+		Workers:          4,
+		MaxInFlightBytes: metrics.Byte(30),
+		Progress: func(progress benchmark.CrawlProgress) {
+			last = progress
+		},
+	})
+	if err != nil {
+		t.Fatalf("error crawling: %s", err.Error())
+	}
+
+	if result.Files != 49 || len(result.Errors) != 1 || result.Errors[0].SrcPath != takenPath {
+		t.Fatalf("expected 49 files and an error on '%s', got %d files and errors %v", takenPath, result.Files, result.Errors)
+	}
+	if last.TotalFiles != 50 || last.Files != 49 || last.ETA != 0 {
+		t.Fatalf("unexpected final progress %+v", last)
+	}
+
+	keys, err := sisInstance.List(ctx, pk.New(srcDir))
+	if err != nil {
+		t.Fatalf("error listing keys: %s", err.Error())
+	}
+	if len(keys) != 50 {
+		t.Fatalf("expected 50 keys, got %d", len(keys))
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = crawler.CrawlParallel(cancelled, benchmark.CrawlOptions{})
+	if err != context.Canceled {
+		t.Fatalf("expected cancelled crawl to return context.Canceled, got %v", err)
+	}
+}
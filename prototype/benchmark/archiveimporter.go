@@ -0,0 +1,239 @@
+package benchmark
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sis"
+	"sis/internal/data"
+	"sis/internal/metrics"
+	"sis/internal/pk"
+	"strings"
+	"time"
+)
+
+// an ArchiveReport tells how much of an imported archive was already in the store
+type ArchiveReport struct {
+	Archive string `json:"archive"`
+	Files   int    `json:"files"`
+	// Bytes is the size of the imported members
+	Bytes metrics.Byte `json:"bytes"`
+	// StoredBytes is the size of the members whose blob was new to the store
+	StoredBytes metrics.Byte `json:"storedBytes"`
+}
+
+// SavedBytes is what deduplication against the store and the archive itself saved
+func (r ArchiveReport) SavedBytes() metrics.Byte {
+	return r.Bytes - r.StoredBytes
+}
+
+// ImportArchives imports every archive at paths under prefix, one report per archive. Content
+// shared between archives is only stored once, and shows up as savings of the later ones
+func ImportArchives(ctx context.Context, store sis.Store, prefix pk.PK, paths ...string) ([]ArchiveReport, error) {
+	reports := make([]ArchiveReport, 0, len(paths))
+	for _, archivePath := range paths {
+		report, err := ImportArchive(ctx, store, prefix, archivePath)
+		if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// ImportArchive imports a .zip, or a tar archive gzipped or not, telling them apart by extension
+func ImportArchive(ctx context.Context, store sis.Store, prefix pk.PK, archivePath string) (ArchiveReport, error) {
+	if strings.EqualFold(filepath.Ext(archivePath), ".zip") {
+		reader, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return ArchiveReport{}, fmt.Errorf("error opening '%s': %w", archivePath, err)
+		}
+		defer reader.Close()
+		return importZip(ctx, store, prefix, archivePath, &reader.Reader)
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return ArchiveReport{}, fmt.Errorf("error opening '%s': %w", archivePath, err)
+	}
+	defer file.Close()
+	return ImportTar(ctx, store, prefix, archivePath, file)
+}
+
+// ImportTar streams the members of the tar archive read from r into the store under prefix.
+// Gzipped archives are detected and decompressed. name only labels the report
+func ImportTar(ctx context.Context, store sis.Store, prefix pk.PK, name string, r io.Reader) (ArchiveReport, error) {
+
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(2)
+	r = buffered
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return ArchiveReport{}, fmt.Errorf("error opening gzip stream of '%s': %w", name, err)
+		}
+		defer gzipReader.Close()
+		r = gzipReader
+	}
+	tarReader := tar.NewReader(r)
+
+	return importMembers(ctx, store, prefix, name, func(importMember func(archiveMember) error) error {
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error reading tar: %w", err)
+			}
+
+			member := archiveMember{
+				name:    header.Name,
+				mode:    fs.FileMode(header.Mode),
+				modTime: header.ModTime,
+				body:    tarReader,
+			}
+			switch header.Typeflag {
+			case tar.TypeReg:
+			case tar.TypeSymlink:
+				member.linkTarget = header.Linkname
+			default:
+				// directories are implied by keys, other types have no contents
+				continue
+			}
+
+			err = importMember(member)
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// ImportZip imports the members of the zip archive read from r under prefix. name only labels the report
+func ImportZip(ctx context.Context, store sis.Store, prefix pk.PK, name string, r io.ReaderAt, size int64) (ArchiveReport, error) {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return ArchiveReport{}, fmt.Errorf("error opening zip '%s': %w", name, err)
+	}
+	return importZip(ctx, store, prefix, name, reader)
+}
+
+func importZip(ctx context.Context, store sis.Store, prefix pk.PK, name string, reader *zip.Reader) (ArchiveReport, error) {
+	return importMembers(ctx, store, prefix, name, func(importMember func(archiveMember) error) error {
+		for _, file := range reader.File {
+			mode := file.Mode()
+			if mode.IsDir() {
+				continue
+			}
+
+			body, err := file.Open()
+			if err != nil {
+				return fmt.Errorf("error opening member '%s': %w", file.Name, err)
+			}
+			member := archiveMember{
+				name:    file.Name,
+				mode:    mode,
+				modTime: file.Modified,
+				body:    body,
+			}
+			if mode&fs.ModeSymlink != 0 {
+				target, err := io.ReadAll(body)
+				if err != nil {
+					body.Close()
+					return fmt.Errorf("error reading link '%s': %w", file.Name, err)
+				}
+				member.linkTarget = string(target)
+			}
+
+			err = importMember(member)
+			body.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// an archiveMember is a regular file, or a symbolic link when linkTarget is set
+type archiveMember struct {
+	name       string
+	mode       fs.FileMode
+	modTime    time.Time
+	linkTarget string
+	body       io.Reader
+}
+
+// importMembers runs walk, which hands every member of an archive to importMember
+func importMembers(ctx context.Context, store sis.Store, prefix pk.PK, name string, walk func(importMember func(archiveMember) error) error) (ArchiveReport, error) {
+
+	report := ArchiveReport{Archive: name}
+	err := walk(func(member archiveMember) error {
+		size, stored, err := importMember(ctx, store, prefix, member)
+		if err != nil {
+			return fmt.Errorf("error importing member '%s': %w", member.name, err)
+		}
+		report.Files++
+		report.Bytes += size
+		if stored {
+			report.StoredBytes += size
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("error importing '%s': %w", name, err)
+	}
+
+	return report, nil
+}
+
+// importMember creates the key of member along with its mode and modification time, and reports
+// whether its blob was new to the store
+func importMember(ctx context.Context, store sis.Store, prefix pk.PK, member archiveMember) (metrics.Byte, bool, error) {
+
+	memberPath := path.Clean(strings.TrimPrefix(member.name, "/"))
+	if !filepath.IsLocal(filepath.FromSlash(memberPath)) {
+		return 0, false, fmt.Errorf("member path '%s' escapes the archive: %w", member.name, sis.ErrInvalidKey)
+	}
+	memberKey, err := pk.Parse(memberPath)
+	if err != nil {
+		return 0, false, fmt.Errorf("error mapping member '%s' to a key: %w", member.name, err)
+	}
+	key := prefix.Suffix(memberKey)
+
+	metadata := data.FileMetadata(member.mode, member.modTime)
+	body := member.body
+	if member.linkTarget != "" {
+		metadata[data.MetadataSymlink] = member.linkTarget
+		body = strings.NewReader(member.linkTarget)
+	}
+
+	counter := &countingReader{r: body}
+	stored, err := store.CreateWithMetadata(ctx, key, counter, metadata)
+	if err != nil {
+		return 0, false, fmt.Errorf("error creating '%s': %w", key.Path(), err)
+	}
+
+	return counter.n, stored, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n metrics.Byte
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += metrics.Byte(n)
+	return n, err
+}
@@ -1,13 +1,20 @@
 package benchmark
 
-import "fmt"
+import (
+	"fmt"
+	"sis"
+)
 
-// Returns nil if the byte slices are identicals
+// Returns nil if the byte slices are identicals, and an error wrapping sis.ErrCorrupted otherwise
 func ByteOnByte(blob1, blob2 []byte) error {
 
+	if len(blob1) != len(blob2) {
+		return fmt.Errorf("length mismatch: blob1 has %d bytes while blob2 has %d: %w", len(blob1), len(blob2), sis.ErrCorrupted)
+	}
+
 	for i, b := range blob1 {
 		if blob2[i] != b {
-			return fmt.Errorf("mismatch on index %d: blob1 has '%d' while blob2 has '%d'", i, b, blob2[i])
+			return fmt.Errorf("mismatch on index %d: blob1 has '%d' while blob2 has '%d': %w", i, b, blob2[i], sis.ErrCorrupted)
 		}
 	}
 
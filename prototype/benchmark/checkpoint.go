@@ -0,0 +1,98 @@
+package benchmark
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// a crawlCheckpoint records the source paths already stored, one JSON string per line, so that
+// an interrupted crawl can skip them
+type crawlCheckpoint struct {
+	mu        sync.Mutex
+	path      string
+	completed map[string]bool
+}
+
+// EnableCheckpoint makes the crawler record every stored file in the file at path. With resume set,
+// files recorded by a previous crawl are skipped, otherwise the checkpoint starts empty.
+// It must be called before crawling
+func (c *SISCrawler) EnableCheckpoint(path string, resume bool) error {
+	checkpoint := &crawlCheckpoint{
+		path:      path,
+		completed: make(map[string]bool),
+	}
+
+	if !resume {
+		err := os.WriteFile(path, nil, 0666)
+		if err != nil {
+			return fmt.Errorf("error resetting checkpoint: %w", err)
+		}
+		c.checkpoint = checkpoint
+		return nil
+	}
+
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		c.checkpoint = checkpoint
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading checkpoint: %w", err)
+	}
+
+	// a crash while appending leaves a partial last line, which is cut so that the next entry
+	// starts a line of its own
+	complete := bytes.LastIndexByte(contents, '\n') + 1
+	if complete < len(contents) {
+		err = os.Truncate(path, int64(complete))
+		if err != nil {
+			return fmt.Errorf("error truncating checkpoint: %w", err)
+		}
+	}
+
+	for line := range bytes.Lines(contents[:complete]) {
+		var srcPath string
+		err = json.Unmarshal(line, &srcPath)
+		if err != nil {
+			// a line torn by a crash before partial lines were cut, the entries after it are kept
+			continue
+		}
+		checkpoint.completed[srcPath] = true
+	}
+
+	c.checkpoint = checkpoint
+	return nil
+}
+
+func (cp *crawlCheckpoint) isCompleted(srcPath string) bool {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.completed[srcPath]
+}
+
+func (cp *crawlCheckpoint) markCompleted(srcPath string) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	line, err := json.Marshal(srcPath)
+	if err != nil {
+		return fmt.Errorf("error marshalling checkpoint entry: %w", err)
+	}
+
+	f, err := os.OpenFile(cp.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return fmt.Errorf("error opening checkpoint: %w", err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("error appending to checkpoint: %w", err)
+	}
+
+	cp.completed[srcPath] = true
+	return nil
+}
@@ -0,0 +1,137 @@
+package benchmark
+
+import (
+	"fmt"
+	"path/filepath"
+	"sis"
+	"sis/internal/metrics"
+	"sis/internal/pk"
+	"strings"
+)
+
+// a CrawlerOption configures which files a SISCrawler takes and the keys it stores them at
+type CrawlerOption func(c *SISCrawler)
+
+type SymlinkPolicy int
+
+const (
+	// FollowSymlinks crawls the file or directory a link points to, as if it were in its place
+	FollowSymlinks SymlinkPolicy = iota
+	// SkipSymlinks ignores links
+	SkipSymlinks
+	// StoreSymlinks stores the link target as the blob, recorded in data.MetadataSymlink too
+	StoreSymlinks
+)
+
+type HiddenPolicy int
+
+const (
+	// IncludeHidden crawls files and directories whose name starts with a dot
+	IncludeHidden HiddenPolicy = iota
+	// SkipHidden ignores them, along with everything below a hidden directory
+	SkipHidden
+)
+
+// WithDestPrefix stores every key under prefix
+func WithDestPrefix(prefix pk.PK) CrawlerOption {
+	return func(c *SISCrawler) {
+		c.destPrefix = prefix
+	}
+}
+
+// WithRelativeKeys makes keys relative to srcDir instead of using the absolute source path
+func WithRelativeKeys() CrawlerOption {
+	return func(c *SISCrawler) {
+		c.relativeKeys = true
+	}
+}
+
+// WithInclude only crawls files matching at least one of patterns. Patterns use filepath.Match
+// syntax and are matched against both the path relative to srcDir and the file name
+func WithInclude(patterns ...string) CrawlerOption {
+	return func(c *SISCrawler) {
+		c.include = append(c.include, patterns...)
+	}
+}
+
+// WithExclude skips files and directories matching any of patterns, matched like in WithInclude
+func WithExclude(patterns ...string) CrawlerOption {
+	return func(c *SISCrawler) {
+		c.exclude = append(c.exclude, patterns...)
+	}
+}
+
+// WithSizeLimits skips files smaller than min or larger than max. A zero max means no limit
+func WithSizeLimits(min, max metrics.Byte) CrawlerOption {
+	return func(c *SISCrawler) {
+		c.minSize = min
+		c.maxSize = max
+	}
+}
+
+func WithSymlinks(policy SymlinkPolicy) CrawlerOption {
+	return func(c *SISCrawler) {
+		c.symlinks = policy
+	}
+}
+
+func WithHidden(policy HiddenPolicy) CrawlerOption {
+	return func(c *SISCrawler) {
+		c.hidden = policy
+	}
+}
+
+// WithFileMetadata records the mode and modification time of every file in its header metadata
+func WithFileMetadata() CrawlerOption {
+	return func(c *SISCrawler) {
+		c.fileMetadata = true
+	}
+}
+
+func (c *SISCrawler) destKeyOf(srcPath string) (pk.PK, error) {
+	keyPath := srcPath
+	if c.relativeKeys {
+		relPath, err := filepath.Rel(c.srcDir, srcPath)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", sis.ErrInvalidKey, err)
+		}
+		keyPath = relPath
+	}
+	key, err := pk.FromPath(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return key.Prefix(c.destPrefix), nil
+}
+
+func (c *SISCrawler) isExcluded(srcPath string) bool {
+	if c.hidden == SkipHidden && strings.HasPrefix(filepath.Base(srcPath), ".") {
+		return true
+	}
+	return c.matchesAny(c.exclude, srcPath)
+}
+
+func (c *SISCrawler) isIncluded(srcPath string, size metrics.Byte) bool {
+	if size < c.minSize || (c.maxSize > 0 && size > c.maxSize) {
+		return false
+	}
+	return len(c.include) == 0 || c.matchesAny(c.include, srcPath)
+}
+
+func (c *SISCrawler) matchesAny(patterns []string, srcPath string) bool {
+	relPath, err := filepath.Rel(c.srcDir, srcPath)
+	if err != nil {
+		relPath = srcPath
+	}
+	for _, pattern := range patterns {
+		matched, _ := filepath.Match(pattern, relPath)
+		if matched {
+			return true
+		}
+		matched, _ = filepath.Match(pattern, filepath.Base(srcPath))
+		if matched {
+			return true
+		}
+	}
+	return false
+}
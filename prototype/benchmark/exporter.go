@@ -0,0 +1,294 @@
+package benchmark
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sis"
+	"sis/internal/data"
+	"sis/internal/metrics"
+	"sis/internal/pk"
+	"strings"
+	"time"
+)
+
+type ExportOptions struct {
+	// RestoreMetadata applies the mode and modification time recorded in header metadata, as set by
+	// crawlers and importers. Files without them get 0644 and, in archives, the export time
+	RestoreMetadata bool
+	// Gzip compresses the archive written by ExportTar
+	Gzip bool
+}
+
+type ExportResult struct {
+	Files int
+	Bytes metrics.Byte
+}
+
+// an exportedKey is a user key along with the slash separated path it is exported at
+type exportedKey struct {
+	key     pk.PK
+	relPath string
+}
+
+// ExportDir writes every user key under prefix to targetDir, at its path relative to prefix.
+// Keys stored as symbolic links are recreated as links once every file is written, and nothing is
+// written through a link, so that links cannot redirect files outside targetDir
+func ExportDir(ctx context.Context, store sis.Store, prefix pk.PK, targetDir string, options ExportOptions) (ExportResult, error) {
+
+	keys, err := exportedKeys(ctx, store, prefix)
+	if err != nil {
+		return ExportResult{}, err
+	}
+
+	var res ExportResult
+	var links []exportedKey
+	for _, exported := range keys {
+		written, isLink, err := exportFile(ctx, store, exported, targetDir, options)
+		if err != nil {
+			return res, fmt.Errorf("error exporting '%s': %w", exported.key.Path(), err)
+		}
+		if isLink {
+			links = append(links, exported)
+			continue
+		}
+		res.Files++
+		res.Bytes += written
+	}
+
+	for _, exported := range links {
+		err = exportLink(ctx, store, exported, targetDir)
+		if err != nil {
+			return res, fmt.Errorf("error exporting '%s': %w", exported.key.Path(), err)
+		}
+		res.Files++
+	}
+
+	return res, nil
+}
+
+// ExportTar streams every user key under prefix to w as a tar archive, gzipped if options.Gzip
+func ExportTar(ctx context.Context, store sis.Store, prefix pk.PK, w io.Writer, options ExportOptions) (ExportResult, error) {
+
+	keys, err := exportedKeys(ctx, store, prefix)
+	if err != nil {
+		return ExportResult{}, err
+	}
+
+	var gzipWriter *gzip.Writer
+	if options.Gzip {
+		gzipWriter = gzip.NewWriter(w)
+		w = gzipWriter
+	}
+	tarWriter := tar.NewWriter(w)
+
+	exportTime := time.Now()
+	var res ExportResult
+	for _, exported := range keys {
+		written, err := exportTarEntry(ctx, store, tarWriter, exported, exportTime, options)
+		if err != nil {
+			return res, fmt.Errorf("error exporting '%s': %w", exported.key.Path(), err)
+		}
+		res.Files++
+		res.Bytes += written
+	}
+
+	err = tarWriter.Close()
+	if err != nil {
+		return res, fmt.Errorf("error closing tar archive: %w", err)
+	}
+	if gzipWriter != nil {
+		err = gzipWriter.Close()
+		if err != nil {
+			return res, fmt.Errorf("error closing gzip stream: %w", err)
+		}
+	}
+	return res, nil
+}
+
+// exportedKeys lists the keys under prefix. Paths must stay inside the export root, so keys with
+// '..' segments are refused, and must be unique, like those of a prefix that is a key itself and
+// of a child key named like its last segment
+func exportedKeys(ctx context.Context, store sis.Store, prefix pk.PK) ([]exportedKey, error) {
+
+	keys, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("error listing '%s': %w", prefix.Path(), err)
+	}
+
+	exported := make([]exportedKey, 0, len(keys))
+	exportedBy := make(map[string]pk.PK, len(keys))
+	for _, key := range keys {
+		relKey := key[len(prefix):]
+		if len(relKey) == 0 {
+			// the prefix is a key itself
+			relKey = key[len(key)-1:]
+		}
+		relPath := relKey.Format()
+		if !filepath.IsLocal(filepath.FromSlash(relPath)) {
+			return nil, fmt.Errorf("key '%s' cannot be exported below the target: %w", key.Path(), sis.ErrInvalidKey)
+		}
+		if other, ok := exportedBy[relPath]; ok {
+			return nil, fmt.Errorf("keys '%s' and '%s' would both be exported at '%s': %w", other.Path(), key.Path(), relPath, sis.ErrConflict)
+		}
+		exportedBy[relPath] = key
+		exported = append(exported, exportedKey{key: key, relPath: relPath})
+	}
+
+	return exported, nil
+}
+
+// exportFile writes a regular file, or only reports that the key is a link, for exportLink
+func exportFile(ctx context.Context, store sis.Store, exported exportedKey, targetDir string, options ExportOptions) (metrics.Byte, bool, error) {
+
+	reader, info, err := store.Open(ctx, exported.key)
+	if err != nil {
+		return 0, false, fmt.Errorf("error opening key: %w", err)
+	}
+	defer reader.Close()
+
+	if _, isLink := data.SymlinkTarget(info.Metadata); isLink {
+		return 0, true, nil
+	}
+
+	destPath, err := exportPath(targetDir, exported.relPath)
+	if err != nil {
+		return 0, false, err
+	}
+
+	mode := fs.FileMode(0644)
+	if options.RestoreMetadata {
+		if storedMode, ok := data.FileMode(info.Metadata); ok {
+			mode = storedMode
+		}
+	}
+
+	file, err := os.OpenFile(destPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return 0, false, fmt.Errorf("error creating file: %w", err)
+	}
+	written, err := io.Copy(file, reader)
+	if err != nil {
+		file.Close()
+		return 0, false, fmt.Errorf("error writing file: %w", err)
+	}
+	err = file.Close()
+	if err != nil {
+		return 0, false, fmt.Errorf("error closing file: %w", err)
+	}
+
+	if options.RestoreMetadata {
+		// the umask may have masked the mode on creation
+		err = os.Chmod(destPath, mode)
+		if err != nil {
+			return 0, false, fmt.Errorf("error restoring mode: %w", err)
+		}
+		if modTime, ok := data.ModTime(info.Metadata); ok {
+			err = os.Chtimes(destPath, modTime, modTime)
+			if err != nil {
+				return 0, false, fmt.Errorf("error restoring modification time: %w", err)
+			}
+		}
+	}
+
+	return metrics.Byte(written), false, nil
+}
+
+// exportLink recreates a key stored as a symbolic link
+func exportLink(ctx context.Context, store sis.Store, exported exportedKey, targetDir string) error {
+
+	info, err := store.Stat(ctx, exported.key)
+	if err != nil {
+		return fmt.Errorf("error reading key info: %w", err)
+	}
+	target, _ := data.SymlinkTarget(info.Metadata)
+
+	destPath, err := exportPath(targetDir, exported.relPath)
+	if err != nil {
+		return err
+	}
+	err = os.Symlink(target, destPath)
+	if err != nil {
+		return fmt.Errorf("error creating symlink: %w", err)
+	}
+	return nil
+}
+
+// exportPath creates the parent dirs of relPath under targetDir, refusing to go through links
+func exportPath(targetDir string, relPath string) (string, error) {
+
+	dir := targetDir
+	segments := strings.Split(relPath, "/")
+	for _, segment := range segments[:len(segments)-1] {
+		dir = filepath.Join(dir, segment)
+		dirInfo, err := os.Lstat(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			err = os.Mkdir(dir, 0755)
+			if err != nil {
+				return "", fmt.Errorf("error creating parent dir: %w", err)
+			}
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("error reading parent dir: %w", err)
+		}
+		if dirInfo.Mode()&fs.ModeSymlink != 0 {
+			return "", fmt.Errorf("parent dir '%s' is a symbolic link: %w", dir, sis.ErrConflict)
+		}
+	}
+
+	return filepath.Join(dir, segments[len(segments)-1]), nil
+}
+
+func exportTarEntry(ctx context.Context, store sis.Store, tarWriter *tar.Writer, exported exportedKey, exportTime time.Time, options ExportOptions) (metrics.Byte, error) {
+
+	reader, info, err := store.Open(ctx, exported.key)
+	if err != nil {
+		return 0, fmt.Errorf("error opening key: %w", err)
+	}
+	defer reader.Close()
+
+	header := &tar.Header{
+		Name:    exported.relPath,
+		Mode:    0644,
+		ModTime: exportTime,
+	}
+	if options.RestoreMetadata {
+		if mode, ok := data.FileMode(info.Metadata); ok {
+			header.Mode = int64(mode)
+		}
+		if modTime, ok := data.ModTime(info.Metadata); ok {
+			header.ModTime = modTime
+		}
+	}
+
+	target, isLink := data.SymlinkTarget(info.Metadata)
+	if isLink {
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = target
+		err = tarWriter.WriteHeader(header)
+		if err != nil {
+			return 0, fmt.Errorf("error writing tar header: %w", err)
+		}
+		return 0, nil
+	}
+
+	header.Typeflag = tar.TypeReg
+	header.Size = int64(info.Size)
+	err = tarWriter.WriteHeader(header)
+	if err != nil {
+		return 0, fmt.Errorf("error writing tar header: %w", err)
+	}
+	written, err := io.Copy(tarWriter, reader)
+	if err != nil {
+		return 0, fmt.Errorf("error writing tar entry: %w", err)
+	}
+
+	return metrics.Byte(written), nil
+}
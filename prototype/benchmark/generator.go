@@ -0,0 +1,279 @@
+package benchmark
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sis/internal/metrics"
+	"slices"
+	"strings"
+)
+
+type SizeDistribution string
+
+const (
+	UniformSize   SizeDistribution = "uniform"
+	LogNormalSize SizeDistribution = "lognormal"
+	ZipfSize      SizeDistribution = "zipf"
+)
+
+type ContentType string
+
+const (
+	// RandomContent is incompressible and shares nothing with other files
+	RandomContent ContentType = "random"
+	// TextContent is made of words from a small vocabulary, so it compresses well
+	TextContent ContentType = "text"
+	// OverlappingContent copies a contiguous part of a previous file and fills the rest randomly
+	OverlappingContent ContentType = "overlapping"
+	// NearDuplicateContent is a previous file with a few small edits
+	NearDuplicateContent ContentType = "near-duplicate"
+)
+
+// zipfBuckets is how many distinct sizes the zipf distribution picks from
+const zipfBuckets = 1000
+
+type SizeConfig struct {
+	Distribution SizeDistribution `json:"distribution"`
+	// sizes are always clamped to [Min, Max]
+	Min metrics.Byte `json:"min"`
+	Max metrics.Byte `json:"max"`
+	// Mu and Sigma parametrize the natural logarithm of the size for LogNormalSize
+	Mu    float64 `json:"mu,omitempty"`
+	Sigma float64 `json:"sigma,omitempty"`
+	// ZipfS is the exponent for ZipfSize, which must be larger than 1. Small sizes are the most frequent
+	ZipfS float64 `json:"zipfS,omitempty"`
+}
+
+type GeneratorConfig struct {
+	Seed uint64     `json:"seed"`
+	Size SizeConfig `json:"size"`
+	// ContentWeights is the relative frequency of each content type
+	ContentWeights map[ContentType]float64 `json:"contentWeights"`
+	// DuplicateRate is the probability of a file being an exact copy of a previous one
+	DuplicateRate float64 `json:"duplicateRate"`
+	// OverlapFraction is the part of an overlapping file copied from a previous one
+	OverlapFraction float64 `json:"overlapFraction,omitempty"`
+	// Edits is the number of bytes changed in a near-duplicate file
+	Edits int `json:"edits,omitempty"`
+}
+
+// a Generator produces a deterministic sequence of synthetic files. The same config always
+// produces the same files, in the same order
+type Generator struct {
+	config       GeneratorConfig
+	rng          *rand.Rand
+	zipf         *rand.Zipf
+	contentTypes []ContentType
+	emitted      []fileRecipe
+	// originals are the emitted files that can serve as base for derived content
+	originals []int
+}
+
+// a GeneratedFile is an entry produced by a Generator. Exact duplicates share the Id of their original
+type GeneratedFile struct {
+	Id   string
+	Blob []byte
+}
+
+// a fileRecipe regenerates a file from its own seed, so the generator never holds previous files in memory
+type fileRecipe struct {
+	id          string
+	seed        uint64
+	size        int
+	contentType ContentType
+	// base is the index of the file derived from, for overlapping and near-duplicate content
+	base int
+}
+
+var textVocabulary = strings.Fields(`the of and to in is was for on that with as by at from this be
+an are or it which not but had have his her they one all were their been has more when who will
+would there no if out so what up about into than them can only other new some could these two may
+first then do any like my now over such our man me even most made after also did many before must
+data storage single instance deduplication blob digest hash metadata header key value`)
+
+func NewGenerator(config GeneratorConfig) (*Generator, error) {
+	if config.Size.Min < 0 || config.Size.Max < config.Size.Min {
+		return nil, fmt.Errorf("invalid size range [%d, %d]", config.Size.Min, config.Size.Max)
+	}
+	if config.DuplicateRate < 0 || config.DuplicateRate >= 1 {
+		return nil, fmt.Errorf("duplicate rate %.2f must be in [0, 1)", config.DuplicateRate)
+	}
+	if config.OverlapFraction < 0 || config.OverlapFraction > 1 {
+		return nil, fmt.Errorf("overlap fraction %.2f must be in [0, 1]", config.OverlapFraction)
+	}
+
+	var contentTypes []ContentType
+	for contentType, weight := range config.ContentWeights {
+		if weight < 0 {
+			return nil, fmt.Errorf("content type '%s' has negative weight", contentType)
+		}
+		switch contentType {
+		case RandomContent, TextContent, OverlappingContent, NearDuplicateContent:
+		default:
+			return nil, fmt.Errorf("unknown content type '%s'", contentType)
+		}
+		contentTypes = append(contentTypes, contentType)
+	}
+	if len(contentTypes) == 0 {
+		config.ContentWeights = map[ContentType]float64{RandomContent: 1}
+		contentTypes = []ContentType{RandomContent}
+	}
+	// map iteration order is random, so the picking order is fixed by sorting
+	slices.Sort(contentTypes)
+
+	rng := rand.New(rand.NewPCG(config.Seed, config.Seed^0x5eed))
+	g := &Generator{
+		config:       config,
+		rng:          rng,
+		contentTypes: contentTypes,
+	}
+
+	switch config.Size.Distribution {
+	case UniformSize, LogNormalSize:
+	case ZipfSize:
+		if config.Size.ZipfS <= 1 {
+			return nil, fmt.Errorf("zipf exponent %.2f must be larger than 1", config.Size.ZipfS)
+		}
+		g.zipf = rand.NewZipf(rng, config.Size.ZipfS, 1, zipfBuckets)
+	default:
+		return nil, fmt.Errorf("unknown size distribution '%s'", config.Size.Distribution)
+	}
+
+	return g, nil
+}
+
+func (g *Generator) Config() GeneratorConfig {
+	return g.config
+}
+
+// Next produces the next file of the sequence
+func (g *Generator) Next() GeneratedFile {
+
+	if len(g.emitted) > 0 && g.rng.Float64() < g.config.DuplicateRate {
+		original := g.emitted[g.rng.IntN(len(g.emitted))]
+		return GeneratedFile{Id: original.id, Blob: g.build(original)}
+	}
+
+	recipe := fileRecipe{
+		id:          fmt.Sprintf("synthetic-%06d", len(g.emitted)+1),
+		seed:        g.rng.Uint64(),
+		size:        g.pickSize(),
+		contentType: g.pickContentType(),
+		base:        -1,
+	}
+
+	derived := recipe.contentType == OverlappingContent || recipe.contentType == NearDuplicateContent
+	if derived && len(g.originals) == 0 {
+		recipe.contentType = RandomContent
+		derived = false
+	}
+	if derived {
+		recipe.base = g.originals[g.rng.IntN(len(g.originals))]
+	} else {
+		g.originals = append(g.originals, len(g.emitted))
+	}
+
+	g.emitted = append(g.emitted, recipe)
+	return GeneratedFile{Id: recipe.id, Blob: g.build(recipe)}
+}
+
+func (g *Generator) pickSize() int {
+	sizeConfig := g.config.Size
+	var size float64
+	switch sizeConfig.Distribution {
+	case UniformSize:
+		size = float64(sizeConfig.Min) + g.rng.Float64()*float64(sizeConfig.Max-sizeConfig.Min)
+	case LogNormalSize:
+		size = math.Exp(sizeConfig.Mu + sizeConfig.Sigma*g.rng.NormFloat64())
+	case ZipfSize:
+		bucket := float64(g.zipf.Uint64())
+		size = float64(sizeConfig.Min) + bucket/zipfBuckets*float64(sizeConfig.Max-sizeConfig.Min)
+	}
+	size = max(float64(sizeConfig.Min), min(float64(sizeConfig.Max), size))
+	return int(size)
+}
+
+func (g *Generator) pickContentType() ContentType {
+	var total float64
+	for _, contentType := range g.contentTypes {
+		total += g.config.ContentWeights[contentType]
+	}
+	target := g.rng.Float64() * total
+	for _, contentType := range g.contentTypes {
+		target -= g.config.ContentWeights[contentType]
+		if target < 0 {
+			return contentType
+		}
+	}
+	return g.contentTypes[len(g.contentTypes)-1]
+}
+
+// build regenerates the contents of recipe
+func (g *Generator) build(recipe fileRecipe) []byte {
+	rng := rand.New(rand.NewPCG(recipe.seed, recipe.seed^0xb10b))
+
+	switch recipe.contentType {
+	case TextContent:
+		return textBlob(rng, recipe.size)
+	case OverlappingContent:
+		base := g.build(g.emitted[recipe.base])
+		blob := randomBlob(rng, recipe.size)
+		overlap := min(len(base), int(float64(recipe.size)*g.config.OverlapFraction))
+		if overlap > 0 {
+			from := rng.IntN(len(base) - overlap + 1)
+			to := rng.IntN(recipe.size - overlap + 1)
+			copy(blob[to:], base[from:from+overlap])
+		}
+		return blob
+	case NearDuplicateContent:
+		blob := g.build(g.emitted[recipe.base])
+		for range g.config.Edits {
+			if len(blob) == 0 {
+				break
+			}
+			blob[rng.IntN(len(blob))] = byte(rng.Uint32())
+		}
+		return blob
+	default:
+		return randomBlob(rng, recipe.size)
+	}
+}
+
+func randomBlob(rng *rand.Rand, size int) []byte {
+	blob := make([]byte, size)
+	for i := range blob {
+		blob[i] = byte(rng.Uint32())
+	}
+	return blob
+}
+
+func textBlob(rng *rand.Rand, size int) []byte {
+	var builder strings.Builder
+	builder.Grow(size)
+	for builder.Len() < size {
+		builder.WriteString(textVocabulary[rng.IntN(len(textVocabulary))])
+		if rng.IntN(12) == 0 {
+			builder.WriteString(".\n")
+		} else {
+			builder.WriteByte(' ')
+		}
+	}
+	return []byte(builder.String()[:size])
+}
+
+// Regenerate returns the contents of the file with the given id, advancing the sequence until
+// that file has been produced. Files are regenerated from their seed, not kept in memory
+func (g *Generator) Regenerate(id string) ([]byte, error) {
+	var number int
+	_, err := fmt.Sscanf(id, "synthetic-%d", &number)
+	if err != nil || number <= 0 {
+		return nil, fmt.Errorf("'%s' is not a generated file id", id)
+	}
+
+	for len(g.emitted) < number {
+		g.Next()
+	}
+
+	return g.build(g.emitted[number-1]), nil
+}
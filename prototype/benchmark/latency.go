@@ -0,0 +1,106 @@
+package benchmark
+
+import (
+	"math"
+	"sis"
+	"sis/internal/metrics"
+	"slices"
+	"sync"
+	"time"
+)
+
+// a LatencyRecorder keeps the duration of every operation so that percentiles can be computed.
+// It is safe for concurrent use
+type LatencyRecorder struct {
+	mu  sync.Mutex
+	ops map[string]*opSamples
+}
+
+type opSamples struct {
+	durations []time.Duration
+	bytes     metrics.Byte
+	errors    int
+	total     time.Duration
+}
+
+// OpReport summarizes every recorded call of an operation
+type OpReport struct {
+	Count  int          `json:"count"`
+	Errors int          `json:"errors"`
+	Bytes  metrics.Byte `json:"bytes"`
+	// Throughput is in bytes per second of time spent in the operation
+	Throughput   float64       `json:"throughput"`
+	OpsPerSecond float64       `json:"opsPerSecond"`
+	P50          time.Duration `json:"p50"`
+	P90          time.Duration `json:"p90"`
+	P99          time.Duration `json:"p99"`
+	Max          time.Duration `json:"max"`
+}
+
+func NewLatencyRecorder() *LatencyRecorder {
+	return &LatencyRecorder{
+		ops: make(map[string]*opSamples),
+	}
+}
+
+func (l *LatencyRecorder) Record(op string, elapsed time.Duration, bytes metrics.Byte, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	samples, ok := l.ops[op]
+	if !ok {
+		samples = &opSamples{}
+		l.ops[op] = samples
+	}
+	samples.durations = append(samples.durations, elapsed)
+	samples.bytes += bytes
+	samples.total += elapsed
+	if err != nil {
+		samples.errors++
+	}
+}
+
+// Middleware records every operation on the wrapped store
+func (l *LatencyRecorder) Middleware() sis.Middleware {
+	return sis.Intercept(func(call *sis.Call) error {
+		start := time.Now()
+		err := call.Next()
+		l.Record(string(call.Op), time.Since(start), call.Bytes, err)
+		return err
+	})
+}
+
+// Summary reports every operation recorded so far
+func (l *LatencyRecorder) Summary() map[string]OpReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	summary := make(map[string]OpReport, len(l.ops))
+	for op, samples := range l.ops {
+		sorted := slices.Clone(samples.durations)
+		slices.Sort(sorted)
+
+		report := OpReport{
+			Count:  len(sorted),
+			Errors: samples.errors,
+			Bytes:  samples.bytes,
+			P50:    percentile(sorted, 0.50),
+			P90:    percentile(sorted, 0.90),
+			P99:    percentile(sorted, 0.99),
+			Max:    sorted[len(sorted)-1],
+		}
+		if seconds := samples.total.Seconds(); seconds > 0 {
+			report.Throughput = float64(samples.bytes) / seconds
+			report.OpsPerSecond = float64(len(sorted)) / seconds
+		}
+		summary[op] = report
+	}
+
+	return summary
+}
+
+// percentile uses the nearest-rank method on sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	return sorted[max(rank-1, 0)]
+}
@@ -0,0 +1,217 @@
+package benchmark
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sis/internal/metrics"
+	"sis/internal/pk"
+	"sync"
+	"time"
+)
+
+type CrawlOptions struct {
+	// Workers is the number of files stored concurrently, runtime.NumCPU() when zero
+	Workers int
+	// MaxInFlightBytes bounds the size of files read but not stored yet, unlimited when zero.
+	// A single file larger than the budget is still crawled, alone
+	MaxInFlightBytes metrics.Byte
+	// Progress, if set, is called after every file, never concurrently
+	Progress func(CrawlProgress)
+}
+
+type CrawlProgress struct {
+	Files      int
+	TotalFiles int
+	Bytes      metrics.Byte
+	TotalBytes metrics.Byte
+	Elapsed    time.Duration
+	// Rate is in bytes per second
+	Rate float64
+	ETA  time.Duration
+}
+
+type CrawlResult struct {
+	Files  int
+	Bytes  metrics.Byte
+	Errors []CrawlError
+}
+
+// a CrawlError is a file that could not be crawled. It does not stop the other files
+type CrawlError struct {
+	SrcPath string
+	DestKey pk.PK
+	Err     error
+}
+
+func (e CrawlError) Error() string {
+	return fmt.Sprintf("error crawling '%s' into '%s': %s", e.SrcPath, e.DestKey.Path(), e.Err)
+}
+
+func (e CrawlError) Unwrap() error {
+	return e.Err
+}
+
+// CrawlParallel stores every file of the source directory not in the checkpoint using a pool of workers. Files that fail
+// are collected in the result, while cancelling ctx stops the crawl and returns ctx.Err() along
+// with what was done so far
+func (c *SISCrawler) CrawlParallel(ctx context.Context, options CrawlOptions) (CrawlResult, error) {
+
+	entries, err := c.pendingFileEntries(ctx)
+	if ctx.Err() != nil {
+		return CrawlResult{}, ctx.Err()
+	}
+	if err != nil {
+		return CrawlResult{}, fmt.Errorf("error generating crawl path: %w", err)
+	}
+
+	workers := options.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	tracker := newCrawlTracker(entries, options.Progress)
+	budget := newByteBudget(ctx, options.MaxInFlightBytes)
+	defer budget.stop()
+
+	queue := make(chan fileEntry)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range queue {
+				err := c.storeEntry(ctx, entry)
+				budget.release(entry.size)
+				tracker.done(entry, err)
+			}
+		}()
+	}
+
+	for _, entry := range entries {
+		err = budget.acquire(entry.size)
+		if err != nil {
+			break
+		}
+		queue <- entry
+	}
+	close(queue)
+	wg.Wait()
+
+	return tracker.result(), ctx.Err()
+}
+
+// a crawlTracker accumulates the outcome of a parallel crawl and reports progress
+type crawlTracker struct {
+	mu         sync.Mutex
+	start      time.Time
+	totalFiles int
+	totalBytes metrics.Byte
+	progress   func(CrawlProgress)
+	res        CrawlResult
+	// handledBytes counts failed files too, so that the ETA reaches zero
+	handledBytes metrics.Byte
+}
+
+func newCrawlTracker(entries []fileEntry, progress func(CrawlProgress)) *crawlTracker {
+	tracker := &crawlTracker{
+		start:      time.Now(),
+		totalFiles: len(entries),
+		progress:   progress,
+	}
+	for _, entry := range entries {
+		tracker.totalBytes += entry.size
+	}
+	return tracker
+}
+
+func (t *crawlTracker) done(entry fileEntry, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.handledBytes += entry.size
+	if err != nil {
+		t.res.Errors = append(t.res.Errors, CrawlError{SrcPath: entry.srcPath, DestKey: entry.destKey, Err: err})
+	} else {
+		t.res.Files++
+		t.res.Bytes += entry.size
+	}
+
+	if t.progress == nil {
+		return
+	}
+
+	elapsed := time.Since(t.start)
+	progress := CrawlProgress{
+		Files:      t.res.Files,
+		TotalFiles: t.totalFiles,
+		Bytes:      t.res.Bytes,
+		TotalBytes: t.totalBytes,
+		Elapsed:    elapsed,
+	}
+	if elapsed > 0 {
+		progress.Rate = float64(t.handledBytes) / elapsed.Seconds()
+	}
+	if progress.Rate > 0 {
+		remaining := float64(t.totalBytes - t.handledBytes)
+		progress.ETA = time.Duration(remaining / progress.Rate * float64(time.Second))
+	}
+	t.progress(progress)
+}
+
+func (t *crawlTracker) result() CrawlResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.res
+}
+
+// a byteBudget blocks acquirers while too many bytes are in flight
+type byteBudget struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	ctx      context.Context
+	max      metrics.Byte
+	inFlight metrics.Byte
+	stop     func() bool
+}
+
+func newByteBudget(ctx context.Context, max metrics.Byte) *byteBudget {
+	b := &byteBudget{
+		ctx: ctx,
+		max: max,
+	}
+	b.cond = sync.NewCond(&b.mu)
+	// waiting acquirers are woken up on cancellation
+	b.stop = context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.cond.Broadcast()
+	})
+	return b
+}
+
+func (b *byteBudget) acquire(size metrics.Byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.max > 0 && b.inFlight > 0 && b.inFlight+size > b.max {
+		if b.ctx.Err() != nil {
+			return b.ctx.Err()
+		}
+		b.cond.Wait()
+	}
+	if b.ctx.Err() != nil {
+		return b.ctx.Err()
+	}
+
+	b.inFlight += size
+	return nil
+}
+
+func (b *byteBudget) release(size metrics.Byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.inFlight -= size
+	b.cond.Broadcast()
+}
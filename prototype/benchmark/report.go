@@ -0,0 +1,132 @@
+package benchmark
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"sis/internal/metrics"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// a Report is the outcome of a benchmark run, meant to be compared across runs
+type Report struct {
+	Name      string    `json:"name"`
+	StartedAt time.Time `json:"startedAt"`
+	// LogicalBytes is the size of everything ingested into the store
+	LogicalBytes metrics.Byte `json:"logicalBytes"`
+	// SysDataBytes and UserDataBytes are measured on disk
+	SysDataBytes  metrics.Byte `json:"sysDataBytes"`
+	UserDataBytes metrics.Byte `json:"userDataBytes"`
+	// MetadataOverhead is the size of blob metadata and data headers
+	MetadataOverhead metrics.Byte `json:"metadataOverhead"`
+	// DedupRatio is LogicalBytes over the bytes of unique blobs
+	DedupRatio float64 `json:"dedupRatio"`
+	// SpaceSaving is the part of LogicalBytes SIS did not need to store, overhead included
+	SpaceSaving           float64             `json:"spaceSaving"`
+	TargetDuplicationRate float64             `json:"targetDuplicationRate"`
+	DuplicationRate       float64             `json:"duplicationRate"`
+	Ops                   map[string]OpReport `json:"ops"`
+}
+
+var reportCSVHeader = []string{
+	"name", "startedAt", "logicalBytes", "sysDataBytes", "userDataBytes", "metadataOverhead",
+	"dedupRatio", "spaceSaving", "targetDuplicationRate", "duplicationRate",
+	"op", "count", "errors", "bytes", "throughput", "opsPerSecond", "p50Ms", "p90Ms", "p99Ms", "maxMs",
+}
+
+// MeasureStoreDir measures sys/data and user/data of a store kept in storeRoot
+func MeasureStoreDir(storeRoot string) (sysData, userData metrics.Byte, err error) {
+	sysData, err = measureIfExists(filepath.Join(storeRoot, "sys", "data"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("error measuring sys/data: %w", err)
+	}
+	userData, err = measureIfExists(filepath.Join(storeRoot, "user", "data"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("error measuring user/data: %w", err)
+	}
+	return sysData, userData, nil
+}
+
+func measureIfExists(dirPath string) (metrics.Byte, error) {
+	entries, err := os.ReadDir(dirPath)
+	if os.IsNotExist(err) || (err == nil && len(entries) == 0) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	info, err := metrics.MeasureDir(dirPath)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (r Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteCSV writes a row per operation, repeating the run columns so that the rows of several runs
+// can be concatenated. The header row is only written when withHeader is set
+func (r Report) WriteCSV(w io.Writer, withHeader bool) error {
+	writer := csv.NewWriter(w)
+
+	if withHeader {
+		err := writer.Write(reportCSVHeader)
+		if err != nil {
+			return fmt.Errorf("error writing csv header: %w", err)
+		}
+	}
+
+	runColumns := []string{
+		r.Name,
+		r.StartedAt.Format(time.RFC3339),
+		strconv.FormatInt(int64(r.LogicalBytes), 10),
+		strconv.FormatInt(int64(r.SysDataBytes), 10),
+		strconv.FormatInt(int64(r.UserDataBytes), 10),
+		strconv.FormatInt(int64(r.MetadataOverhead), 10),
+		formatFloat(r.DedupRatio),
+		formatFloat(r.SpaceSaving),
+		formatFloat(r.TargetDuplicationRate),
+		formatFloat(r.DuplicationRate),
+	}
+
+	for _, op := range slices.Sorted(maps.Keys(r.Ops)) {
+		opReport := r.Ops[op]
+		row := append(slices.Clone(runColumns),
+			op,
+			strconv.Itoa(opReport.Count),
+			strconv.Itoa(opReport.Errors),
+			strconv.FormatInt(int64(opReport.Bytes), 10),
+			formatFloat(opReport.Throughput),
+			formatFloat(opReport.OpsPerSecond),
+			formatMs(opReport.P50),
+			formatMs(opReport.P90),
+			formatMs(opReport.P99),
+			formatMs(opReport.Max),
+		)
+		err := writer.Write(row)
+		if err != nil {
+			return fmt.Errorf("error writing csv row: %w", err)
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 4, 64)
+}
+
+func formatMs(d time.Duration) string {
+	return formatFloat(float64(d) / float64(time.Millisecond))
+}
@@ -1,27 +1,51 @@
 package benchmark
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sis"
+	"sis/internal/data"
+	"sis/internal/metrics"
 	"sis/internal/pk"
+	"strings"
 )
 
 // a SIS Crawler takes every file from a local srcDir and saves it to a SIS instance
 type SISCrawler struct {
-	sisInstance    sis.SIS
+	store          sis.Store
 	srcDir         string
 	crawlPath      []fileEntry
 	alreadyCrawled bool
+	// checkpoint is nil unless EnableCheckpoint was called
+	checkpoint *crawlCheckpoint
+
+	destPrefix   pk.PK
+	relativeKeys bool
+	include      []string
+	exclude      []string
+	minSize      metrics.Byte
+	maxSize      metrics.Byte
+	symlinks     SymlinkPolicy
+	hidden       HiddenPolicy
+	fileMetadata bool
 }
 
 type fileEntry struct {
 	destKey pk.PK
 	srcPath string
+	size    metrics.Byte
+	// linkTarget is set for symbolic links stored as such, and is the blob instead of the file contents
+	linkTarget string
+	metadata   map[string]any
 }
 
-func NewSISCrawler(sisInstance sis.SIS, srcDir string) (*SISCrawler, error) {
+// NewSISCrawler returns a crawler of srcDir. By default every file is crawled, symlinks are followed
+// and keys are the absolute source paths
+func NewSISCrawler(store sis.Store, srcDir string, options ...CrawlerOption) (*SISCrawler, error) {
 	info, err := os.Stat(srcDir)
 	if err != nil {
 		return nil, fmt.Errorf("error getting srcDir info: %w", err)
@@ -29,16 +53,20 @@ func NewSISCrawler(sisInstance sis.SIS, srcDir string) (*SISCrawler, error) {
 	if !info.IsDir() {
 		return nil, fmt.Errorf("srcDir '%s' is not a directory", srcDir)
 	}
-	return &SISCrawler{
-		sisInstance: sisInstance,
-		srcDir:      srcDir,
-	}, nil
+	crawler := &SISCrawler{
+		store:  store,
+		srcDir: srcDir,
+	}
+	for _, option := range options {
+		option(crawler)
+	}
+	return crawler, nil
 }
 
-func (c *SISCrawler) CrawlAll() error {
+func (c *SISCrawler) CrawlAll(ctx context.Context) error {
 	var err error
 	for err == nil {
-		err = c.Crawl()
+		err = c.Crawl(ctx)
 	}
 	if err != ErrEOF {
 		return fmt.Errorf("unexpected error during crawling: %w", err)
@@ -46,10 +74,10 @@ func (c *SISCrawler) CrawlAll() error {
 	return nil
 }
 
-func (c *SISCrawler) Crawl() error {
+func (c *SISCrawler) Crawl(ctx context.Context) error {
 
 	if !c.alreadyCrawled {
-		err := c.generateCrawlPath()
+		err := c.generateCrawlPath(ctx)
 		if err != nil {
 			return fmt.Errorf("error generating crawl path: %w", err)
 		}
@@ -61,13 +89,9 @@ func (c *SISCrawler) Crawl() error {
 
 	c.alreadyCrawled = true
 	currEntry := c.crawlPath[0]
-	fileBytes, err := os.ReadFile(currEntry.srcPath)
+	err := c.storeEntry(ctx, currEntry)
 	if err != nil {
-		return fmt.Errorf("error reading source file '%s': %w", currEntry.srcPath, err)
-	}
-	err = c.sisInstance.Create(currEntry.destKey, fileBytes)
-	if err != nil {
-		return fmt.Errorf("error creating destination file '%s': %w", currEntry.destKey.Path(), err)
+		return fmt.Errorf("error crawling '%s' into '%s': %w", currEntry.srcPath, currEntry.destKey.Path(), err)
 	}
 
 	if len(c.crawlPath) > 1 {
@@ -79,9 +103,9 @@ func (c *SISCrawler) Crawl() error {
 	return nil
 }
 
-func (c *SISCrawler) generateCrawlPath() error {
+func (c *SISCrawler) generateCrawlPath(ctx context.Context) error {
 
-	fileEntries, err := c.getDirFileEntries(c.srcDir)
+	fileEntries, err := c.pendingFileEntries(ctx)
 	if err != nil {
 		return fmt.Errorf("error getting file entries: %w", err)
 	}
@@ -89,7 +113,73 @@ func (c *SISCrawler) generateCrawlPath() error {
 	return nil
 }
 
-func (c *SISCrawler) getDirFileEntries(dirPath string) ([]fileEntry, error) {
+// pendingFileEntries lists the files of srcDir not recorded in the checkpoint
+func (c *SISCrawler) pendingFileEntries(ctx context.Context) ([]fileEntry, error) {
+
+	fileEntries, err := c.getDirFileEntries(ctx, c.srcDir, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+	if c.checkpoint == nil {
+		return fileEntries, nil
+	}
+
+	var pending []fileEntry
+	for _, entry := range fileEntries {
+		if !c.checkpoint.isCompleted(entry.srcPath) {
+			pending = append(pending, entry)
+		}
+	}
+	return pending, nil
+}
+
+func (c *SISCrawler) markCompleted(entry fileEntry) error {
+	if c.checkpoint == nil {
+		return nil
+	}
+	return c.checkpoint.markCompleted(entry.srcPath)
+}
+
+// storeEntry creates the key of entry along with its metadata and records it in the checkpoint
+func (c *SISCrawler) storeEntry(ctx context.Context, entry fileEntry) error {
+	var r io.Reader = strings.NewReader(entry.linkTarget)
+	if entry.linkTarget == "" {
+		f, err := os.Open(entry.srcPath)
+		if err != nil {
+			return fmt.Errorf("error opening source file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	_, err := c.store.CreateWithMetadata(ctx, entry.destKey, r, entry.metadata)
+	if err != nil {
+		return fmt.Errorf("error creating destination file: %w", err)
+	}
+	// once the key exists it is finished and checkpointed, so a cancelled crawl can resume from it
+	err = c.markCompleted(entry)
+	if err != nil {
+		return fmt.Errorf("error saving checkpoint: %w", err)
+	}
+	return nil
+}
+
+// getDirFileEntries lists the files to crawl below dirPath. ancestors holds the resolved paths of
+// the directories being walked, so that following a link back into one of them does not loop
+func (c *SISCrawler) getDirFileEntries(ctx context.Context, dirPath string, ancestors map[string]bool) ([]fileEntry, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	realPath, err := filepath.EvalSymlinks(dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving dir '%s': %w", dirPath, err)
+	}
+	if ancestors[realPath] {
+		return nil, nil
+	}
+	ancestors[realPath] = true
+	defer delete(ancestors, realPath)
 
 	dirEntries, err := os.ReadDir(dirPath)
 	if err != nil {
@@ -99,17 +189,71 @@ func (c *SISCrawler) getDirFileEntries(dirPath string) ([]fileEntry, error) {
 	var fileEntries []fileEntry
 	for _, entry := range dirEntries {
 		entryPath := filepath.Join(dirPath, entry.Name())
-		if entry.IsDir() {
-			currFileEntries, err := c.getDirFileEntries(entryPath)
+		if c.isExcluded(entryPath) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving info of '%s': %w", entryPath, err)
+		}
+
+		var linkTarget string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			switch c.symlinks {
+			case SkipSymlinks:
+				continue
+			case StoreSymlinks:
+				linkTarget, err = os.Readlink(entryPath)
+				if err != nil {
+					return nil, fmt.Errorf("error reading link '%s': %w", entryPath, err)
+				}
+			default:
+				info, err = os.Stat(entryPath)
+				if err != nil {
+					// a dangling link has nothing to follow
+					continue
+				}
+			}
+		}
+
+		if info.IsDir() {
+			currFileEntries, err := c.getDirFileEntries(ctx, entryPath, ancestors)
 			if err != nil {
 				return nil, fmt.Errorf("error reading dir '%s': %w", dirPath, err)
 			}
 			fileEntries = append(fileEntries, currFileEntries...)
 			continue
 		}
+		if linkTarget == "" && !info.Mode().IsRegular() {
+			continue
+		}
+
+		size := metrics.Byte(info.Size())
+		if linkTarget != "" {
+			size = metrics.Byte(len(linkTarget))
+		}
+		if !c.isIncluded(entryPath, size) {
+			continue
+		}
+
+		destKey, err := c.destKeyOf(entryPath)
+		if err != nil {
+			return nil, fmt.Errorf("error mapping '%s' to a key: %w", entryPath, err)
+		}
 		currFileEntry := fileEntry{
-			srcPath: entryPath,
-			destKey: pk.New(entryPath),
+			srcPath:    entryPath,
+			destKey:    destKey,
+			size:       size,
+			linkTarget: linkTarget,
+		}
+		if c.fileMetadata {
+			currFileEntry.metadata = data.FileMetadata(info.Mode(), info.ModTime())
+		}
+		if linkTarget != "" {
+			if currFileEntry.metadata == nil {
+				currFileEntry.metadata = make(map[string]any)
+			}
+			currFileEntry.metadata[data.MetadataSymlink] = linkTarget
 		}
 		fileEntries = append(fileEntries, currFileEntry)
 	}
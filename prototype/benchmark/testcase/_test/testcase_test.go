@@ -1,25 +1,63 @@
 package testcase_test
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
 	"sis"
+	"sis/benchmark"
 	"sis/benchmark/testcase"
 	"sis/internal/crud/crudos"
 	"sis/internal/metrics"
 	"testing"
 )
 
-const OpenImagesDataDir = "/home/miguel/tcc/tcc/data"
+// generateSourceDir fills a temporary dir with files from a Generator, to stand in for a real dataset
+func generateSourceDir(t *testing.T, files int) string {
+	generator, err := benchmark.NewGenerator(benchmark.GeneratorConfig{
+		Seed: 7,
+		Size: benchmark.SizeConfig{
+			Distribution: benchmark.LogNormalSize,
+			Min:          metrics.KB(1),
+			Max:          metrics.KB(256),
+			Mu:           10,
+			Sigma:        1,
+		},
+		ContentWeights: map[benchmark.ContentType]float64{
+			benchmark.RandomContent: 1,
+			benchmark.TextContent:   1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("error creating generator: %s", err.Error())
+	}
+
+	dir := t.TempDir()
+	for i := range files {
+		err = os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d.bin", i)), generator.Next().Blob, 0666)
+		if err != nil {
+			t.Fatalf("error writing source file: %s", err.Error())
+		}
+	}
+	return dir
+}
 
 func TestSetEntryweights(t *testing.T) {
+	ctx := t.Context()
 
 	h := sha256.New()
-	crudOs, err := crudos.New("./data/test1/root")
+	dir := t.TempDir()
+	crudOs, err := crudos.New(filepath.Join(dir, "root"))
 	if err != nil {
 		t.Fatalf("error creating crudos instance: %s", err.Error())
 	}
-	sisInstance := sis.New(h, crudOs)
-	testCase, err := testcase.NewTestCase(sisInstance, "data/test1", OpenImagesDataDir, "./log", metrics.MB(50), 0.3)
+	sisInstance, err := sis.New(ctx, h, crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+	testCase, err := testcase.NewTestCase(&sisInstance, dir, generateSourceDir(t, 20), "./log", metrics.MB(50), 0.3, 1)
 	if err != nil {
 		t.Fatalf("error creating test case: %s", err.Error())
 	}
@@ -37,8 +75,11 @@ func TestSetEntryweights(t *testing.T) {
 // 	if err != nil {
 // 		t.Fatalf("error creating crudos instance: %s", err.Error())
 // 	}
-// 	sisInstance := sis.New(h, crudOs)
-// 	testCase, err := testcase.NewTestCase(sisInstance, "test2", OpenImagesDataDir, "./log", metrics.MB(100), 0.3)
+// 	sisInstance, err := sis.New(ctx, h, crudOs)
+// 	if err != nil {
+// 		t.Fatalf("error creating sis instance: %s", err.Error())
+// 	}
+// 	testCase, err := testcase.NewTestCase(&sisInstance, "test2", "./source", "./log", metrics.MB(100), 0.3, 1)
 // 	if err != nil {
 // 		t.Fatalf("error creating test case: %s", err.Error())
 // 	}
@@ -50,29 +91,176 @@ func TestSetEntryweights(t *testing.T) {
 // }
 
 func TestSISCrawlAll(t *testing.T) {
+	ctx := t.Context()
+	h := sha256.New()
+	dir := t.TempDir()
+	crudOs, err := crudos.New(filepath.Join(dir, "sis"))
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, h, crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+	testCase, err := testcase.NewTestCase(&sisInstance, dir, generateSourceDir(t, 40), "./log", metrics.MB(5), 0.5, 1)
+	if err != nil {
+		t.Fatalf("error creating test case: %s", err.Error())
+	}
+
+	err = testCase.GenerateTestData(ctx)
+	if err != nil {
+		t.Fatalf("error generating control space: %s", err.Error())
+	}
+
+	err = testCase.PopulateSIS(ctx)
+	if err != nil {
+		t.Fatalf("error populating SIS: %s", err.Error())
+	}
+
+	err = testCase.CompareOriginalWithSIS(ctx)
+	if err != nil {
+		t.Fatalf("error on original comparison with SIS: %s", err.Error())
+	}
+}
+
+func TestSyntheticCrawlAll(t *testing.T) {
+	ctx := t.Context()
 	h := sha256.New()
-	crudOs, err := crudos.New("./data/test4/sis")
+	dir := t.TempDir()
+	sisDir := filepath.Join(dir, "sis")
+	crudOs, err := crudos.New(sisDir)
 	if err != nil {
 		t.Fatalf("error creating crudos instance: %s", err.Error())
 	}
-	sisInstance := sis.New(h, crudOs)
-	testCase, err := testcase.NewTestCase(sisInstance, "data/test4", OpenImagesDataDir, "./log", metrics.GB(1), 0.5)
+	sisInstance, err := sis.New(ctx, h, crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+	generator, err := benchmark.NewGenerator(benchmark.GeneratorConfig{
+		Seed: 42,
+		Size: benchmark.SizeConfig{
+			Distribution: benchmark.LogNormalSize,
+			Min:          metrics.KB(1),
+			Max:          metrics.KB(256),
+			Mu:           10,
+			Sigma:        1,
+		},
+		ContentWeights: map[benchmark.ContentType]float64{
+			benchmark.RandomContent:        1,
+			benchmark.TextContent:          1,
+			benchmark.OverlappingContent:   1,
+			benchmark.NearDuplicateContent: 1,
+		},
+		DuplicateRate:   0.2,
+		OverlapFraction: 0.5,
+		Edits:           4,
+	})
+	if err != nil {
+		t.Fatalf("error creating generator: %s", err.Error())
+	}
+	testCase, err := testcase.NewSyntheticTestCase(&sisInstance, dir, generator, metrics.MB(5), 0.3)
 	if err != nil {
 		t.Fatalf("error creating test case: %s", err.Error())
 	}
 
-	err = testCase.GenerateTestData()
+	err = testCase.GenerateTestData(ctx)
 	if err != nil {
 		t.Fatalf("error generating control space: %s", err.Error())
 	}
 
-	err = testCase.PopulateSIS()
+	err = testCase.PopulateSIS(ctx)
 	if err != nil {
 		t.Fatalf("error populating SIS: %s", err.Error())
 	}
 
-	err = testCase.CompareOriginalWithSIS()
+	err = testCase.CompareOriginalWithSIS(ctx)
 	if err != nil {
 		t.Fatalf("error on original comparison with SIS: %s", err.Error())
 	}
+
+	report, err := testCase.Report(ctx, sisDir)
+	if err != nil {
+		t.Fatalf("error building report: %s", err.Error())
+	}
+	if report.Ops["createWithMetadata"].Count == 0 || report.Ops["read"].Count != report.Ops["createWithMetadata"].Count {
+		t.Fatalf("expected every created entry to be read back, got %+v", report.Ops)
+	}
+	if report.DedupRatio <= 1 {
+		t.Fatalf("expected duplicated test data to be deduplicated, got ratio %.2f", report.DedupRatio)
+	}
+
+	err = testCase.SaveReport(ctx, sisDir)
+	if err != nil {
+		t.Fatalf("error saving report: %s", err.Error())
+	}
+}
+
+func TestReplayTestData(t *testing.T) {
+	ctx := t.Context()
+	srcDir := t.TempDir()
+	for i := range 20 {
+		content := bytes.Repeat([]byte{byte(i)}, 1000*(i+1))
+		err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("file%d.bin", i)), content, 0666)
+		if err != nil {
+			t.Fatalf("error writing source file: %s", err.Error())
+		}
+	}
+
+	h := sha256.New()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, h, crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+
+	original, err := testcase.NewTestCase(&sisInstance, "data/test6", srcDir, "./log", metrics.KB(200), 0.3, 99)
+	if err != nil {
+		t.Fatalf("error creating test case: %s", err.Error())
+	}
+	err = original.GenerateTestData(ctx)
+	if err != nil {
+		t.Fatalf("error generating test data: %s", err.Error())
+	}
+
+	replayed, err := testcase.NewTestCase(&sisInstance, "data/test7", srcDir, "./log", metrics.KB(200), 0.3, 0)
+	if err != nil {
+		t.Fatalf("error creating test case: %s", err.Error())
+	}
+	err = replayed.ReplayTestData(ctx, "data/test6/testdata/info")
+	if err != nil {
+		t.Fatalf("error replaying test data: %s", err.Error())
+	}
+
+	originalEntries, err := os.ReadDir("data/test6/testdata/data")
+	if err != nil {
+		t.Fatalf("error reading original data: %s", err.Error())
+	}
+	replayedEntries, err := os.ReadDir("data/test7/testdata/data")
+	if err != nil {
+		t.Fatalf("error reading replayed data: %s", err.Error())
+	}
+	if len(originalEntries) != len(replayedEntries) {
+		t.Fatalf("original has %d entries, replay has %d", len(originalEntries), len(replayedEntries))
+	}
+	for i, entry := range originalEntries {
+		if replayedEntries[i].Name() != entry.Name() {
+			t.Fatalf("entry %d is '%s' on original and '%s' on replay", i, entry.Name(), replayedEntries[i].Name())
+		}
+		originalContent, _ := os.ReadFile(filepath.Join("data/test6/testdata/data", entry.Name()))
+		replayedContent, _ := os.ReadFile(filepath.Join("data/test7/testdata/data", entry.Name()))
+		if !bytes.Equal(originalContent, replayedContent) {
+			t.Fatalf("entry '%s' differs between original and replay", entry.Name())
+		}
+	}
+
+	for _, infoFile := range []string{"info.json", "log.json"} {
+		originalInfo, _ := os.ReadFile(filepath.Join("data/test6/testdata/info", infoFile))
+		replayedInfo, _ := os.ReadFile(filepath.Join("data/test7/testdata/info", infoFile))
+		if !bytes.Equal(originalInfo, replayedInfo) {
+			t.Fatalf("'%s' differs between original and replay", infoFile)
+		}
+	}
 }
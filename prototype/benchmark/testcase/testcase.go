@@ -1,23 +1,30 @@
 package testcase
 
 import (
+	"context"
 	"fmt"
-	"math/rand"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
 	"sis"
 	"sis/benchmark"
 	"sis/internal/metrics"
 	"sis/internal/pk"
+	"time"
 )
 
 type TestCase struct {
-	// sisInstance is the sis.SIS instance being tested
-	sisInstance sis.SIS
+	// store is the sis.Store being tested
+	store sis.Store
 	// srcDir is the path to a local directory which contains all available source data to use while testing
 	srcDir string
+	// generator produces synthetic source data instead of srcDir when set
+	generator *benchmark.Generator
 	// entryWeights is used to compute the probability of a random entry on the dataset being picked
 	entryWeights []float64
+	// seed drives rng, which picks every entry, so the same seed and source always produce the same test data
+	seed uint64
+	rng  *rand.Rand
 	// testNamespace is the root PK for every persistence inside a test case
 	testNamespace pk.PK
 	// testData is a benchmark.TestData instance with a duplicated dataset generated from srcDir
@@ -26,10 +33,16 @@ type TestCase struct {
 	maxSize metrics.Byte
 	// expectedDuplicationRate is the approximate rate of duplicated bytes on the test data - if 0.5, roughly 50% of the space is consumed by duplicated files
 	expectedDuplicationRate float64
+	// recorder times every operation on store
+	recorder *benchmark.LatencyRecorder
+	// unrecordedStore is store without the recorder, used to measure it
+	unrecordedStore sis.Store
+	// startedAt is when the test case was created
+	startedAt time.Time
 }
 
-func NewTestCase(sisInstance sis.SIS, name, sourceDir, logFilePath string, maxSize metrics.Byte, expectedDuplicationRate float64) (*TestCase, error) {
-	testNamespace := pk.New(name)
+func NewTestCase(store sis.Store, name, sourceDir, logFilePath string, maxSize metrics.Byte, expectedDuplicationRate float64, seed uint64) (*TestCase, error) {
+	testNamespace := pk.New(filepath.ToSlash(name))
 	if expectedDuplicationRate > 0.5 {
 		fmt.Printf("duplication rate %.2f cannot be larger than 50%%\nfixing at 0.50\n", expectedDuplicationRate)
 		expectedDuplicationRate = 0.5
@@ -53,12 +66,18 @@ func NewTestCase(sisInstance sis.SIS, name, sourceDir, logFilePath string, maxSi
 		}
 	}
 
+	recorder := benchmark.NewLatencyRecorder()
 	testCase := &TestCase{
-		sisInstance:             sisInstance,
+		store:                   sis.Chain(store, recorder.Middleware()),
 		srcDir:                  sourceDir,
+		seed:                    seed,
+		rng:                     rand.New(rand.NewPCG(seed, seed)),
 		testNamespace:           testNamespace,
 		maxSize:                 maxSize,
 		expectedDuplicationRate: expectedDuplicationRate,
+		recorder:                recorder,
+		unrecordedStore:         store,
+		startedAt:               time.Now(),
 	}
 
 	err = testCase.setUpDirectories()
@@ -69,9 +88,38 @@ func NewTestCase(sisInstance sis.SIS, name, sourceDir, logFilePath string, maxSi
 	return testCase, nil
 }
 
+// NewSyntheticTestCase creates a test case whose data comes from generator instead of a source directory
+func NewSyntheticTestCase(store sis.Store, name string, generator *benchmark.Generator, maxSize metrics.Byte, expectedDuplicationRate float64) (*TestCase, error) {
+	testNamespace := pk.New(filepath.ToSlash(name))
+	if expectedDuplicationRate > 0.5 {
+		fmt.Printf("duplication rate %.2f cannot be larger than 50%%\nfixing at 0.50\n", expectedDuplicationRate)
+		expectedDuplicationRate = 0.5
+	}
+
+	recorder := benchmark.NewLatencyRecorder()
+	testCase := &TestCase{
+		store:                   sis.Chain(store, recorder.Middleware()),
+		generator:               generator,
+		seed:                    generator.Config().Seed,
+		testNamespace:           testNamespace,
+		maxSize:                 maxSize,
+		expectedDuplicationRate: expectedDuplicationRate,
+		recorder:                recorder,
+		unrecordedStore:         store,
+		startedAt:               time.Now(),
+	}
+
+	err := testCase.setUpDirectories()
+	if err != nil {
+		return nil, fmt.Errorf("error setting up testcase directories: %w", err)
+	}
+
+	return testCase, nil
+}
+
 func (t *TestCase) setUpDirectories() error {
 
-	root := t.testNamespace.Path()
+	root := filepath.FromSlash(t.testNamespace.Path())
 	testCaseDataPath := filepath.Join(root, "testdata", "data")
 	testCaseInfoPath := filepath.Join(root, "testdata", "info")
 
@@ -93,7 +141,7 @@ func (t *TestCase) testDataPk() pk.PK {
 }
 
 // Reads the original data and the SIS data to find if there are entries lacking, or content was altered
-func (t *TestCase) CompareOriginalWithSIS() error {
+func (t *TestCase) CompareOriginalWithSIS(ctx context.Context) error {
 
 	entries, err := t.testData.GetDataEntryPaths()
 	if err != nil {
@@ -106,8 +154,11 @@ func (t *TestCase) CompareOriginalWithSIS() error {
 		if err != nil {
 			return fmt.Errorf("error reading original file '%s': %w", entry, err)
 		}
-		pk := pk.New(entry)
-		sisContent, err := t.sisInstance.Read(pk)
+		pk, err := pk.FromPath(entry)
+		if err != nil {
+			return fmt.Errorf("error mapping '%s' to a key: %w", entry, err)
+		}
+		sisContent, err := t.store.Read(ctx, pk)
 		if err != nil {
 			return fmt.Errorf("error reading SIS file '%s': %w", pk, err)
 		}
@@ -121,15 +172,15 @@ func (t *TestCase) CompareOriginalWithSIS() error {
 	return nil
 }
 
-func (t *TestCase) PopulateSIS() error {
+func (t *TestCase) PopulateSIS(ctx context.Context) error {
 
 	testDataDir := t.testData.DataDir()
-	crawler, err := benchmark.NewSISCrawler(t.sisInstance, testDataDir)
+	crawler, err := benchmark.NewSISCrawler(t.store, testDataDir)
 	if err != nil {
 		return fmt.Errorf("error creating sis crawler: %w", err)
 	}
 
-	err = crawler.CrawlAll()
+	err = crawler.CrawlAll(ctx)
 	if err != nil {
 		return fmt.Errorf("error crawling through source directory: %w", err)
 	}
@@ -137,7 +188,27 @@ func (t *TestCase) PopulateSIS() error {
 	return nil
 }
 
-func (t *TestCase) GenerateTestData() error {
+// PopulateSISParallel is PopulateSIS with a parallel crawl. It fails if any file could not be stored
+func (t *TestCase) PopulateSISParallel(ctx context.Context, options benchmark.CrawlOptions) error {
+
+	testDataDir := t.testData.DataDir()
+	crawler, err := benchmark.NewSISCrawler(t.store, testDataDir)
+	if err != nil {
+		return fmt.Errorf("error creating sis crawler: %w", err)
+	}
+
+	result, err := crawler.CrawlParallel(ctx, options)
+	if err != nil {
+		return fmt.Errorf("error crawling through source directory: %w", err)
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("%d files failed to crawl, first: %w", len(result.Errors), result.Errors[0])
+	}
+
+	return nil
+}
+
+func (t *TestCase) GenerateTestData(ctx context.Context) error {
 
 	testDataPk := t.testDataPk()
 	testData, err := benchmark.NewTestData(testDataPk, t.maxSize, t.expectedDuplicationRate)
@@ -148,6 +219,24 @@ func (t *TestCase) GenerateTestData() error {
 
 	fmt.Println("starting test data generation")
 
+	config, err := t.config()
+	if err != nil {
+		return fmt.Errorf("error recording test config: %w", err)
+	}
+	t.testData.SetConfig(config)
+
+	if t.generator != nil {
+		err = t.testData.Fill(ctx, t.generator)
+		if err != nil {
+			return fmt.Errorf("error filling test data from generator: %w", err)
+		}
+		err = t.testData.SaveLog(ctx)
+		if err != nil {
+			return fmt.Errorf("error saving log: %w", err)
+		}
+		return t.finishTestData(ctx)
+	}
+
 	err = t.SetEntryWeights()
 	if err != nil {
 		return fmt.Errorf("error setting entry weights: %w", err)
@@ -158,20 +247,26 @@ func (t *TestCase) GenerateTestData() error {
 		if err != nil {
 			return fmt.Errorf("error picking random file from source: %w", err)
 		}
-		err = t.testData.AddFile(picked)
+		err = t.testData.AddFile(ctx, picked)
 		if err != nil {
 			if err == benchmark.ErrSpaceFull {
 				break
 			}
 			return fmt.Errorf("error adding local file to control space: %w", err)
 		}
-		err = t.testData.SaveLog()
+		err = t.testData.SaveLog(ctx)
 		if err != nil {
 			return fmt.Errorf("error saving log: %w", err)
 		}
 	}
 
-	err = t.testData.SaveTestInfo()
+	return t.finishTestData(ctx)
+
+}
+
+func (t *TestCase) finishTestData(ctx context.Context) error {
+
+	err := t.testData.SaveTestInfo(ctx)
 	if err != nil {
 		return fmt.Errorf("error saving test info: %w", err)
 	}
@@ -187,6 +282,73 @@ func (t *TestCase) GenerateTestData() error {
 
 }
 
+// Report measures the store kept in storeRoot against the test data, along with every operation
+// the test case ran on the store so far
+func (t *TestCase) Report(ctx context.Context, storeRoot string) (benchmark.Report, error) {
+
+	usage, err := t.unrecordedStore.Usage(ctx)
+	if err != nil {
+		return benchmark.Report{}, fmt.Errorf("error measuring store usage: %w", err)
+	}
+
+	sysData, userData, err := benchmark.MeasureStoreDir(storeRoot)
+	if err != nil {
+		return benchmark.Report{}, fmt.Errorf("error measuring store dir: %w", err)
+	}
+
+	info := t.testData.Info()
+	report := benchmark.Report{
+		Name:                  t.testNamespace.Path(),
+		StartedAt:             t.startedAt,
+		LogicalBytes:          info.Size,
+		SysDataBytes:          sysData,
+		UserDataBytes:         userData,
+		MetadataOverhead:      usage.MetadataBytes + usage.HeaderBytes,
+		DedupRatio:            usage.DedupRatio(),
+		TargetDuplicationRate: t.expectedDuplicationRate,
+		DuplicationRate:       info.DuplicationRate,
+		Ops:                   t.recorder.Summary(),
+	}
+	if info.Size > 0 {
+		report.SpaceSaving = 1 - float64(sysData+userData)/float64(info.Size)
+	}
+
+	return report, nil
+}
+
+// SaveReport writes the report as report.json and report.csv next to the test data
+func (t *TestCase) SaveReport(ctx context.Context, storeRoot string) error {
+
+	report, err := t.Report(ctx, storeRoot)
+	if err != nil {
+		return fmt.Errorf("error building report: %w", err)
+	}
+
+	reportDir := filepath.FromSlash(t.testNamespace.Path())
+
+	jsonFile, err := os.Create(filepath.Join(reportDir, "report.json"))
+	if err != nil {
+		return fmt.Errorf("error creating json report: %w", err)
+	}
+	defer jsonFile.Close()
+	err = report.WriteJSON(jsonFile)
+	if err != nil {
+		return fmt.Errorf("error writing json report: %w", err)
+	}
+
+	csvFile, err := os.Create(filepath.Join(reportDir, "report.csv"))
+	if err != nil {
+		return fmt.Errorf("error creating csv report: %w", err)
+	}
+	defer csvFile.Close()
+	err = report.WriteCSV(csvFile, true)
+	if err != nil {
+		return fmt.Errorf("error writing csv report: %w", err)
+	}
+
+	return nil
+}
+
 func (t *TestCase) SetEntryWeights() error {
 
 	entries, err := os.ReadDir(t.srcDir)
@@ -235,16 +397,109 @@ func (t *TestCase) pickRandomPathFromSource() (string, error) {
 }
 
 func (t *TestCase) getWeightedRandomIndex() int {
-	randomPercentage := rand.Float64()
+	randomPercentage := t.rng.Float64()
 	var sum float64
-	var currIndex int
-	for sum < randomPercentage {
-		sum += t.entryWeights[currIndex]
-		currIndex++
-		if currIndex > len(t.entryWeights) {
-			break
+	for i, weight := range t.entryWeights {
+		sum += weight
+		if randomPercentage < sum {
+			return i
 		}
 	}
-	currIndex--
-	return currIndex
+	// rounding can leave the weights summing slightly below 1
+	return len(t.entryWeights) - 1
+}
+
+// config records everything GenerateTestData depends on
+func (t *TestCase) config() (benchmark.TestConfig, error) {
+	config := benchmark.TestConfig{
+		Seed:                  t.seed,
+		MaxSize:               t.maxSize,
+		TargetDuplicationRate: t.expectedDuplicationRate,
+	}
+
+	if t.generator != nil {
+		generatorConfig := t.generator.Config()
+		config.Generator = &generatorConfig
+		return config, nil
+	}
+
+	srcDirHash, err := benchmark.HashDir(t.srcDir)
+	if err != nil {
+		return benchmark.TestConfig{}, fmt.Errorf("error hashing source dir: %w", err)
+	}
+	config.SourceDir = t.srcDir
+	config.SourceDirHash = srcDirHash
+	config.WeightsStrategy = benchmark.InverseSizeWeights
+
+	return config, nil
+}
+
+// ReplayTestData regenerates, byte for byte, the test data described by the info.json and log.json
+// saved in infoDir by a previous GenerateTestData. Test data copied from a source directory needs
+// that directory to be unchanged, which is checked against the recorded hash
+func (t *TestCase) ReplayTestData(ctx context.Context, infoDir string) error {
+
+	info, err := benchmark.LoadTestInfo(infoDir)
+	if err != nil {
+		return fmt.Errorf("error loading test info: %w", err)
+	}
+
+	entries, err := benchmark.LoadLog(infoDir)
+	if err != nil {
+		return fmt.Errorf("error loading log: %w", err)
+	}
+
+	var generator *benchmark.Generator
+	if info.Config.Generator != nil {
+		generator, err = benchmark.NewGenerator(*info.Config.Generator)
+		if err != nil {
+			return fmt.Errorf("error creating generator: %w", err)
+		}
+	} else {
+		srcDirHash, err := benchmark.HashDir(t.srcDir)
+		if err != nil {
+			return fmt.Errorf("error hashing source dir: %w", err)
+		}
+		if srcDirHash != info.Config.SourceDirHash {
+			return fmt.Errorf("source dir '%s' changed since the test data was generated", t.srcDir)
+		}
+	}
+
+	testData, err := benchmark.NewTestData(t.testDataPk(), info.Config.MaxSize, info.Config.TargetDuplicationRate)
+	if err != nil {
+		return fmt.Errorf("error creating test data instance: %w", err)
+	}
+	t.testData = *testData
+	t.testData.SetConfig(info.Config)
+	t.seed = info.Config.Seed
+	t.maxSize = info.Config.MaxSize
+	t.expectedDuplicationRate = info.Config.TargetDuplicationRate
+
+	fmt.Printf("replaying %d test data entries\n", len(entries))
+
+	for _, entry := range entries {
+		var blob []byte
+		if generator != nil {
+			blob, err = generator.Regenerate(entry.Id)
+		} else {
+			blob, err = os.ReadFile(filepath.Join(t.srcDir, entry.Source))
+		}
+		if err != nil {
+			return fmt.Errorf("error reading source of '%s': %w", entry.Id, err)
+		}
+		if metrics.Byte(len(blob)) != entry.SingleSize {
+			return fmt.Errorf("source of '%s' has %d bytes, log expects %d", entry.Id, len(blob), entry.SingleSize)
+		}
+		err = t.testData.AddCopy(ctx, entry.Source, entry.Id, blob)
+		if err != nil {
+			return fmt.Errorf("error adding '%s': %w", entry.Id, err)
+		}
+	}
+
+	err = t.testData.SaveLog(ctx)
+	if err != nil {
+		return fmt.Errorf("error saving log: %w", err)
+	}
+
+	return t.finishTestData(ctx)
 }
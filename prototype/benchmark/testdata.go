@@ -1,6 +1,8 @@
 package benchmark
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -20,34 +22,54 @@ type TestData struct {
 	duplicationRate, duplicationRateTarget float64
 	entriesMap                             map[string]EntryInfo
 	entriesLog                             entriesLog
+	config                                 TestConfig
 }
 
 type EntryInfo struct {
 	SingleSize metrics.Byte
 	Id         string
 	Copies     int
+	// Source is the name of the file the entry was copied from, empty for generated entries
+	Source string `json:",omitempty"`
 }
 
 type entriesLog struct {
 	entries []EntryInfo
 }
 
-func (e *entriesLog) Add(entryId string, size metrics.Byte) {
-	e.entries = append(e.entries, EntryInfo{Id: entryId, SingleSize: size, Copies: 1})
+func (e *entriesLog) Add(entryId, source string, size metrics.Byte) {
+	e.entries = append(e.entries, EntryInfo{Id: entryId, SingleSize: size, Copies: 1, Source: source})
 }
 
 type TestInfo struct {
 	Size            metrics.Byte `json:"size"`
 	DuplicationRate float64      `json:"duplicationRate"`
+	Config          TestConfig   `json:"config"`
+}
+
+// InverseSizeWeights picks source files with a probability inversely proportional to their size
+const InverseSizeWeights = "inverse-size"
+
+// TestConfig is everything needed to generate the same test data again
+type TestConfig struct {
+	Seed uint64 `json:"seed"`
+	// SourceDirHash identifies the contents of SourceDir, so that a replay can tell it changed
+	SourceDir             string           `json:"sourceDir,omitempty"`
+	SourceDirHash         string           `json:"sourceDirHash,omitempty"`
+	MaxSize               metrics.Byte     `json:"maxSize"`
+	TargetDuplicationRate float64          `json:"targetDuplicationRate"`
+	WeightsStrategy       string           `json:"weightsStrategy,omitempty"`
+	Generator             *GeneratorConfig `json:"generator,omitempty"`
 }
 
 func NewTestData(root pk.PK, maxSize metrics.Byte, duplicationRateTarget float64) (*TestData, error) {
-	crud, err := crudos.New(root.Path())
+	rootDir := filepath.FromSlash(root.Path())
+	crud, err := crudos.New(rootDir)
 	if err != nil {
 		return nil, fmt.Errorf("error initializing crudos instance: %w", err)
 	}
 	return &TestData{
-		rootDir:               root.Path(),
+		rootDir:               rootDir,
 		size:                  0,
 		maxSize:               maxSize,
 		crud:                  crud,
@@ -74,9 +96,14 @@ func (t *TestData) Info() TestInfo {
 	return TestInfo{
 		Size:            t.size,
 		DuplicationRate: t.duplicationRate,
+		Config:          t.config,
 	}
 }
 
+func (t *TestData) SetConfig(config TestConfig) {
+	t.config = config
+}
+
 func (t *TestData) Log() []EntryInfo {
 	return t.entriesLog.entries
 }
@@ -104,7 +131,7 @@ func (t *TestData) GetDataEntryPaths() ([]string, error) {
 	return paths, nil
 }
 
-func (t *TestData) SaveTestInfo() error {
+func (t *TestData) SaveTestInfo(ctx context.Context) error {
 	infoPk := pk.New(t.infoDir())
 
 	infoBytes, err := json.Marshal(t.Info())
@@ -114,19 +141,19 @@ func (t *TestData) SaveTestInfo() error {
 
 	infoFilePk := infoPk.Suffix(pk.New("info.json"))
 
-	exists, err := t.crud.Exists(infoFilePk)
+	exists, err := t.crud.Exists(ctx, infoFilePk)
 	if err != nil {
 		return fmt.Errorf("error checking info file existence: %w", err)
 	}
 
 	if exists {
-		err := t.crud.Delete(infoFilePk)
+		err := t.crud.Delete(ctx, infoFilePk)
 		if err != nil {
 			return fmt.Errorf("error deleting old info file: %w", err)
 		}
 	}
 
-	err = t.crud.Create(infoFilePk, infoBytes)
+	err = t.crud.Create(ctx, infoFilePk, infoBytes)
 	if err != nil {
 		return fmt.Errorf("error creating info file: %w", err)
 	}
@@ -135,7 +162,7 @@ func (t *TestData) SaveTestInfo() error {
 
 }
 
-func (t *TestData) SaveLog() error {
+func (t *TestData) SaveLog(ctx context.Context) error {
 	infoPk := pk.New(t.infoDir())
 
 	logBytes, err := json.Marshal(t.Log())
@@ -145,19 +172,19 @@ func (t *TestData) SaveLog() error {
 
 	logPk := infoPk.Suffix(pk.New("log.json"))
 
-	exists, err := t.crud.Exists(logPk)
+	exists, err := t.crud.Exists(ctx, logPk)
 	if err != nil {
 		return fmt.Errorf("error checking log existence: %w", err)
 	}
 
 	if exists {
-		err := t.crud.Delete(logPk)
+		err := t.crud.Delete(ctx, logPk)
 		if err != nil {
 			return fmt.Errorf("error deleting old log: %w", err)
 		}
 	}
 
-	err = t.crud.Create(logPk, logBytes)
+	err = t.crud.Create(ctx, logPk, logBytes)
 	if err != nil {
 		return fmt.Errorf("error creating log file: %w", err)
 	}
@@ -165,7 +192,7 @@ func (t *TestData) SaveLog() error {
 	return nil
 }
 
-func (t *TestData) AddFile(path string) error {
+func (t *TestData) AddFile(ctx context.Context, path string) error {
 	f, err := os.Open(path)
 	if err != nil {
 		return fmt.Errorf("error opening file: %w", err)
@@ -177,12 +204,40 @@ func (t *TestData) AddFile(path string) error {
 		return fmt.Errorf("error retrieving file info: %w", err)
 	}
 
-	singleSize := metrics.Byte(fileInfo.Size())
+	fileData, err := io.ReadAll(f)
+	if err != nil {
+		return fmt.Errorf("error reading file data: %w", err)
+	}
+
 	sanitizedName := strings.ReplaceAll(fileInfo.Name(), ".", "-")
 
+	return t.addBlob(ctx, fileInfo.Name(), sanitizedName, fileData)
+}
+
+// AddBlob adds an entry with the given contents. Entries sharing an id are counted as duplicates
+func (t *TestData) AddBlob(ctx context.Context, id string, blob []byte) error {
+	return t.addBlob(ctx, "", id, blob)
+}
+
+// AddCopy adds exactly one copy of an entry, bypassing the duplication target and the maximum size.
+// It is used to replay a log
+func (t *TestData) AddCopy(ctx context.Context, source, id string, blob []byte) error {
+	return t.addEntry(ctx, blob, EntryInfo{
+		Id:         id,
+		SingleSize: metrics.Byte(len(blob)),
+		Copies:     1,
+		Source:     source,
+	})
+}
+
+func (t *TestData) addBlob(ctx context.Context, source, id string, blob []byte) error {
+
+	singleSize := metrics.Byte(len(blob))
+
 	entryInfo := EntryInfo{
-		Id:         sanitizedName,
+		Id:         id,
 		SingleSize: singleSize,
+		Source:     source,
 	}
 
 	shouldDupe := t.shouldDupe()
@@ -201,7 +256,7 @@ func (t *TestData) AddFile(path string) error {
 		entryInfo.Copies = 1
 	}
 
-	err = t.addEntry(f, entryInfo)
+	err := t.addEntry(ctx, blob, entryInfo)
 	if err != nil {
 		return fmt.Errorf("error adding control entry: %w", err)
 	}
@@ -215,16 +270,25 @@ func (t *TestData) AddFile(path string) error {
 	return nil
 }
 
+// Fill adds generated files until the test data reaches its maximum size
+func (t *TestData) Fill(ctx context.Context, generator *Generator) error {
+	for {
+		file := generator.Next()
+		err := t.AddBlob(ctx, file.Id, file.Blob)
+		if err == ErrSpaceFull {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error adding generated file '%s': %w", file.Id, err)
+		}
+	}
+}
+
 func (t *TestData) shouldDupe() bool {
 	return t.duplicationRate < t.duplicationRateTarget
 }
 
-func (t *TestData) addEntry(file *os.File, info EntryInfo) error {
-	fileData, err := io.ReadAll(file)
-	if err != nil {
-		return fmt.Errorf("error reading file data: %w", err)
-	}
-
+func (t *TestData) addEntry(ctx context.Context, fileData []byte, info EntryInfo) error {
 	dataDirPk := pk.New(t.dataDir())
 
 	existingCopies := t.entriesMap[info.Id].Copies
@@ -232,17 +296,17 @@ func (t *TestData) addEntry(file *os.File, info EntryInfo) error {
 		copyIndex := i + existingCopies + 1
 		entryName := fmt.Sprintf("%s-%d", info.Id, copyIndex)
 		entryPk := dataDirPk.Suffix(pk.New(entryName))
-		err := t.crud.Create(entryPk, fileData)
+		err := t.crud.Create(ctx, entryPk, fileData)
 		if err != nil {
 			return fmt.Errorf("error creating '%s' pk on control: %w", entryPk, err)
 		}
-		t.addNewEntryInfo(info.Id, info.SingleSize)
+		t.addNewEntryInfo(info.Id, info.Source, info.SingleSize)
 	}
 
 	return nil
 }
 
-func (t *TestData) addNewEntryInfo(entryId string, entrySize metrics.Byte) {
+func (t *TestData) addNewEntryInfo(entryId, source string, entrySize metrics.Byte) {
 	_, isDuped := t.entriesMap[entryId]
 	dupedTotalBytes := t.duplicationRate * float64(t.size)
 	if isDuped {
@@ -259,5 +323,74 @@ func (t *TestData) addNewEntryInfo(entryId string, entrySize metrics.Byte) {
 	// calculate new duplication rate
 	t.duplicationRate = dupedTotalBytes / float64(t.size)
 	// add non-copied entry to log
-	t.entriesLog.Add(entryId, entrySize)
+	t.entriesLog.Add(entryId, source, entrySize)
+}
+
+// LoadTestInfo reads the info.json saved by SaveTestInfo in infoDir
+func LoadTestInfo(infoDir string) (TestInfo, error) {
+	infoBytes, err := os.ReadFile(filepath.Join(infoDir, "info.json"))
+	if err != nil {
+		return TestInfo{}, fmt.Errorf("error reading info file: %w", err)
+	}
+
+	var info TestInfo
+	err = json.Unmarshal(infoBytes, &info)
+	if err != nil {
+		return TestInfo{}, fmt.Errorf("error unmarshalling info: %w", err)
+	}
+
+	return info, nil
+}
+
+// LoadLog reads the log.json saved by SaveLog in infoDir
+func LoadLog(infoDir string) ([]EntryInfo, error) {
+	logBytes, err := os.ReadFile(filepath.Join(infoDir, "log.json"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading log file: %w", err)
+	}
+
+	var entries []EntryInfo
+	err = json.Unmarshal(logBytes, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling log: %w", err)
+	}
+
+	return entries, nil
+}
+
+// InfoDir is where SaveTestInfo and SaveLog write
+func (t *TestData) InfoDir() string {
+	return filepath.Join(t.rootDir, t.infoDir())
+}
+
+// HashDir hashes the names and contents of the files directly under dirPath, in name order
+func HashDir(dirPath string) (string, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return "", fmt.Errorf("error reading dir: %w", err)
+	}
+
+	h := sha256.New()
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dirPath, entry.Name()))
+		if err != nil {
+			return "", fmt.Errorf("error opening '%s': %w", entry.Name(), err)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return "", fmt.Errorf("error retrieving '%s' info: %w", entry.Name(), err)
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", entry.Name(), info.Size())
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", fmt.Errorf("error reading '%s': %w", entry.Name(), err)
+		}
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
 }
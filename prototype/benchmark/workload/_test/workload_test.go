@@ -0,0 +1,58 @@
+package workload_test
+
+import (
+	"crypto/sha256"
+	"sis"
+	"sis/benchmark/workload"
+	"sis/internal/crud/crudos"
+	"sis/internal/metrics"
+	"testing"
+)
+
+func TestMixedWorkload(t *testing.T) {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+
+	spec := workload.Spec{
+		Seed:            1,
+		Mix:             workload.Mix{Create: 30, Read: 40, Update: 15, Delete: 15},
+		Keys:            50,
+		KeyDistribution: workload.ZipfKeys,
+		ZipfS:           1.2,
+		Values:          5,
+		ValueSize:       metrics.KB(4),
+		Concurrency:     4,
+		Operations:      2000,
+		Prefix:          "workload",
+	}
+
+	result, err := workload.Run(ctx, &sisInstance, spec)
+	if err != nil {
+		t.Fatalf("error running workload: %s", err.Error())
+	}
+
+	if result.Operations != spec.Operations {
+		t.Fatalf("expected %d operations, got %d", spec.Operations, result.Operations)
+	}
+	if result.ViolationCount != 0 {
+		t.Fatalf("found %d violations: %v", result.ViolationCount, result.Violations)
+	}
+	if result.Ops["read"].Count == 0 || result.Ops["delete"].Count == 0 {
+		t.Fatalf("expected reads and deletes, got %+v", result.Ops)
+	}
+
+	problems, err := sisInstance.Check(ctx)
+	if err != nil {
+		t.Fatalf("error checking store: %s", err.Error())
+	}
+	if len(problems) != 0 {
+		t.Fatalf("store is inconsistent after workload: %v", problems)
+	}
+}
@@ -0,0 +1,97 @@
+package workload
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sis/internal/metrics"
+	"time"
+)
+
+type KeyDistribution string
+
+const (
+	UniformKeys KeyDistribution = "uniform"
+	// ZipfKeys makes a few keys hot and most of them cold
+	ZipfKeys KeyDistribution = "zipf"
+)
+
+// Mix is the percentage of each operation in a workload. It must add up to 100
+type Mix struct {
+	Create float64 `json:"create"`
+	Read   float64 `json:"read"`
+	Update float64 `json:"update"`
+	Delete float64 `json:"delete"`
+}
+
+// a Spec declares a workload. Two runs of the same spec on a single worker issue the same operations
+type Spec struct {
+	Seed uint64 `json:"seed"`
+	Mix  Mix    `json:"mix"`
+	// Keys is the size of the key space
+	Keys            int             `json:"keys"`
+	KeyDistribution KeyDistribution `json:"keyDistribution"`
+	// ZipfS is the exponent for ZipfKeys, which must be larger than 1
+	ZipfS float64 `json:"zipfS,omitempty"`
+	// Values is how many distinct contents are written. Fewer values than keys means shared blobs
+	Values    int          `json:"values"`
+	ValueSize metrics.Byte `json:"valueSize"`
+	// Concurrency is the number of workers issuing operations
+	Concurrency int `json:"concurrency"`
+	// the workload stops after Duration or Operations, whichever comes first. Zero disables a limit
+	Duration   time.Duration `json:"duration,omitempty"`
+	Operations int           `json:"operations,omitempty"`
+	// Prefix is prepended to every key, so that workloads can share a store
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// LoadSpec reads a JSON spec
+func LoadSpec(path string) (Spec, error) {
+	specBytes, err := os.ReadFile(path)
+	if err != nil {
+		return Spec{}, fmt.Errorf("error reading spec: %w", err)
+	}
+
+	var spec Spec
+	err = json.Unmarshal(specBytes, &spec)
+	if err != nil {
+		return Spec{}, fmt.Errorf("error unmarshalling spec: %w", err)
+	}
+
+	return spec, spec.Validate()
+}
+
+func (s Spec) Validate() error {
+	total := s.Mix.Create + s.Mix.Read + s.Mix.Update + s.Mix.Delete
+	if total < 99.999 || total > 100.001 {
+		return fmt.Errorf("operation mix adds up to %.2f%%, expected 100%%", total)
+	}
+	if s.Mix.Create < 0 || s.Mix.Read < 0 || s.Mix.Update < 0 || s.Mix.Delete < 0 {
+		return fmt.Errorf("operation mix cannot have negative percentages")
+	}
+	if s.Keys <= 0 {
+		return fmt.Errorf("key space must have at least one key")
+	}
+	if s.Values <= 0 {
+		return fmt.Errorf("workload must write at least one value")
+	}
+	if s.ValueSize < 0 {
+		return fmt.Errorf("value size cannot be negative")
+	}
+	if s.Concurrency <= 0 {
+		return fmt.Errorf("workload needs at least one worker")
+	}
+	if s.Duration <= 0 && s.Operations <= 0 {
+		return fmt.Errorf("workload needs a duration or an operation count")
+	}
+	switch s.KeyDistribution {
+	case UniformKeys:
+	case ZipfKeys:
+		if s.ZipfS <= 1 {
+			return fmt.Errorf("zipf exponent %.2f must be larger than 1", s.ZipfS)
+		}
+	default:
+		return fmt.Errorf("unknown key distribution '%s'", s.KeyDistribution)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+// Package workload drives a sis.Store with a mix of operations while checking every result
+// against a shadow model of what the store should hold
+package workload
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sis"
+	"sis/benchmark"
+	"sis/internal/pk"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	opCreate = "create"
+	opRead   = "read"
+	opUpdate = "update"
+	opDelete = "delete"
+)
+
+// maxViolations bounds how many violations are described in a Result. All of them are counted
+const maxViolations = 100
+
+// a Result reports the outcome of a workload run
+type Result struct {
+	Operations int                           `json:"operations"`
+	Elapsed    time.Duration                 `json:"elapsed"`
+	Ops        map[string]benchmark.OpReport `json:"ops"`
+	// Violations are results that disagree with the shadow model
+	ViolationCount int      `json:"violationCount"`
+	Violations     []string `json:"violations,omitempty"`
+}
+
+type engine struct {
+	spec     Spec
+	store    sis.Store
+	values   [][]byte
+	recorder *benchmark.LatencyRecorder
+	// shadow[k] is the value index key k should hold, or -1 if it should not exist.
+	// keyLocks[k] serializes operations on key k, so that the shadow always matches the store
+	shadow   []int
+	keyLocks []sync.Mutex
+	issued   atomic.Int64
+
+	violationsMu   sync.Mutex
+	violationCount int
+	violations     []string
+}
+
+// Run executes spec against store. Keys under spec.Prefix must not exist when the run starts.
+// Operations that contradict the shadow model are reported as violations, not as errors
+func Run(ctx context.Context, store sis.Store, spec Spec) (Result, error) {
+	err := spec.Validate()
+	if err != nil {
+		return Result{}, fmt.Errorf("invalid spec: %w", err)
+	}
+
+	e := &engine{
+		spec:     spec,
+		store:    store,
+		values:   makeValues(spec),
+		recorder: benchmark.NewLatencyRecorder(),
+		shadow:   make([]int, spec.Keys),
+		keyLocks: make([]sync.Mutex, spec.Keys),
+	}
+	for i := range e.shadow {
+		e.shadow[i] = -1
+	}
+
+	workCtx := ctx
+	if spec.Duration > 0 {
+		var cancel context.CancelFunc
+		workCtx, cancel = context.WithTimeout(ctx, spec.Duration)
+		defer cancel()
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for worker := range spec.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.work(workCtx, uint64(worker))
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	err = e.verifyAll(ctx)
+	if err != nil {
+		return Result{}, fmt.Errorf("error verifying final state: %w", err)
+	}
+
+	return Result{
+		Operations:     int(e.issued.Load()),
+		Elapsed:        elapsed,
+		Ops:            e.recorder.Summary(),
+		ViolationCount: e.violationCount,
+		Violations:     e.violations,
+	}, nil
+}
+
+func makeValues(spec Spec) [][]byte {
+	rng := rand.New(rand.NewPCG(spec.Seed, 0xda7a))
+	values := make([][]byte, spec.Values)
+	for i := range values {
+		values[i] = make([]byte, spec.ValueSize)
+		for j := range values[i] {
+			values[i][j] = byte(rng.Uint32())
+		}
+	}
+	return values
+}
+
+func (e *engine) work(ctx context.Context, worker uint64) {
+	rng := rand.New(rand.NewPCG(e.spec.Seed, worker))
+	var zipf *rand.Zipf
+	if e.spec.KeyDistribution == ZipfKeys {
+		zipf = rand.NewZipf(rng, e.spec.ZipfS, 1, uint64(e.spec.Keys-1))
+	}
+
+	for ctx.Err() == nil {
+		issued := e.issued.Add(1)
+		if e.spec.Operations > 0 && issued > int64(e.spec.Operations) {
+			e.issued.Add(-1)
+			return
+		}
+
+		var keyIndex int
+		if zipf != nil {
+			keyIndex = int(zipf.Uint64())
+		} else {
+			keyIndex = rng.IntN(e.spec.Keys)
+		}
+
+		// an operation in flight finishes even if ctx is done, so the shadow model stays in step
+		e.apply(context.WithoutCancel(ctx), e.pickOp(rng), keyIndex, rng.IntN(len(e.values)))
+	}
+}
+
+func (e *engine) pickOp(rng *rand.Rand) string {
+	target := rng.Float64() * 100
+	mix := e.spec.Mix
+	switch {
+	case target < mix.Create:
+		return opCreate
+	case target < mix.Create+mix.Read:
+		return opRead
+	case target < mix.Create+mix.Read+mix.Update:
+		return opUpdate
+	default:
+		return opDelete
+	}
+}
+
+// apply runs op on a key and compares the outcome with the shadow model
+func (e *engine) apply(ctx context.Context, op string, keyIndex, valueIndex int) {
+	e.keyLocks[keyIndex].Lock()
+	defer e.keyLocks[keyIndex].Unlock()
+
+	key := e.key(keyIndex)
+	current := e.shadow[keyIndex]
+	exists := current >= 0
+
+	start := time.Now()
+	var err error
+	var blob []byte
+	switch op {
+	case opCreate:
+		err = e.store.Create(ctx, key, e.values[valueIndex])
+	case opRead:
+		blob, err = e.store.Read(ctx, key)
+	case opUpdate:
+		err = e.store.Update(ctx, key, e.values[valueIndex])
+	case opDelete:
+		err = e.store.Delete(ctx, key)
+	}
+	e.recorder.Record(op, time.Since(start), e.spec.ValueSize, err)
+
+	succeeded := err == nil
+	// creating an existing key is the only operation expected to fail on existing keys
+	shouldSucceed := exists != (op == opCreate)
+	if succeeded != shouldSucceed {
+		e.violate("%s '%s': expected success %t, got error %v", op, key.Path(), shouldSucceed, err)
+		return
+	}
+	if !succeeded {
+		return
+	}
+
+	switch op {
+	case opCreate, opUpdate:
+		e.shadow[keyIndex] = valueIndex
+	case opDelete:
+		e.shadow[keyIndex] = -1
+	case opRead:
+		if !bytes.Equal(blob, e.values[current]) {
+			e.violate("read '%s': contents differ from value %d", key.Path(), current)
+		}
+	}
+}
+
+// verifyAll compares every key of the key space with the shadow model once the workers are done
+func (e *engine) verifyAll(ctx context.Context) error {
+	for keyIndex, valueIndex := range e.shadow {
+		key := e.key(keyIndex)
+		exists, err := e.store.Exists(ctx, key)
+		if err != nil {
+			return fmt.Errorf("error checking '%s' existence: %w", key.Path(), err)
+		}
+		if exists != (valueIndex >= 0) {
+			e.violate("final state of '%s': expected existence %t", key.Path(), valueIndex >= 0)
+			continue
+		}
+		if !exists {
+			continue
+		}
+		blob, err := e.store.Read(ctx, key)
+		if err != nil {
+			return fmt.Errorf("error reading '%s': %w", key.Path(), err)
+		}
+		if !bytes.Equal(blob, e.values[valueIndex]) {
+			e.violate("final state of '%s': contents differ from value %d", key.Path(), valueIndex)
+		}
+	}
+	return nil
+}
+
+func (e *engine) key(keyIndex int) pk.PK {
+	name := fmt.Sprintf("key-%06d", keyIndex)
+	if e.spec.Prefix == "" {
+		return pk.PK{name}
+	}
+	return append(pk.PK(strings.Split(e.spec.Prefix, "/")), name)
+}
+
+func (e *engine) violate(format string, args ...any) {
+	e.violationsMu.Lock()
+	defer e.violationsMu.Unlock()
+
+	e.violationCount++
+	if len(e.violations) < maxViolations {
+		e.violations = append(e.violations, fmt.Sprintf(format, args...))
+	}
+}
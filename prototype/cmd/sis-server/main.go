@@ -0,0 +1,49 @@
+// sis-server serves a SIS store over HTTP
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"sis"
+	"sis/internal/crud/crudos"
+	sishash "sis/internal/hash"
+	"sis/server"
+	"strconv"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	root := flag.String("root", "./root", "path to the store root directory")
+	hashName := flag.String("hash", "sha256", "hash algorithm")
+	perm := flag.String("perm", "0777", "permissions for directories and files created in the store, in octal")
+	flag.Parse()
+
+	permissions, err := strconv.ParseUint(*perm, 8, 32)
+	if err != nil {
+		log.Fatalf("invalid permissions '%s': %s", *perm, err.Error())
+	}
+
+	h, err := sishash.New(*hashName)
+	if err != nil {
+		log.Fatalf("error creating hash: %s", err.Error())
+	}
+
+	crudOs, err := crudos.New(*root, os.FileMode(permissions))
+	if err != nil {
+		log.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+
+	sisInstance, err := sis.New(context.Background(), h, crudOs)
+	if err != nil {
+		log.Fatalf("error creating sis instance: %s", err.Error())
+	}
+
+	log.Printf("serving '%s' on %s", *root, *addr)
+	err = http.ListenAndServe(*addr, server.New(&sisInstance))
+	if err != nil {
+		log.Fatalf("error serving: %s", err.Error())
+	}
+}
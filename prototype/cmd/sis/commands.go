@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sis"
+	"sis/benchmark"
+	"sis/internal/metrics"
+	"sis/internal/pk"
+	"strings"
+)
+
+func runPut(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	blob, err := readInput(args[1])
+	if err != nil {
+		return fmt.Errorf("error reading input: %w", err)
+	}
+
+	key, err := parseKey(args[0])
+	if err != nil {
+		return err
+	}
+	err = c.sisInstance.Create(ctx, key, blob)
+	if err != nil {
+		return fmt.Errorf("error creating '%s': %w", key.Format(), err)
+	}
+
+	return c.printInfo(ctx, key)
+}
+
+func runGet(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return errUsage
+	}
+
+	key, err := parseKey(args[0])
+	if err != nil {
+		return err
+	}
+	blob, err := c.sisInstance.Read(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error reading '%s': %w", key.Format(), err)
+	}
+
+	if len(args) == 1 || args[1] == "-" {
+		_, err = os.Stdout.Write(blob)
+		return err
+	}
+	return os.WriteFile(args[1], blob, 0666)
+}
+
+func runRm(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	key, err := parseKey(args[0])
+	if err != nil {
+		return err
+	}
+	err = c.sisInstance.Delete(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error deleting '%s': %w", key.Format(), err)
+	}
+
+	return nil
+}
+
+func runLs(ctx context.Context, c *cli, args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+
+	prefix := pk.PK{}
+	if len(args) == 1 {
+		var err error
+		prefix, err = parsePrefix(args[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	keys, err := c.sisInstance.List(ctx, prefix)
+	if err != nil {
+		return fmt.Errorf("error listing keys: %w", err)
+	}
+
+	if c.json {
+		paths := make([]string, len(keys))
+		for i, key := range keys {
+			paths[i] = key.Format()
+		}
+		return printJSON(paths)
+	}
+
+	for _, key := range keys {
+		fmt.Println(key.Format())
+	}
+	return nil
+}
+
+func runStat(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	key, err := parseKey(args[0])
+	if err != nil {
+		return err
+	}
+	return c.printInfo(ctx, key)
+}
+
+// runFind prints the keys matching a pattern, like 'images/**/thumb-*.jpg', with their size and digest
+func runFind(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	infos, err := c.sisInstance.Find(ctx, strings.Trim(args[0], "/"))
+	if err != nil {
+		return fmt.Errorf("error finding '%s': %w", args[0], err)
+	}
+
+	if c.json {
+		return printJSON(infos)
+	}
+
+	for _, info := range infos {
+		fmt.Printf("%s\t%s\t%s\n", info.PK.Format(), info.Size, info.Digest)
+	}
+	return nil
+}
+
+func runDu(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	usage, err := c.sisInstance.Usage(ctx)
+	if err != nil {
+		return fmt.Errorf("error measuring store: %w", err)
+	}
+
+	if c.json {
+		return printJSON(struct {
+			sis.Usage
+			PhysicalBytes metrics.Byte `json:"physicalBytes"`
+			DedupRatio    float64      `json:"dedupRatio"`
+		}{usage, usage.PhysicalBytes(), usage.DedupRatio()})
+	}
+
+	fmt.Printf("keys:     %d\n", usage.Keys)
+	fmt.Printf("blobs:    %d\n", usage.Blobs)
+	fmt.Printf("logical:  %s\n", usage.LogicalBytes)
+	fmt.Printf("physical: %s (blobs %s, metadata %s, headers %s)\n", usage.PhysicalBytes(), usage.BlobBytes, usage.MetadataBytes, usage.HeaderBytes)
+	fmt.Printf("dedup:    %.2fx\n", usage.DedupRatio())
+	return nil
+}
+
+func runFsck(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	problems, err := c.sisInstance.Check(ctx)
+	if err != nil {
+		return fmt.Errorf("error checking store: %w", err)
+	}
+
+	if c.json {
+		err = printJSON(problems)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errProblems
+	}
+	return nil
+}
+
+func runGc(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	result, err := c.sisInstance.GC(ctx)
+	if err != nil {
+		return fmt.Errorf("error collecting garbage: %w", err)
+	}
+
+	if c.json {
+		return printJSON(result)
+	}
+
+	fmt.Printf("restored %d refs, removed %d refs and %d blobs, freed %s\n", result.RestoredRefs, result.RemovedRefs, result.RemovedBlobs, result.FreedBytes)
+	return nil
+}
+
+// runImport imports a directory, or a tar, tar.gz or zip archive when given a file
+func runImport(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return errUsage
+	}
+
+	srcDir := args[0]
+	prefix := pk.PK{}
+	if len(args) == 2 {
+		var err error
+		prefix, err = parsePrefix(args[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	srcInfo, err := os.Stat(srcDir)
+	if err != nil {
+		return fmt.Errorf("error reading '%s': %w", srcDir, err)
+	}
+	if !srcInfo.IsDir() {
+		return importArchive(ctx, c, srcDir, prefix)
+	}
+
+	var files int
+	var size metrics.Byte
+	err = filepath.WalkDir(srcDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return fmt.Errorf("error computing relative path of '%s': %w", path, err)
+		}
+		blob, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading '%s': %w", path, err)
+		}
+
+		relKey, err := pk.FromPath(relPath)
+		if err != nil {
+			return fmt.Errorf("error mapping '%s' to a key: %w", path, err)
+		}
+		key := prefix.Suffix(relKey)
+		err = c.sisInstance.Create(ctx, key, blob)
+		if err != nil {
+			return fmt.Errorf("error creating '%s': %w", key.Format(), err)
+		}
+
+		files++
+		size += metrics.Byte(len(blob))
+		if !c.json {
+			fmt.Println(key.Format())
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error importing '%s': %w", srcDir, err)
+	}
+
+	if c.json {
+		return printJSON(struct {
+			Files int          `json:"files"`
+			Bytes metrics.Byte `json:"bytes"`
+		}{files, size})
+	}
+
+	fmt.Printf("imported %d files, %s\n", files, size)
+	return nil
+}
+
+func importArchive(ctx context.Context, c *cli, archivePath string, prefix pk.PK) error {
+	report, err := benchmark.ImportArchive(ctx, c.sisInstance, prefix, archivePath)
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return printJSON(struct {
+			benchmark.ArchiveReport
+			SavedBytes metrics.Byte `json:"savedBytes"`
+		}{report, report.SavedBytes()})
+	}
+
+	fmt.Printf("imported %d files, %s (stored %s, saved %s)\n", report.Files, report.Bytes, report.StoredBytes, report.SavedBytes())
+	return nil
+}
+
+// runExport writes the keys under prefix to a directory, or to a tar archive when the target ends
+// in .tar, .tar.gz or .tgz, or is '-' for stdout. Recorded modes and modification times are restored
+func runExport(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	prefix, err := parsePrefix(args[0])
+	if err != nil {
+		return err
+	}
+	target := args[1]
+	options := benchmark.ExportOptions{
+		RestoreMetadata: true,
+		Gzip:            strings.HasSuffix(target, ".tar.gz") || strings.HasSuffix(target, ".tgz"),
+	}
+
+	var res benchmark.ExportResult
+	switch {
+	case target == "-":
+		res, err = benchmark.ExportTar(ctx, c.sisInstance, prefix, os.Stdout, options)
+	case options.Gzip || strings.HasSuffix(target, ".tar"):
+		res, err = exportArchive(ctx, c, prefix, target, options)
+	default:
+		res, err = benchmark.ExportDir(ctx, c.sisInstance, prefix, target, options)
+	}
+	if err != nil {
+		return fmt.Errorf("error exporting '%s': %w", prefix.Format(), err)
+	}
+
+	if c.json {
+		return printJSON(struct {
+			Files int          `json:"files"`
+			Bytes metrics.Byte `json:"bytes"`
+		}{res.Files, res.Bytes})
+	}
+
+	if target != "-" {
+		fmt.Printf("exported %d files, %s\n", res.Files, res.Bytes)
+	}
+	return nil
+}
+
+func exportArchive(ctx context.Context, c *cli, prefix pk.PK, path string, options benchmark.ExportOptions) (benchmark.ExportResult, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return benchmark.ExportResult{}, fmt.Errorf("error creating archive: %w", err)
+	}
+	res, err := benchmark.ExportTar(ctx, c.sisInstance, prefix, file, options)
+	if err != nil {
+		file.Close()
+		return res, err
+	}
+	return res, file.Close()
+}
+
+func (c *cli) printInfo(ctx context.Context, key pk.PK) error {
+	info, err := c.sisInstance.Stat(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error reading info of '%s': %w", key.Format(), err)
+	}
+
+	if c.json {
+		return printJSON(info)
+	}
+
+	fmt.Printf("key:    %s\n", info.PK.Format())
+	fmt.Printf("digest: %s\n", info.Digest)
+	fmt.Printf("size:   %s\n", info.Size)
+	for name, value := range info.Metadata {
+		fmt.Printf("%s: %v\n", name, value)
+	}
+	return nil
+}
+
+// parseKey turns a '/' separated key into a pk, ignoring leading and trailing separators
+func parseKey(key string) (pk.PK, error) {
+	return pk.Parse(strings.Trim(key, "/"))
+}
+
+// parsePrefix is parseKey for prefixes, which may be empty to mean every key
+func parsePrefix(prefix string) (pk.PK, error) {
+	if strings.Trim(prefix, "/") == "" {
+		return pk.PK{}, nil
+	}
+	return parseKey(prefix)
+}
+
+// readInput reads a whole file, or stdin when path is '-'
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,143 @@
+// sis is a command-line tool for day-to-day operations on a SIS store
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sis"
+	"sis/internal/crud/crudos"
+	sishash "sis/internal/hash"
+	"strconv"
+	"strings"
+)
+
+// exit codes
+const (
+	exitOk       = 0
+	exitFailure  = 1
+	exitUsage    = 2
+	exitProblems = 3
+)
+
+// errUsage is returned by commands called with the wrong arguments
+var errUsage = errors.New("usage")
+
+// errProblems is returned by fsck when the store is inconsistent
+var errProblems = errors.New("store has problems")
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"put":    {"put <key> <file|->", runPut},
+	"get":    {"get <key> [file|-]", runGet},
+	"rm":     {"rm <key>", runRm},
+	"ls":     {"ls [prefix]", runLs},
+	"stat":   {"stat <key>", runStat},
+	"find":   {"find <pattern>", runFind},
+	"du":     {"du", runDu},
+	"fsck":   {"fsck", runFsck},
+	"gc":     {"gc", runGc},
+	"import": {"import <dir|archive> [prefix]", runImport},
+	"export": {"export <prefix> <dir|archive.tar|archive.tar.gz|->", runExport},
+}
+
+var commandOrder = []string{"put", "get", "rm", "ls", "stat", "find", "du", "fsck", "gc", "import", "export"}
+
+// cli holds the global flags and the opened store
+type cli struct {
+	sisInstance *sis.SIS
+	json        bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	flags := flag.NewFlagSet("sis", flag.ContinueOnError)
+	root := flags.String("root", "./root", "path to the store root directory")
+	hashName := flags.String("hash", "sha256", fmt.Sprintf("hash algorithm, one of %s", strings.Join(sishash.Names(), ", ")))
+	perm := flags.String("perm", "0777", "permissions for directories and files created in the store, in octal")
+	jsonOutput := flags.Bool("json", false, "print results as JSON")
+	flags.Usage = func() { printUsage(flags) }
+
+	err := flags.Parse(args)
+	if err != nil {
+		return exitUsage
+	}
+
+	if flags.NArg() == 0 {
+		printUsage(flags)
+		return exitUsage
+	}
+
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command '%s'\n", flags.Arg(0))
+		printUsage(flags)
+		return exitUsage
+	}
+
+	permissions, err := strconv.ParseUint(*perm, 8, 32)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid permissions '%s': %s\n", *perm, err)
+		return exitUsage
+	}
+
+	h, err := sishash.New(*hashName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	// an interrupt cancels the running command, which leaves the store consistent
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	crudOs, err := crudos.New(*root, os.FileMode(permissions))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating crudos instance: %s\n", err)
+		return exitFailure
+	}
+
+	sisInstance, err := sis.New(ctx, h, crudOs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	c := &cli{
+		sisInstance: &sisInstance,
+		json:        *jsonOutput,
+	}
+
+	err = cmd.run(ctx, c, flags.Args()[1:])
+	switch {
+	case err == nil:
+		return exitOk
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(os.Stderr, "usage: sis [flags] %s\n", cmd.usage)
+		return exitUsage
+	case errors.Is(err, errProblems):
+		return exitProblems
+	default:
+		fmt.Fprintf(os.Stderr, "%s: %s\n", flags.Arg(0), err)
+		return exitFailure
+	}
+}
+
+func printUsage(flags *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "usage: sis [flags] <command> [args]\n\ncommands:\n")
+	for _, name := range commandOrder {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nflags:\n")
+	flags.PrintDefaults()
+}
@@ -1,11 +1,22 @@
 package constants
 
 import (
-	"path"
 	"sis/internal/pk"
 )
 
 // constants
-var UserDataSpace pk.PK = pk.New(path.Join("user", "data"))
-var SystemDataSpace pk.PK = pk.New(path.Join("sys", "data"))
+var UserDataSpace pk.PK = pk.New("user/data")
+var SystemDataSpace pk.PK = pk.New("sys/data")
+var SystemAliasSpace pk.PK = pk.New("sys/alias")
+var SystemMigrationSpace pk.PK = pk.New("sys/migration")
+var SystemManifest pk.PK = pk.New("sys/manifest")
+var SystemStagingSpace pk.PK = pk.New("sys/staging")
+var SystemSnapshotSpace pk.PK = pk.New("sys/snapshots")
+var SystemVersionSpace pk.PK = pk.New("sys/versions")
+var SystemJournalSpace pk.PK = pk.New("sys/journal")
+var SystemChangesSpace pk.PK = pk.New("sys/changes")
+var SystemChangesTruncated pk.PK = pk.New("sys/changes/truncated")
+var SystemChangesPending pk.PK = pk.New("sys/changes/pending")
 var DataHeaderSuffix pk.PK = pk.New("data-header")
+var BlobSuffix pk.PK = pk.New("blob")
+var MetadataSuffix pk.PK = pk.New("metadata")
@@ -1,12 +1,39 @@
 package crud
 
-import "sis/internal/metrics"
+import (
+	"context"
+	"io"
+	"sis/internal/metrics"
+)
 
+// Crud is the storage backend of a SIS instance. Every method fails with the context error once ctx
+// is done, and a cancelled Create or CreateFrom leaves nothing behind
 type Crud interface {
-	Create(pk []string, blob []byte) error
-	Read(pk []string) ([]byte, error)
-	Update(pk []string, blob []byte) error
-	Delete(pk []string) error
-	Exists(pk []string) (bool, error)
-	SizeOf(pk []string) (metrics.Byte, error)
+	Create(ctx context.Context, pk []string, blob []byte) error
+	Read(ctx context.Context, pk []string) ([]byte, error)
+	Update(ctx context.Context, pk []string, blob []byte) error
+	Delete(ctx context.Context, pk []string) error
+	Exists(ctx context.Context, pk []string) (bool, error)
+	SizeOf(ctx context.Context, pk []string) (metrics.Byte, error)
+	// List returns the direct children of pk, sorted by name. An empty pk lists the root,
+	// and a pk that does not exist has no children.
+	List(ctx context.Context, pk []string) ([]Entry, error)
+}
+
+// an Entry is a direct child of a listed pk
+type Entry struct {
+	Name  string
+	IsDir bool
+}
+
+// a Streamer is a Crud that can move blobs without holding them in memory.
+// Backends that are not Streamers are read and written whole
+type Streamer interface {
+	Crud
+	// Open returns a reader over the contents of pk, which the caller must close
+	Open(ctx context.Context, pk []string) (io.ReadSeekCloser, error)
+	// CreateFrom creates pk with everything read from r, returning the number of bytes written
+	CreateFrom(ctx context.Context, pk []string, r io.Reader) (metrics.Byte, error)
+	// Rename moves the contents of from to to, which must not exist yet
+	Rename(ctx context.Context, from, to []string) error
 }
@@ -1,10 +1,13 @@
 package crudos
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sis/internal/crud"
 	"sis/internal/metrics"
 )
 
@@ -33,73 +36,64 @@ func New(rootPath string, permissions ...os.FileMode) (CrudOs, error) {
 	return c, nil
 }
 
-func (c CrudOs) Create(pk []string, blob []byte) error {
+func (c CrudOs) Create(ctx context.Context, pk []string, blob []byte) error {
 
-	if len(pk) == 0 {
-		return fmt.Errorf("pk cannot be empty")
-	}
-
-	pkPath := c.pkToPath(pk)
-	directoriesPath := c.pkToPath(pk[:len(pk)-1])
-	err := os.MkdirAll(directoriesPath, c.perm)
-	if err != nil {
-		return fmt.Errorf("error creating necessary directories: %w", err)
-	}
-
-	f, err := os.Create(pkPath)
-	if err != nil {
-		return fmt.Errorf("error creating specified pk: %w", err)
-	}
-	defer f.Close()
-
-	_, err = f.Write(blob)
-	if err != nil {
-		return fmt.Errorf("error writing data to pk: %w", err)
+	if err := checkPk(pk); err != nil {
+		return err
 	}
 
+	_, err := c.writeFile(ctx, pk, bytes.NewReader(blob))
 	return err
 }
 
-func (c CrudOs) Read(pk []string) ([]byte, error) {
+func (c CrudOs) Read(ctx context.Context, pk []string) ([]byte, error) {
+
+	if err := checkPk(pk); err != nil {
+		return nil, err
+	}
 
-	if len(pk) == 0 {
-		return nil, fmt.Errorf("pk cannot be empty")
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
 	pkPath := c.pkToPath(pk)
 	f, err := os.Open(pkPath)
 	if err != nil {
-		return nil, fmt.Errorf("error opening pk: %w", err)
+		return nil, fmt.Errorf("error opening pk: %w", crud.FromFS(err))
 	}
 	defer f.Close()
 
 	blob, err := io.ReadAll(f)
 	if err != nil {
-		return nil, fmt.Errorf("error reading pk: %w", err)
+		return nil, fmt.Errorf("error reading pk: %w", crud.FromFS(err))
 	}
 
 	return blob, nil
 }
 
-func (c CrudOs) Update(pk []string, blob []byte) error {
+func (c CrudOs) Update(ctx context.Context, pk []string, blob []byte) error {
 
-	if len(pk) == 0 {
-		return fmt.Errorf("pk cannot be empty")
+	if err := checkPk(pk); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
 	pkPath := c.pkToPath(pk)
-	exists, err := c.Exists(pk)
+	exists, err := c.Exists(ctx, pk)
 	if err != nil {
 		return fmt.Errorf("error verifying pk existence: %w", err)
 	}
 
 	if !exists {
-		return fmt.Errorf("cannot update contents of non-existant pk")
+		return fmt.Errorf("cannot update contents of non-existant pk: %w", crud.ErrNotFound)
 	}
 
 	f, err := os.Create(pkPath)
 	if err != nil {
-		return fmt.Errorf("error truncating specified pk: %w", err)
+		return fmt.Errorf("error truncating specified pk: %w", crud.FromFS(err))
 	}
 	defer f.Close()
 
@@ -111,46 +105,42 @@ func (c CrudOs) Update(pk []string, blob []byte) error {
 	return nil
 }
 
-func (c CrudOs) Delete(key []string) error {
+func (c CrudOs) Delete(ctx context.Context, key []string) error {
 
-	if len(key) == 0 {
-		return fmt.Errorf("pk cannot be empty")
+	if err := checkPk(key); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
 	pkPath := c.pkToPath(key)
-	exists, err := c.Exists(key)
+	exists, err := c.Exists(ctx, key)
 	if err != nil {
 		return fmt.Errorf("error verifying pk existence: %w", err)
 	}
 
 	if !exists {
-		return fmt.Errorf("cannot delete non-existant pk")
+		return fmt.Errorf("cannot delete non-existant pk: %w", crud.ErrNotFound)
 	}
 
 	err = os.Remove(pkPath)
 	if err != nil {
-		return fmt.Errorf("error deleting pk: %w", err)
+		return fmt.Errorf("error deleting pk: %w", crud.FromFS(err))
 	}
 
-	dirPath := filepath.Dir(pkPath)
-	isDirEmpty, err := c.isDirEmpty(dirPath)
-	if err != nil {
-		return fmt.Errorf("error checking if parent directory is empty: %w", err)
-	}
-	if isDirEmpty && dirPath != c.root {
-		err := c.Delete(c.absPathToPk(dirPath))
-		if err != nil {
-			return fmt.Errorf("error deleting parent directory: %w", err)
-		}
-	}
-
-	return nil
+	return c.deleteEmptyParents(ctx, pkPath)
 }
 
-func (c CrudOs) Exists(pk []string) (bool, error) {
+func (c CrudOs) Exists(ctx context.Context, pk []string) (bool, error) {
 
-	if len(pk) == 0 {
-		return false, fmt.Errorf("pk cannot be empty")
+	if err := checkPk(pk); err != nil {
+		return false, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return false, err
 	}
 
 	pkPath := c.pkToPath(pk)
@@ -165,15 +155,19 @@ func (c CrudOs) Exists(pk []string) (bool, error) {
 	return true, nil
 }
 
-func (c CrudOs) SizeOf(key []string) (metrics.Byte, error) {
-	if len(key) == 0 {
-		return 0, fmt.Errorf("pk cannot be empty")
+func (c CrudOs) SizeOf(ctx context.Context, key []string) (metrics.Byte, error) {
+	if err := checkPk(key); err != nil {
+		return 0, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
 	}
 
 	pkPath := c.pkToPath(key)
 	fileInfo, err := os.Stat(pkPath)
 	if err != nil {
-		return 0, fmt.Errorf("error retrieving pk info: %w", err)
+		return 0, fmt.Errorf("error retrieving pk info: %w", crud.FromFS(err))
 	}
 
 	if fileInfo.IsDir() {
@@ -186,3 +180,102 @@ func (c CrudOs) SizeOf(key []string) (metrics.Byte, error) {
 
 	return metrics.Byte(fileInfo.Size()), nil
 }
+
+func (c CrudOs) List(ctx context.Context, key []string) ([]crud.Entry, error) {
+
+	if len(key) > 0 {
+		if err := checkPk(key); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	dirPath := c.pkToPath(key)
+	dirEntries, err := os.ReadDir(dirPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading pk entries: %w", crud.FromFS(err))
+	}
+
+	entries := make([]crud.Entry, len(dirEntries))
+	for i, dirEntry := range dirEntries {
+		entries[i] = crud.Entry{
+			Name:  dirEntry.Name(),
+			IsDir: dirEntry.IsDir(),
+		}
+	}
+
+	return entries, nil
+}
+
+func (c CrudOs) Open(ctx context.Context, key []string) (io.ReadSeekCloser, error) {
+
+	if err := checkPk(key); err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(c.pkToPath(key))
+	if err != nil {
+		return nil, fmt.Errorf("error opening pk: %w", crud.FromFS(err))
+	}
+
+	return f, nil
+}
+
+func (c CrudOs) CreateFrom(ctx context.Context, key []string, r io.Reader) (metrics.Byte, error) {
+
+	if err := checkPk(key); err != nil {
+		return 0, err
+	}
+
+	return c.writeFile(ctx, key, r)
+}
+
+func (c CrudOs) Rename(ctx context.Context, from, to []string) error {
+
+	if err := checkPk(from); err != nil {
+		return err
+	}
+	if err := checkPk(to); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	exists, err := c.Exists(ctx, to)
+	if err != nil {
+		return fmt.Errorf("error verifying destination pk existence: %w", err)
+	}
+	if exists {
+		return fmt.Errorf("destination pk: %w", crud.ErrAlreadyExists)
+	}
+
+	err = os.MkdirAll(c.pkToPath(to[:len(to)-1]), c.perm)
+	if err != nil {
+		return fmt.Errorf("error creating necessary directories: %w", err)
+	}
+
+	fromPath := c.pkToPath(from)
+	err = os.Rename(fromPath, c.pkToPath(to))
+	if err != nil {
+		return fmt.Errorf("error renaming pk: %w", crud.FromFS(err))
+	}
+
+	err = c.deleteEmptyParents(ctx, fromPath)
+	if err != nil {
+		return fmt.Errorf("error deleting empty source directories: %w", err)
+	}
+
+	return nil
+}
@@ -1,11 +1,15 @@
 package crudos
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sis/internal/crud"
+	"sis/internal/metrics"
 	"sis/internal/pk"
+	"strings"
 )
 
 func (c CrudOs) init() error {
@@ -16,14 +20,74 @@ func (c CrudOs) init() error {
 	return nil
 }
 
+// writeFile creates key with everything read from r. If the write fails or ctx is done midway, the
+// partial file is removed
+func (c CrudOs) writeFile(ctx context.Context, key []string, r io.Reader) (metrics.Byte, error) {
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	err := os.MkdirAll(c.pkToPath(key[:len(key)-1]), c.perm)
+	if err != nil {
+		return 0, fmt.Errorf("error creating necessary directories: %w", err)
+	}
+
+	pkPath := c.pkToPath(key)
+	f, err := os.Create(pkPath)
+	if err != nil {
+		return 0, fmt.Errorf("error creating specified pk: %w", crud.FromFS(err))
+	}
+
+	written, err := io.Copy(f, contextReader{ctx: ctx, r: r})
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(pkPath)
+		c.deleteEmptyParents(ctx, pkPath)
+		return 0, fmt.Errorf("error writing data to pk: %w", err)
+	}
+
+	return metrics.Byte(written), nil
+}
+
+// contextReader stops reading once ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// checkPk rejects keys with a segment that could reach outside the root. Empty segments are joined
+// away, as in keys made from absolute paths
+func checkPk(key []string) error {
+	if len(key) == 0 {
+		return fmt.Errorf("pk cannot be empty: %w", crud.ErrInvalidKey)
+	}
+	for _, segment := range key {
+		if segment == "." || segment == ".." || strings.ContainsAny(segment, "/\x00") || strings.ContainsRune(segment, filepath.Separator) {
+			return fmt.Errorf("pk segment '%s' is not a file name: %w", segment, crud.ErrInvalidKey)
+		}
+	}
+	return nil
+}
+
 func (c CrudOs) pkToPath(pk []string) string {
 	rootedPk := append([]string{c.root}, pk...)
 	return filepath.Join(rootedPk...)
 }
 
 func (c CrudOs) absPathToPk(absPath string) (key []string) {
-	rootedPk := pk.New(absPath)
-	rootPk := pk.New(c.root)
+	rootedPk := pk.New(filepath.ToSlash(absPath))
+	rootPk := pk.New(filepath.ToSlash(c.root))
 	return rootedPk[len(rootPk):]
 }
 
@@ -43,3 +107,20 @@ func (c CrudOs) isDirEmpty(dirPath string) (bool, error) {
 	}
 	return false, nil
 }
+
+// deleteEmptyParents removes the parent directory of pkPath if it is left empty, and so on up to the root.
+// It runs even if ctx is done, since it only tidies up after a change that already happened
+func (c CrudOs) deleteEmptyParents(ctx context.Context, pkPath string) error {
+	dirPath := filepath.Dir(pkPath)
+	isDirEmpty, err := c.isDirEmpty(dirPath)
+	if err != nil {
+		return fmt.Errorf("error checking if parent directory is empty: %w", err)
+	}
+	if isDirEmpty && dirPath != c.root {
+		err := c.Delete(context.WithoutCancel(ctx), c.absPathToPk(dirPath))
+		if err != nil {
+			return fmt.Errorf("error deleting parent directory: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package crud
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sis/internal/pk"
+	"strings"
+)
+
+// errors shared by every backend and by SIS, to be checked with errors.Is
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+	ErrCorrupted     = errors.New("corrupted")
+	ErrInvalidKey    = pk.ErrInvalidKey
+	ErrConflict      = errors.New("conflict")
+	ErrReadOnly      = errors.New("read-only")
+)
+
+// a KeyError records an operation that failed on a key, like fs.PathError does for files
+type KeyError struct {
+	Op  string
+	Key []string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%s '%s': %s", e.Op, strings.Join(e.Key, "/"), e.Err)
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// FromFS maps fs.ErrNotExist and fs.ErrExist to ErrNotFound and ErrAlreadyExists, keeping err in
+// the chain. Other errors are returned as they are
+func FromFS(err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	case errors.Is(err, fs.ErrExist):
+		return fmt.Errorf("%w: %w", ErrAlreadyExists, err)
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package data_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sis/internal/data"
+	"sis/internal/pk"
+	"slices"
+	"testing"
+)
+
+func readGolden(t *testing.T, name string) []byte {
+	golden, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("error reading golden file: %s", err.Error())
+	}
+	return bytes.TrimSpace(golden)
+}
+
+func TestHeaderGolden(t *testing.T) {
+	expected := data.Header{
+		PK:       pk.New("user/data/docs/report.txt"),
+		Digest:   "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03",
+		Metadata: map[string]any{"mode": float64(420)},
+	}
+
+	// headers written before the wire form hold the pk as an array of segments
+	for _, name := range []string{"header-array.json", "header.json"} {
+		var header data.Header
+		err := json.Unmarshal(readGolden(t, name), &header)
+		if err != nil {
+			t.Fatalf("error reading '%s': %s", name, err.Error())
+		}
+		if !slices.Equal(header.PK, expected.PK) || header.Digest != expected.Digest || header.Metadata["mode"] != expected.Metadata["mode"] {
+			t.Fatalf("unexpected header from '%s': %+v", name, header)
+		}
+	}
+
+	headerBytes, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("error writing header: %s", err.Error())
+	}
+	if golden := readGolden(t, "header.json"); !bytes.Equal(headerBytes, golden) {
+		t.Fatalf("header is written as %s, expected %s", headerBytes, golden)
+	}
+}
+
+func TestBlobMetadataGolden(t *testing.T) {
+	var metadata data.BlobMetadata
+	err := json.Unmarshal(readGolden(t, "blobmetadata-array.json"), &metadata)
+	if err != nil {
+		t.Fatalf("error reading blob metadata: %s", err.Error())
+	}
+	expected := []pk.PK{{"a", "b"}, {"", "tmp", "c"}}
+	if !slices.EqualFunc(metadata.PkList, expected, slices.Equal) {
+		t.Fatalf("unexpected pk list %v", metadata.PkList)
+	}
+
+	// the wire form keeps every segment, including the empty first one of absolute paths
+	metadataBytes, err := json.Marshal(metadata)
+	if err != nil {
+		t.Fatalf("error writing blob metadata: %s", err.Error())
+	}
+	var reread data.BlobMetadata
+	err = json.Unmarshal(metadataBytes, &reread)
+	if err != nil || !slices.EqualFunc(reread.PkList, expected, slices.Equal) {
+		t.Fatalf("pk list did not round-trip through %s: %v", metadataBytes, err)
+	}
+}
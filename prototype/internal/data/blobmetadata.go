@@ -4,4 +4,6 @@ import "sis/internal/pk"
 
 type BlobMetadata struct {
 	PkList []pk.PK `json:"pkList"`
+	// Refs hold the blob for something other than a user key, like a snapshot
+	Refs []string `json:"refs,omitempty"`
 }
@@ -0,0 +1,57 @@
+package data
+
+import (
+	"io/fs"
+	"strconv"
+	"time"
+)
+
+// Header.Metadata keys describing the file a key was imported from
+const (
+	// MetadataMode holds the permission bits as an octal string
+	MetadataMode = "mode"
+	// MetadataModTime holds the modification time in RFC 3339 with nanoseconds
+	MetadataModTime = "mtime"
+	// MetadataSymlink holds the target of a key stored as a symbolic link. The blob is the target too
+	MetadataSymlink = "symlink"
+)
+
+// FileMetadata returns the metadata recording mode and modTime
+func FileMetadata(mode fs.FileMode, modTime time.Time) map[string]any {
+	return map[string]any{
+		MetadataMode:    strconv.FormatUint(uint64(mode.Perm()), 8),
+		MetadataModTime: modTime.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// FileMode returns the permission bits recorded in metadata
+func FileMode(metadata map[string]any) (fs.FileMode, bool) {
+	value, ok := metadata[MetadataMode].(string)
+	if !ok {
+		return 0, false
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, false
+	}
+	return fs.FileMode(mode).Perm(), true
+}
+
+// ModTime returns the modification time recorded in metadata
+func ModTime(metadata map[string]any) (time.Time, bool) {
+	value, ok := metadata[MetadataModTime].(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	modTime, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return modTime, true
+}
+
+// SymlinkTarget returns the link target recorded in metadata
+func SymlinkTarget(metadata map[string]any) (string, bool) {
+	target, ok := metadata[MetadataSymlink].(string)
+	return target, ok
+}
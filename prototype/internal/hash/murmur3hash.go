@@ -0,0 +1,34 @@
+package hash
+
+import gohash "hash"
+
+// murmur3Hash adapts Murmur3 to hash.Hash. Murmur3 is not incremental, so input is buffered
+// until Sum is called
+type murmur3Hash struct {
+	buf []byte
+}
+
+func NewMurmur3() gohash.Hash {
+	return &murmur3Hash{}
+}
+
+func (m *murmur3Hash) Write(p []byte) (int, error) {
+	m.buf = append(m.buf, p...)
+	return len(p), nil
+}
+
+func (m *murmur3Hash) Sum(b []byte) []byte {
+	return append(b, Murmur3(m.buf)...)
+}
+
+func (m *murmur3Hash) Reset() {
+	m.buf = m.buf[:0]
+}
+
+func (m *murmur3Hash) Size() int {
+	return 16
+}
+
+func (m *murmur3Hash) BlockSize() int {
+	return 16
+}
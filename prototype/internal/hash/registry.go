@@ -0,0 +1,62 @@
+package hash
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	gohash "hash"
+	"slices"
+)
+
+// probeInput is hashed to tell algorithms apart without relying on their names
+var probeInput = []byte("sis hash probe")
+
+var algorithms = map[string]func() gohash.Hash{
+	"md5":     md5.New,
+	"sha1":    sha1.New,
+	"sha256":  sha256.New,
+	"sha512":  sha512.New,
+	"murmur3": NewMurmur3,
+}
+
+// New returns a fresh instance of the algorithm registered under name
+func New(name string) (gohash.Hash, error) {
+	constructor, ok := algorithms[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm '%s', expected one of %v", name, Names())
+	}
+	return constructor(), nil
+}
+
+// Names lists every registered algorithm, sorted
+func Names() []string {
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Probe returns the hex digest of a fixed input. Two hashes with the same probe produce the same
+// digests. h is reset before and after probing
+func Probe(h gohash.Hash) string {
+	h.Reset()
+	h.Write(probeInput)
+	probe := fmt.Sprintf("%x", h.Sum(nil))
+	h.Reset()
+	return probe
+}
+
+// Identify returns the registered name of h, found by comparing probes
+func Identify(h gohash.Hash) (string, bool) {
+	probe := Probe(h)
+	for _, name := range Names() {
+		if Probe(algorithms[name]()) == probe {
+			return name, true
+		}
+	}
+	return "", false
+}
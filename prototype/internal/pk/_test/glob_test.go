@@ -0,0 +1,56 @@
+package pk_test
+
+import (
+	"errors"
+	"sis/internal/pk"
+	"slices"
+	"testing"
+)
+
+func TestGlob(t *testing.T) {
+	cases := []struct {
+		pattern string
+		key     string
+		match   bool
+	}{
+		{"images/2024/*/thumb-*.jpg", "images/2024/05/thumb-cat.jpg", true},
+		{"images/2024/*/thumb-*.jpg", "images/2024/05/full-cat.jpg", false},
+		{"images/2024/*/thumb-*.jpg", "images/2024/05/x/thumb-cat.jpg", false},
+		{"images/**/*.jpg", "images/a.jpg", true},
+		{"images/**/*.jpg", "images/a/b/c.jpg", true},
+		{"images/**", "images", true},
+		{"**/c", "a/b/c", true},
+		{"a/?", "a/b", true},
+		{"a/?", "a/bc", false},
+		{"a/?", "a/日", true},
+		{"a/*b*c", "a/xbybzc", true},
+		{"a/*b*c", "a/xbybzcd", false},
+		{"a:b/*", "a:b/c", true},
+		{"**/x/**", "x/y/x/z", true},
+	}
+	for _, c := range cases {
+		glob, err := pk.ParseGlob(c.pattern)
+		if err != nil {
+			t.Fatalf("error parsing '%s': %s", c.pattern, err.Error())
+		}
+		key, err := pk.Parse(c.key)
+		if err != nil {
+			t.Fatalf("error parsing '%s': %s", c.key, err.Error())
+		}
+		if key.Match(glob) != c.match {
+			t.Fatalf("'%s' matching '%s': expected %t", c.key, c.pattern, c.match)
+		}
+	}
+
+	glob, _ := pk.ParseGlob("a:b/c/*/d")
+	if !slices.Equal(glob.Prefix(), pk.PK{"a%3Ab", "c"}) {
+		t.Fatalf("unexpected prefix %v", glob.Prefix())
+	}
+
+	for _, pattern := range []string{"", "a//b", "a/../b", "a/b**", "data-header"} {
+		_, err := pk.ParseGlob(pattern)
+		if !errors.Is(err, pk.ErrInvalidKey) {
+			t.Fatalf("expected ErrInvalidKey parsing '%s', got %v", pattern, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package pk_test
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sis"
+	"sis/internal/crud/crudos"
+	"sis/internal/pk"
+	"slices"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	keys := []string{"a", "a/b/c", "dir/file name.txt", "a:b/c*?", "100%/x", "back\\slash", "tab\there", "ünï/日本語", "..a/b..", "con", "x/Nul.txt", "lpt1 .log", "trailing /space"}
+	for _, key := range keys {
+		parsed, err := pk.Parse(key)
+		if err != nil {
+			t.Fatalf("error parsing '%s': %s", key, err.Error())
+		}
+		if err := parsed.Validate(); err != nil {
+			t.Fatalf("parsed '%s' is not valid: %s", key, err.Error())
+		}
+		if parsed.Format() != key {
+			t.Fatalf("'%s' formatted as '%s'", key, parsed.Format())
+		}
+	}
+
+	parsed, _ := pk.Parse("a:b/100%")
+	if !slices.Equal(parsed, pk.PK{"a%3Ab", "100%25"}) {
+		t.Fatalf("unexpected escaping %v", parsed)
+	}
+
+	// Windows drops trailing dots and spaces and opens device names whatever their extension
+	parsed, _ = pk.Parse("con/aux.tar.gz/a.")
+	if !slices.Equal(parsed, pk.PK{"%63on", "%61ux.tar.gz", "a%2E"}) {
+		t.Fatalf("unexpected escaping %v", parsed)
+	}
+
+	invalid := []string{"", "/a", "a/", "a//b", "./a", "a/..", "data-header", "a/data-versions", "a/Data-Header", "\xff"}
+	for _, key := range invalid {
+		_, err := pk.Parse(key)
+		if !errors.Is(err, pk.ErrInvalidKey) {
+			t.Fatalf("expected ErrInvalidKey parsing '%s', got %v", key, err)
+		}
+	}
+
+	for _, key := range []pk.PK{{}, {"", "a"}, {"a/b"}, {"%2e%2e"}, {"%2E%2E"}, {"%zz"}, {"a%3ab"}} {
+		if !errors.Is(key.Validate(), pk.ErrInvalidKey) {
+			t.Fatalf("expected %v to be invalid", key)
+		}
+	}
+}
+
+func TestParsedKeysRoundTrip(t *testing.T) {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+
+	keys := []string{"x/a:b", "x/a%3Ab", "x/q?/r"}
+	for _, key := range keys {
+		parsed, _ := pk.Parse(key)
+		err = sisInstance.Create(ctx, parsed, []byte(key))
+		if err != nil {
+			t.Fatalf("error creating '%s': %s", key, err.Error())
+		}
+	}
+
+	listed, err := sisInstance.List(ctx, pk.PK{"x"})
+	if err != nil {
+		t.Fatalf("error listing: %s", err.Error())
+	}
+	formatted := make([]string, len(listed))
+	for i, key := range listed {
+		formatted[i] = key.Format()
+		blob, err := sisInstance.Read(ctx, key)
+		if err != nil || string(blob) != formatted[i] {
+			t.Fatalf("unexpected contents of '%s': %q, %v", formatted[i], blob, err)
+		}
+	}
+	slices.Sort(formatted)
+	slices.Sort(keys)
+	if !slices.Equal(formatted, keys) {
+		t.Fatalf("expected keys %v, got %v", keys, formatted)
+	}
+
+	err = sisInstance.Create(ctx, pk.PK{"x", "a/b"}, []byte("x"))
+	if !errors.Is(err, sis.ErrInvalidKey) {
+		t.Fatalf("expected ErrInvalidKey for an unescaped key, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package pk
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// a Glob selects keys by a '/' separated pattern. In a segment '*' matches any run of characters
+// and '?' a single character, and a '**' segment matches any number of segments, none included.
+// Patterns match the unescaped segments, the way keys are written to Parse
+type Glob struct {
+	segments []string
+}
+
+// ParseGlob checks pattern with the rules of Parse, except for wildcards
+func ParseGlob(pattern string) (Glob, error) {
+	if !utf8.ValidString(pattern) {
+		return Glob{}, fmt.Errorf("pattern '%s' is not valid UTF-8: %w", pattern, ErrInvalidKey)
+	}
+	if pattern == "" {
+		return Glob{}, fmt.Errorf("pattern cannot be empty: %w", ErrInvalidKey)
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(pattern, "/") {
+		if strings.Contains(segment, "**") && segment != "**" {
+			return Glob{}, fmt.Errorf("pattern '%s': '**' must be a whole segment: %w", pattern, ErrInvalidKey)
+		}
+		if segment == "**" && len(segments) > 0 && segments[len(segments)-1] == "**" {
+			continue
+		}
+		if !isWildcard(segment) {
+			err := checkSegment(segment)
+			if err != nil {
+				return Glob{}, fmt.Errorf("pattern '%s': %w", pattern, err)
+			}
+		}
+		segments = append(segments, segment)
+	}
+	return Glob{segments: segments}, nil
+}
+
+func (g Glob) String() string {
+	return strings.Join(g.segments, "/")
+}
+
+// Prefix is the key of the literal segments before the first wildcard. Every match is under it
+func (g Glob) Prefix() PK {
+	return g.Literal(0)
+}
+
+// Literal is the key of the literal segments of g from segment i up to the next wildcard
+func (g Glob) Literal(i int) PK {
+	var prefix PK
+	for _, segment := range g.segments[i:] {
+		if isWildcard(segment) {
+			break
+		}
+		prefix = append(prefix, EscapeSegment(segment))
+	}
+	return prefix
+}
+
+// Len is the number of segments of g
+func (g Glob) Len() int {
+	return len(g.segments)
+}
+
+// IsRecursive reports whether segment i of g is '**'
+func (g Glob) IsRecursive(i int) bool {
+	return g.segments[i] == "**"
+}
+
+// MatchSegment reports whether the key segment matches segment i of g, which must not be '**'
+func (g Glob) MatchSegment(i int, segment string) bool {
+	unescaped, err := UnescapeSegment(segment)
+	if err != nil {
+		return false
+	}
+	return matchSegment(g.segments[i], unescaped)
+}
+
+// Match reports whether key matches g
+func (g Glob) Match(key PK) bool {
+	return g.match(0, key)
+}
+
+func (g Glob) match(i int, key PK) bool {
+	if i == len(g.segments) {
+		return len(key) == 0
+	}
+	if g.IsRecursive(i) {
+		for skipped := 0; skipped <= len(key); skipped++ {
+			if g.match(i+1, key[skipped:]) {
+				return true
+			}
+		}
+		return false
+	}
+	return len(key) > 0 && g.MatchSegment(i, key[0]) && g.match(i+1, key[1:])
+}
+
+// Match reports whether pk matches g
+func (pk PK) Match(g Glob) bool {
+	return g.Match(pk)
+}
+
+func isWildcard(segment string) bool {
+	return strings.ContainsAny(segment, "*?")
+}
+
+// matchSegment matches name against a pattern of '*' and '?', backtracking to the last '*'
+func matchSegment(pattern, name string) bool {
+	p, n := []rune(pattern), []rune(name)
+	pi, ni := 0, 0
+	star, starNi := -1, 0
+	for ni < len(n) {
+		switch {
+		case pi < len(p) && p[pi] == '*':
+			star, starNi = pi, ni
+			pi++
+		case pi < len(p) && (p[pi] == '?' || p[pi] == n[ni]):
+			pi++
+			ni++
+		case star >= 0:
+			pi = star + 1
+			starNi++
+			ni = starNi
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
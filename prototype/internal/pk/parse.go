@@ -0,0 +1,159 @@
+package pk
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrInvalidKey = errors.New("invalid key")
+
+// reservedSegments are the names SIS gives to its own files next to user keys. They are reserved
+// in any case, since case-insensitive file systems do not tell them apart
+var reservedSegments = []string{"data-header", "data-versions"}
+
+// unsafeChars are escaped in segments along with control characters, so that a segment never holds
+// a separator or a character Windows refuses in file names
+const unsafeChars = "%/\\:*?\"<>|"
+
+// windowsDevices are the names Windows opens as devices, in any case and with any extension
+var windowsDevices = []string{
+	"CON", "PRN", "AUX", "NUL",
+	"COM0", "COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+	"LPT0", "LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9",
+}
+
+// Parse turns a '/' separated key, like the keys of HTTP requests or of the command line, into a
+// PK. Keys must be valid UTF-8 with no empty, '.' or '..' segments and no segment named like the
+// files SIS keeps next to user keys. Unsafe characters are percent-escaped, and Format reverses it
+func Parse(key string) (PK, error) {
+	if !utf8.ValidString(key) {
+		return nil, fmt.Errorf("key '%s' is not valid UTF-8: %w", key, ErrInvalidKey)
+	}
+	if key == "" {
+		return nil, fmt.Errorf("key cannot be empty: %w", ErrInvalidKey)
+	}
+
+	segments := strings.Split(key, "/")
+	parsed := make(PK, len(segments))
+	for i, segment := range segments {
+		err := checkSegment(segment)
+		if err != nil {
+			return nil, fmt.Errorf("key '%s': %w", key, err)
+		}
+		parsed[i] = EscapeSegment(segment)
+	}
+	return parsed, nil
+}
+
+// FromPath maps a file path to a PK with the rules of Parse, dropping its leading separator
+func FromPath(path string) (PK, error) {
+	return Parse(strings.TrimPrefix(filepath.ToSlash(path), "/"))
+}
+
+// Format joins the unescaped segments of pk with '/', so that Format(Parse(key)) is key. Segments
+// that are not valid escapes, like those of keys made with New, are kept as they are
+func (pk PK) Format() string {
+	segments := make([]string, len(pk))
+	for i, segment := range pk {
+		unescaped, err := UnescapeSegment(segment)
+		if err != nil {
+			unescaped = segment
+		}
+		segments[i] = unescaped
+	}
+	return strings.Join(segments, "/")
+}
+
+// Validate checks that pk could have been returned by Parse
+func (pk PK) Validate() error {
+	if len(pk) == 0 {
+		return fmt.Errorf("key cannot be empty: %w", ErrInvalidKey)
+	}
+	for _, segment := range pk {
+		unescaped, err := UnescapeSegment(segment)
+		if err != nil {
+			return err
+		}
+		if !utf8.ValidString(unescaped) {
+			return fmt.Errorf("segment '%s' is not valid UTF-8: %w", segment, ErrInvalidKey)
+		}
+		err = checkSegment(unescaped)
+		if err != nil {
+			return err
+		}
+		if EscapeSegment(unescaped) != segment {
+			return fmt.Errorf("segment '%s' has unescaped characters: %w", segment, ErrInvalidKey)
+		}
+	}
+	return nil
+}
+
+// EscapeSegment percent-escapes the unsafe characters of segment. The first character of a device
+// name and a trailing dot or space, which Windows drops, are escaped too
+func EscapeSegment(segment string) string {
+	device := isDeviceName(segment)
+	var escaped strings.Builder
+	for i := 0; i < len(segment); i++ {
+		b := segment[i]
+		trailing := i == len(segment)-1 && (b == '.' || b == ' ')
+		if b < 0x20 || b == 0x7f || strings.IndexByte(unsafeChars, b) >= 0 || trailing || device && i == 0 {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+			continue
+		}
+		escaped.WriteByte(b)
+	}
+	return escaped.String()
+}
+
+// UnescapeSegment reverses EscapeSegment
+func UnescapeSegment(segment string) (string, error) {
+	var unescaped strings.Builder
+	for i := 0; i < len(segment); i++ {
+		if segment[i] != '%' {
+			unescaped.WriteByte(segment[i])
+			continue
+		}
+		if i+2 >= len(segment) || !isHex(segment[i+1]) || !isHex(segment[i+2]) {
+			return "", fmt.Errorf("segment '%s' has a malformed escape: %w", segment, ErrInvalidKey)
+		}
+		unescaped.WriteByte(unhex(segment[i+1])<<4 | unhex(segment[i+2]))
+		i += 2
+	}
+	return unescaped.String(), nil
+}
+
+func checkSegment(segment string) error {
+	switch {
+	case segment == "":
+		return fmt.Errorf("empty segment: %w", ErrInvalidKey)
+	case segment == "." || segment == "..":
+		return fmt.Errorf("segment '%s' is not allowed: %w", segment, ErrInvalidKey)
+	case slices.ContainsFunc(reservedSegments, func(reserved string) bool { return strings.EqualFold(reserved, segment) }):
+		return fmt.Errorf("segment '%s' is reserved: %w", segment, ErrInvalidKey)
+	}
+	return nil
+}
+
+func isDeviceName(segment string) bool {
+	base, _, _ := strings.Cut(segment, ".")
+	base = strings.TrimRight(base, " ")
+	return slices.ContainsFunc(windowsDevices, func(device string) bool { return strings.EqualFold(device, base) })
+}
+
+func isHex(b byte) bool {
+	return '0' <= b && b <= '9' || 'A' <= b && b <= 'F' || 'a' <= b && b <= 'f'
+}
+
+func unhex(b byte) byte {
+	switch {
+	case b <= '9':
+		return b - '0'
+	case b <= 'F':
+		return b - 'A' + 10
+	}
+	return b - 'a' + 10
+}
@@ -1,21 +1,23 @@
 package pk
 
 import (
-	"os"
-	"path/filepath"
+	"encoding/json"
 	"slices"
 	"strings"
 )
 
-// a PK (primary key) mimics directory hierarchy. It can represent a directory or a file.
+// a PK (primary key) mimics directory hierarchy. It can represent a directory or a file. Its wire
+// form, used by String, MarshalText and refs, joins segments with '/' on every platform. Only
+// backends map it to OS paths
 type PK []string
 
-func New(path string) PK {
-	return strings.Split(path, string(os.PathSeparator))
+// New splits a '/' separated key. Use FromPath for OS paths, and Parse for untrusted input
+func New(key string) PK {
+	return strings.Split(key, "/")
 }
 
 func (pk PK) String() string {
-	return strings.Join(pk, string(filepath.Separator))
+	return strings.Join(pk, "/")
 }
 
 func (pk PK) Prefix(prefix PK) PK {
@@ -26,6 +28,39 @@ func (pk PK) Suffix(suffix PK) PK {
 	return slices.Concat(pk, suffix)
 }
 
+// Path is the wire form of pk, like String
 func (pk PK) Path() string {
-	return strings.Join(pk, string(os.PathSeparator))
+	return pk.String()
+}
+
+func (pk PK) MarshalText() ([]byte, error) {
+	return []byte(pk.String()), nil
+}
+
+func (pk *PK) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*pk = PK{}
+		return nil
+	}
+	*pk = New(string(text))
+	return nil
+}
+
+// UnmarshalJSON reads the wire form, and the array of segments written before it existed
+func (pk *PK) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '[' {
+		var segments []string
+		err := json.Unmarshal(data, &segments)
+		if err != nil {
+			return err
+		}
+		*pk = segments
+		return nil
+	}
+	var text string
+	err := json.Unmarshal(data, &text)
+	if err != nil {
+		return err
+	}
+	return pk.UnmarshalText([]byte(text))
 }
@@ -0,0 +1,186 @@
+package server_test
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sis"
+	"sis/internal/crud/crudos"
+	"sis/server"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	ctx := t.Context()
+	crudOs, err := crudos.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating crudos instance: %s", err.Error())
+	}
+	sisInstance, err := sis.New(ctx, sha256.New(), crudOs)
+	if err != nil {
+		t.Fatalf("error creating sis instance: %s", err.Error())
+	}
+	testServer := httptest.NewServer(server.New(&sisInstance))
+	t.Cleanup(testServer.Close)
+	return testServer
+}
+
+func do(t *testing.T, method, url, body string, headers map[string]string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("error creating request: %s", err.Error())
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error on %s %s: %s", method, url, err.Error())
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func expectStatus(t *testing.T, resp *http.Response, status int) {
+	t.Helper()
+	if resp.StatusCode != status {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("%s %s: expected status %d, got %d: %s", resp.Request.Method, resp.Request.URL, status, resp.StatusCode, body)
+	}
+}
+
+func TestObjects(t *testing.T) {
+	testServer := newTestServer(t)
+	objectURL := testServer.URL + "/objects/hello/world"
+
+	resp := do(t, http.MethodPut, objectURL, "hello, world", nil)
+	expectStatus(t, resp, http.StatusCreated)
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("expected an ETag on PUT")
+	}
+
+	resp = do(t, http.MethodPut, testServer.URL+"/objects/hello/copy", "hello, world", map[string]string{"If-None-Match": "*"})
+	expectStatus(t, resp, http.StatusCreated)
+	if resp.Header.Get("ETag") != etag {
+		t.Fatalf("expected identical content to share the ETag")
+	}
+
+	resp = do(t, http.MethodPut, objectURL, "other", map[string]string{"If-None-Match": "*"})
+	expectStatus(t, resp, http.StatusPreconditionFailed)
+
+	resp = do(t, http.MethodGet, objectURL, "", nil)
+	expectStatus(t, resp, http.StatusOK)
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "hello, world" {
+		t.Fatalf("expected 'hello, world', got '%s'", body)
+	}
+
+	resp = do(t, http.MethodGet, objectURL, "", map[string]string{"Range": "bytes=7-11"})
+	expectStatus(t, resp, http.StatusPartialContent)
+	body, _ = io.ReadAll(resp.Body)
+	if string(body) != "world" {
+		t.Fatalf("expected 'world', got '%s'", body)
+	}
+
+	resp = do(t, http.MethodGet, objectURL, "", map[string]string{"If-None-Match": etag})
+	expectStatus(t, resp, http.StatusNotModified)
+
+	resp = do(t, http.MethodHead, objectURL, "", nil)
+	expectStatus(t, resp, http.StatusOK)
+	if resp.ContentLength != int64(len("hello, world")) {
+		t.Fatalf("expected content length %d on HEAD, got %d", len("hello, world"), resp.ContentLength)
+	}
+
+	resp = do(t, http.MethodPut, objectURL, "bye", map[string]string{"If-Match": `"stale"`})
+	expectStatus(t, resp, http.StatusPreconditionFailed)
+
+	resp = do(t, http.MethodPut, objectURL, "bye", map[string]string{"If-Match": etag})
+	expectStatus(t, resp, http.StatusOK)
+	if resp.Header.Get("ETag") == etag {
+		t.Fatalf("expected ETag to change on update")
+	}
+
+	resp = do(t, http.MethodGet, testServer.URL+"/objects?prefix=hello", "", nil)
+	expectStatus(t, resp, http.StatusOK)
+	var keys []string
+	err := json.NewDecoder(resp.Body).Decode(&keys)
+	if err != nil {
+		t.Fatalf("error decoding listing: %s", err.Error())
+	}
+	if len(keys) != 2 || keys[0] != "hello/copy" || keys[1] != "hello/world" {
+		t.Fatalf("unexpected listing %v", keys)
+	}
+
+	resp = do(t, http.MethodGet, testServer.URL+"/stats", "", nil)
+	expectStatus(t, resp, http.StatusOK)
+	var stats server.Stats
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	if err != nil {
+		t.Fatalf("error decoding stats: %s", err.Error())
+	}
+	if stats.Keys != 2 || stats.Blobs != 2 {
+		t.Fatalf("expected 2 keys and 2 blobs, got %+v", stats)
+	}
+
+	resp = do(t, http.MethodDelete, objectURL, "", nil)
+	expectStatus(t, resp, http.StatusNoContent)
+
+	resp = do(t, http.MethodGet, objectURL, "", nil)
+	expectStatus(t, resp, http.StatusNotFound)
+
+	resp = do(t, http.MethodGet, testServer.URL+"/objects/../sys/manifest", "", nil)
+	if resp.StatusCode == http.StatusOK {
+		t.Fatalf("expected keys escaping the user namespace to be refused")
+	}
+}
+
+func TestConcurrentConditionalPuts(t *testing.T) {
+	testServer := newTestServer(t)
+	objectURL := testServer.URL + "/objects/counter"
+
+	resp := do(t, http.MethodPut, objectURL, "0", nil)
+	expectStatus(t, resp, http.StatusCreated)
+	etag := resp.Header.Get("ETag")
+
+	// every writer read the same version, so only one of them may replace it
+	const writers = 8
+	statuses := make([]int, writers)
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, err := http.NewRequest(http.MethodPut, objectURL, strings.NewReader(strings.Repeat("1", i+1)))
+			if err != nil {
+				return
+			}
+			req.Header.Set("If-Match", etag)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return
+			}
+			resp.Body.Close()
+			statuses[i] = resp.StatusCode
+		}()
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, status := range statuses {
+		switch status {
+		case http.StatusOK:
+			succeeded++
+		case http.StatusPreconditionFailed:
+		default:
+			t.Fatalf("unexpected status %d", status)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("expected a single conditional put to succeed, got %d", succeeded)
+	}
+}
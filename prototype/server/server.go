@@ -0,0 +1,244 @@
+// Package server exposes a SIS instance over HTTP
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sis"
+	"sis/internal/metrics"
+	"sis/internal/pk"
+	"strings"
+	"time"
+)
+
+// a Server serves the objects of a sis.Store:
+//
+//	PUT    /objects/{pk...}  creates or replaces an object
+//	GET    /objects/{pk...}  reads an object, with range and conditional requests
+//	HEAD   /objects/{pk...}  like GET, without the body
+//	DELETE /objects/{pk...}  deletes an object
+//	GET    /objects?prefix=  lists the keys under prefix
+//	GET    /stats            reports deduplication statistics
+//
+// The ETag of an object is its digest
+type Server struct {
+	store sis.Store
+	mux   *http.ServeMux
+}
+
+func New(store sis.Store) *Server {
+	srv := &Server{
+		store: store,
+		mux:   http.NewServeMux(),
+	}
+	srv.mux.HandleFunc("PUT /objects/{pk...}", srv.putObject)
+	srv.mux.HandleFunc("GET /objects/{pk...}", srv.getObject)
+	srv.mux.HandleFunc("DELETE /objects/{pk...}", srv.deleteObject)
+	srv.mux.HandleFunc("GET /objects", srv.listObjects)
+	srv.mux.HandleFunc("GET /stats", srv.stats)
+	return srv
+}
+
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.mux.ServeHTTP(w, r)
+}
+
+// Stats is the body of GET /stats
+type Stats struct {
+	sis.Usage
+	PhysicalBytes metrics.Byte `json:"physicalBytes"`
+	DedupRatio    float64      `json:"dedupRatio"`
+}
+
+func (srv *Server) putObject(w http.ResponseWriter, r *http.Request) {
+	key, ok := parseKey(w, r.PathValue("pk"))
+	if !ok {
+		return
+	}
+
+	// conditions are checked by the store under the same lock as the write
+	created, err := srv.store.PutIf(r.Context(), key, r.Body, func(digest string, exists bool) bool {
+		current := etag(digest)
+		return ifMatch(r, current, exists) && ifNoneMatch(r, current, exists)
+	})
+	if err != nil {
+		httpError(w, statusOf(err), fmt.Errorf("error writing '%s': %w", key.Format(), err))
+		return
+	}
+
+	info, err := srv.store.Stat(r.Context(), key)
+	if err != nil {
+		httpError(w, statusOf(err), err)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	w.Header().Set("ETag", etag(info.Digest))
+	writeJSON(w, status, info)
+}
+
+func (srv *Server) getObject(w http.ResponseWriter, r *http.Request) {
+	key, ok := parseKey(w, r.PathValue("pk"))
+	if !ok {
+		return
+	}
+
+	exists, err := srv.store.Exists(r.Context(), key)
+	if err != nil {
+		httpError(w, statusOf(err), err)
+		return
+	}
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	reader, info, err := srv.store.Open(r.Context(), key)
+	if err != nil {
+		httpError(w, statusOf(err), err)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("ETag", etag(info.Digest))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	// ServeContent handles HEAD, Range, If-Match, If-None-Match and If-Range against the ETag
+	http.ServeContent(w, r, key.Format(), time.Time{}, reader)
+}
+
+func (srv *Server) deleteObject(w http.ResponseWriter, r *http.Request) {
+	key, ok := parseKey(w, r.PathValue("pk"))
+	if !ok {
+		return
+	}
+
+	// missing keys pass, so that they are reported as not found
+	err := srv.store.DeleteIf(r.Context(), key, func(digest string, exists bool) bool {
+		return !exists || ifMatch(r, etag(digest), exists)
+	})
+	if err != nil {
+		httpError(w, statusOf(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (srv *Server) listObjects(w http.ResponseWriter, r *http.Request) {
+	prefix := pk.PK{}
+	if rawPrefix := r.URL.Query().Get("prefix"); rawPrefix != "" {
+		var ok bool
+		prefix, ok = parseKey(w, rawPrefix)
+		if !ok {
+			return
+		}
+	}
+
+	keys, err := srv.store.List(r.Context(), prefix)
+	if err != nil {
+		httpError(w, statusOf(err), err)
+		return
+	}
+
+	paths := make([]string, len(keys))
+	for i, key := range keys {
+		paths[i] = key.Format()
+	}
+
+	writeJSON(w, http.StatusOK, paths)
+}
+
+func (srv *Server) stats(w http.ResponseWriter, r *http.Request) {
+	usage, err := srv.store.Usage(r.Context())
+	if err != nil {
+		httpError(w, statusOf(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Stats{
+		Usage:         usage,
+		PhysicalBytes: usage.PhysicalBytes(),
+		DedupRatio:    usage.DedupRatio(),
+	})
+}
+
+// parseKey turns the '/' separated key of a request into a pk, rejecting invalid keys, see pk.Parse
+func parseKey(w http.ResponseWriter, rawKey string) (pk.PK, bool) {
+	key, err := pk.Parse(strings.Trim(rawKey, "/"))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	return key, true
+}
+
+func etag(digest string) string {
+	return `"` + digest + `"`
+}
+
+// ifMatch reports whether the If-Match precondition of r holds
+func ifMatch(r *http.Request, current string, exists bool) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	if !exists {
+		return false
+	}
+	return etagListContains(header, current)
+}
+
+// ifNoneMatch reports whether the If-None-Match precondition of r holds
+func ifNoneMatch(r *http.Request, current string, exists bool) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" || !exists {
+		return true
+	}
+	return !etagListContains(header, current)
+}
+
+func etagListContains(header, current string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == current {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("error writing response: %s", err)
+	}
+}
+
+// statusOf maps the errors of the store to a response status
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, sis.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, sis.ErrAlreadyExists), errors.Is(err, sis.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, sis.ErrInvalidKey):
+		return http.StatusBadRequest
+	case errors.Is(err, sis.ErrReadOnly):
+		return http.StatusForbidden
+	case errors.Is(err, sis.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusInternalServerError
+}
+
+func httpError(w http.ResponseWriter, status int, err error) {
+	http.Error(w, err.Error(), status)
+}
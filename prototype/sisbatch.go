@@ -0,0 +1,314 @@
+package sis
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sis/internal/crud"
+	"sis/internal/metrics"
+	"sis/internal/pk"
+	"slices"
+)
+
+// a Batch groups creates, updates, deletes and copies that become visible together on Commit, or
+// not at all. Blobs are staged and hashed as operations are added, then Commit publishes the new
+// ones and applies every header and reference change as a single journaled intent, under the write
+// lock.
+// A Batch is not safe for concurrent use
+type Batch struct {
+	s   *SIS
+	ops []batchOp
+	// touched holds the keys written by the batch, read holds the sources of copies
+	touched map[string]bool
+	read    map[string]bool
+	done    bool
+	// add and apply are wrapped by the middlewares of the store the batch was started from, add
+	// around every operation added, so that they can refuse it before its blob is staged
+	add   func(ctx context.Context, key pk.PK, size metrics.Byte, next func(ctx context.Context) error) error
+	apply func(ctx context.Context) error
+}
+
+type batchOpKind int
+
+const (
+	batchCreate batchOpKind = iota
+	batchUpdate
+	batchDelete
+	batchCopy
+)
+
+type batchOp struct {
+	kind batchOpKind
+	key  pk.PK
+	// src is the key copied from
+	src pk.PK
+	// the blob of creates and updates is staged under stagedPk, or kept in blob when the backend
+	// cannot stream
+	stagedPk pk.PK
+	blob     []byte
+	// digest of the blob, computed by the hash generation
+	digest     string
+	generation int
+}
+
+// Batch starts an empty batch
+func (s *SIS) Batch() *Batch {
+	b := &Batch{
+		s:       s,
+		touched: make(map[string]bool),
+		read:    make(map[string]bool),
+	}
+	b.add = func(ctx context.Context, _ pk.PK, _ metrics.Byte, next func(ctx context.Context) error) error {
+		return next(ctx)
+	}
+	b.apply = b.commit
+	return b
+}
+
+func (b *Batch) Create(ctx context.Context, key pk.PK, blob []byte) error {
+	return b.add(ctx, key, metrics.Byte(len(blob)), func(ctx context.Context) error {
+		return b.addWrite(ctx, batchCreate, key, blob)
+	})
+}
+
+func (b *Batch) Update(ctx context.Context, key pk.PK, blob []byte) error {
+	return b.add(ctx, key, metrics.Byte(len(blob)), func(ctx context.Context) error {
+		return b.addWrite(ctx, batchUpdate, key, blob)
+	})
+}
+
+func (b *Batch) Delete(key pk.PK) error {
+	return b.add(context.Background(), key, 0, func(context.Context) error {
+		err := b.claim(key)
+		if err != nil {
+			return err
+		}
+		b.ops = append(b.ops, batchOp{kind: batchDelete, key: key})
+		return nil
+	})
+}
+
+// Copy makes dst point at the contents src has before the batch
+func (b *Batch) Copy(src, dst pk.PK) error {
+	return b.add(context.Background(), dst, 0, func(context.Context) error {
+		return b.addCopy(src, dst)
+	})
+}
+
+func (b *Batch) addCopy(src, dst pk.PK) error {
+	if b.done {
+		return fmt.Errorf("batch is already committed or aborted")
+	}
+	if b.touched[src.Path()] {
+		return &KeyError{Op: "copy", Key: src, Err: fmt.Errorf("%w: written by the batch", ErrConflict)}
+	}
+	err := checkKey("copy", dst)
+	if err != nil {
+		return err
+	}
+	err = b.claim(dst)
+	if err != nil {
+		return err
+	}
+	b.read[src.Path()] = true
+	b.ops = append(b.ops, batchOp{kind: batchCopy, key: dst, src: src})
+	return nil
+}
+
+// Commit applies the batch. It fails without changing any key if an operation cannot be applied,
+// like a create of an existing key
+func (b *Batch) Commit(ctx context.Context) error {
+	if b.done {
+		return fmt.Errorf("batch is already committed or aborted")
+	}
+	b.done = true
+	defer b.dropStaged(ctx)
+
+	return b.apply(ctx)
+}
+
+func (b *Batch) commit(ctx context.Context) error {
+	s := b.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	digests := make([]string, len(b.ops))
+	for i, op := range b.ops {
+		if op.kind != batchCreate && op.kind != batchUpdate {
+			continue
+		}
+		digests[i] = op.digest
+		if op.generation == s.h.currentGeneration() {
+			continue
+		}
+		// a Rehasher switched the hash since the blob was staged
+		var err error
+		digests[i], _, err = b.digestOf(ctx, op)
+		if err != nil {
+			return fmt.Errorf("error hashing blob of '%s': %w", op.key.Path(), err)
+		}
+	}
+
+	intentOps, err := b.intentOps(ctx, digests)
+	if err != nil {
+		return err
+	}
+
+	// new blobs go first. Until the intent is applied nothing points at them, and an interrupted
+	// commit only leaves them for GC
+	for i, op := range b.ops {
+		if digests[i] == "" {
+			continue
+		}
+		err = b.publishBlob(ctx, op, digests[i])
+		if err != nil {
+			return fmt.Errorf("error publishing blob of '%s': %w", op.key.Path(), err)
+		}
+	}
+
+	return s.runIntent(ctx, intentOps)
+}
+
+// Abort drops the batch and its staged blobs
+func (b *Batch) Abort() error {
+	if b.done {
+		return fmt.Errorf("batch is already committed or aborted")
+	}
+	b.done = true
+	b.dropStaged(context.Background())
+	return nil
+}
+
+func (b *Batch) claim(key pk.PK) error {
+	if b.done {
+		return fmt.Errorf("batch is already committed or aborted")
+	}
+	if b.touched[key.Path()] || b.read[key.Path()] {
+		return &KeyError{Op: "batch", Key: key, Err: fmt.Errorf("%w: already used by the batch", ErrConflict)}
+	}
+	b.touched[key.Path()] = true
+	return nil
+}
+
+func (b *Batch) addWrite(ctx context.Context, kind batchOpKind, key pk.PK, blob []byte) error {
+	err := checkKey("batch", key)
+	if err != nil {
+		return err
+	}
+	err = b.claim(key)
+	if err != nil {
+		return err
+	}
+
+	op := batchOp{kind: kind, key: key}
+	streamer, ok := b.s.crud.(crud.Streamer)
+	if ok {
+		op.stagedPk, err = b.s.stage(ctx, streamer, bytes.NewReader(blob))
+		if err != nil {
+			delete(b.touched, key.Path())
+			return fmt.Errorf("error staging blob: %w", err)
+		}
+	} else {
+		op.blob = slices.Clone(blob)
+	}
+
+	op.digest, op.generation, err = b.digestOf(ctx, op)
+	if err != nil {
+		delete(b.touched, key.Path())
+		if op.stagedPk != nil {
+			b.s.dropStaged(ctx, op.stagedPk)
+		}
+		return fmt.Errorf("error hashing blob: %w", err)
+	}
+
+	b.ops = append(b.ops, op)
+	return nil
+}
+
+// intentOps checks every operation against the store and turns them into intent ops, puts first.
+// Callers must hold the write lock
+func (b *Batch) intentOps(ctx context.Context, digests []string) ([]intentOp, error) {
+	s := b.s
+
+	var puts, deletes []intentOp
+	for i, op := range b.ops {
+		exists, err := s.pkExists(ctx, op.key)
+		if err != nil {
+			return nil, fmt.Errorf("error on s.pkExists: %w", err)
+		}
+
+		switch op.kind {
+		case batchCreate:
+			if exists {
+				return nil, &KeyError{Op: "create", Key: op.key, Err: ErrAlreadyExists}
+			}
+			puts = append(puts, intentOp{Kind: intentPut, PK: op.key, Digest: digests[i]})
+
+		case batchUpdate:
+			if !exists {
+				return nil, &KeyError{Op: "update", Key: op.key, Err: ErrNotFound}
+			}
+			header, err := s.readDataHeader(ctx, op.key)
+			if err != nil {
+				return nil, fmt.Errorf("error on data header read: %w", err)
+			}
+			puts = append(puts, intentOp{Kind: intentPut, PK: op.key, Digest: digests[i], Metadata: header.Metadata})
+
+		case batchDelete:
+			if !exists {
+				return nil, &KeyError{Op: "delete", Key: op.key, Err: ErrNotFound}
+			}
+			deletes = append(deletes, intentOp{Kind: intentDelete, PK: op.key})
+
+		case batchCopy:
+			if exists {
+				return nil, &KeyError{Op: "copy", Key: op.key, Err: ErrAlreadyExists}
+			}
+			copyOps, err := s.relinkOps(ctx, []pk.PK{op.src}, []pk.PK{op.key}, false)
+			if err != nil {
+				return nil, err
+			}
+			puts = append(puts, copyOps...)
+		}
+	}
+
+	return slices.Concat(puts, deletes), nil
+}
+
+// digestOf hashes the blob of op without the store lock
+func (b *Batch) digestOf(ctx context.Context, op batchOp) (string, int, error) {
+	if op.stagedPk == nil {
+		return b.s.h.sum(bytes.NewReader(op.blob))
+	}
+	return b.s.digestStaged(ctx, b.s.crud.(crud.Streamer), op.stagedPk)
+}
+
+// publishBlob moves the blob of op to digest, unless the store already holds it
+func (b *Batch) publishBlob(ctx context.Context, op batchOp, digest string) error {
+	s := b.s
+
+	exists, err := s.digestExists(ctx, digest)
+	if err != nil {
+		return fmt.Errorf("error on s.digestExists: %w", err)
+	}
+	if exists {
+		return nil
+	}
+
+	if op.stagedPk == nil {
+		return s.persistBlob(ctx, digest, op.blob)
+	}
+	err = s.crud.(crud.Streamer).Rename(ctx, op.stagedPk, blobPk(digest))
+	if err != nil {
+		return fmt.Errorf("error moving staged blob: %w", err)
+	}
+	return s.persistEmptyBlobMetadata(ctx, digest)
+}
+
+func (b *Batch) dropStaged(ctx context.Context) {
+	for _, op := range b.ops {
+		if op.stagedPk != nil {
+			b.s.dropStaged(ctx, op.stagedPk)
+		}
+	}
+}
@@ -0,0 +1,534 @@
+package sis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"maps"
+	"sis/internal/constants"
+	"sis/internal/metrics"
+	"sis/internal/pk"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type ChangeKind string
+
+const (
+	ChangeCreate ChangeKind = "create"
+	ChangeUpdate ChangeKind = "update"
+	ChangeDelete ChangeKind = "delete"
+	// ChangeMetadata replaces the metadata of a key and leaves its contents as they are
+	ChangeMetadata ChangeKind = "metadata"
+)
+
+// a Change is a mutation of a user key, as recorded in the change feed
+type Change struct {
+	Seq  uint64     `json:"seq"`
+	Kind ChangeKind `json:"kind"`
+	PK   pk.PK      `json:"pk"`
+	// OldDigest is empty for creates, NewDigest for deletes. Both are set for metadata changes
+	OldDigest string `json:"oldDigest,omitempty"`
+	NewDigest string `json:"newDigest,omitempty"`
+	// Size is the size of the new blob, or of the deleted one
+	Size metrics.Byte `json:"size"`
+	// NewBlob is set when the write stored a blob the store did not have, and unset when it was deduplicated
+	NewBlob bool      `json:"newBlob"`
+	Time    time.Time `json:"time"`
+}
+
+// ErrChangesDropped is returned when the changes asked for were removed by retention. The reader
+// must resynchronize from the current contents of the store
+var ErrChangesDropped = errors.New("changes were dropped by retention")
+
+type ChangeFeedOptions struct {
+	// MaxEvents is the number of changes kept, unlimited when zero
+	MaxEvents int
+	// MaxAge is how long changes are kept, forever when zero
+	MaxAge time.Duration
+	// CompactEvery runs CompactChanges after that many changes, never when zero
+	CompactEvery int
+}
+
+// WithChangeFeed records every create, update, delete and metadata change in an append-only log under sys/changes,
+// numbered with increasing sequence numbers. Writes made without it are not recorded
+func WithChangeFeed(options ChangeFeedOptions) Option {
+	return func(s *SIS) {
+		s.feed = &changeFeed{options: options}
+	}
+}
+
+// a changeFeed is the in-memory state of the change log, shared by copies of a SIS. Sequence
+// numbers and counters are guarded by the write lock of the SIS
+type changeFeed struct {
+	options ChangeFeedOptions
+	// nextSeq is the sequence number of the next change, firstSeq the lowest one that may exist
+	nextSeq  uint64
+	firstSeq uint64
+	count    int
+	// sinceCompaction counts the changes recorded since the last compaction
+	sinceCompaction int
+	// uncommitted holds the changes of finished mutations that could not be written to the log yet
+	uncommitted []Change
+
+	notifyMu sync.Mutex
+	// notify is closed and replaced whenever a change is recorded
+	notify chan struct{}
+}
+
+func changePk(seq uint64) pk.PK {
+	return constants.SystemChangesSpace.Suffix(pk.PK{fmt.Sprintf("%020d", seq)})
+}
+
+func pendingChangePk(seq uint64) pk.PK {
+	return constants.SystemChangesPending.Suffix(pk.PK{fmt.Sprintf("%020d", seq)})
+}
+
+// a Subscription delivers changes as they are recorded. A consumer slower than the writers only
+// falls behind, reading the older changes back from the log, and never blocks them
+type Subscription struct {
+	C    <-chan Change
+	done chan struct{}
+	err  error
+}
+
+// Err blocks until C is closed and tells why: nil when the context was cancelled, ErrChangesDropped
+// when the subscriber fell behind retention, or a read error
+func (sub *Subscription) Err() error {
+	<-sub.done
+	return sub.err
+}
+
+// Changes iterates over the changes recorded after sinceSeq, in order. Iteration stops on the
+// first error, which is ctx.Err() when ctx is done
+func (s *SIS) Changes(ctx context.Context, sinceSeq uint64) iter.Seq2[Change, error] {
+	return func(yield func(Change, error) bool) {
+
+		s.mu.RLock()
+		seqs, truncated, err := s.changeSeqs(ctx)
+		s.mu.RUnlock()
+		if err != nil {
+			yield(Change{}, err)
+			return
+		}
+		if sinceSeq+1 < truncated {
+			yield(Change{}, fmt.Errorf("changes from %d to %d: %w", sinceSeq+1, truncated-1, ErrChangesDropped))
+			return
+		}
+
+		for _, seq := range seqs {
+			if seq <= sinceSeq {
+				continue
+			}
+			if ctx.Err() != nil {
+				yield(Change{}, ctx.Err())
+				return
+			}
+
+			s.mu.RLock()
+			change, found, err := s.readChange(ctx, seq)
+			s.mu.RUnlock()
+			if err != nil {
+				yield(Change{}, err)
+				return
+			}
+			if !found {
+				// removed by retention or compaction since the listing
+				continue
+			}
+			if !yield(change, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Subscribe delivers every change after sinceSeq on a channel holding up to buffer changes, until
+// ctx is done. The store must have been opened WithChangeFeed
+func (s *SIS) Subscribe(ctx context.Context, sinceSeq uint64, buffer int) (*Subscription, error) {
+	if s.feed == nil {
+		return nil, fmt.Errorf("change feed is not enabled")
+	}
+
+	changes := make(chan Change, buffer)
+	sub := &Subscription{C: changes, done: make(chan struct{})}
+
+	go func() {
+		defer close(sub.done)
+		defer close(changes)
+
+		seq := sinceSeq
+		for {
+			// taken before reading, so that a change recorded meanwhile is not missed
+			notify := s.feed.wait()
+
+			for change, err := range s.Changes(ctx, seq) {
+				if err != nil {
+					if ctx.Err() == nil {
+						sub.err = err
+					}
+					return
+				}
+				select {
+				case changes <- change:
+					seq = change.Seq
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-notify:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return sub, nil
+}
+
+// CompactChanges removes every change followed by a later change of the same key, so that the log
+// keeps the latest state of each key. It returns the number of changes removed
+func (s *SIS) CompactChanges(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.compactChanges(ctx)
+}
+
+func (f *changeFeed) wait() <-chan struct{} {
+	f.notifyMu.Lock()
+	defer f.notifyMu.Unlock()
+
+	if f.notify == nil {
+		f.notify = make(chan struct{})
+	}
+	return f.notify
+}
+
+func (f *changeFeed) broadcast() {
+	f.notifyMu.Lock()
+	defer f.notifyMu.Unlock()
+
+	if f.notify != nil {
+		close(f.notify)
+		f.notify = nil
+	}
+}
+
+// loadFeed restores the feed state from the log, continuing its numbering
+func (s SIS) loadFeed(ctx context.Context) error {
+
+	seqs, truncated, err := s.changeSeqs(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.feed.count = len(seqs)
+	s.feed.firstSeq = max(truncated, 1)
+	s.feed.nextSeq = s.feed.firstSeq
+	if len(seqs) > 0 {
+		s.feed.firstSeq = seqs[0]
+		s.feed.nextSeq = seqs[len(seqs)-1] + 1
+	}
+
+	return nil
+}
+
+// beginChange numbers change and writes it under sys/changes/pending before its mutation starts,
+// if the feed is enabled. A crash before the change is committed is settled by recoverChanges.
+// Callers must hold the write lock
+func (s SIS) beginChange(ctx context.Context, change Change) (Change, error) {
+	if s.feed == nil {
+		return change, nil
+	}
+
+	change.Seq = s.feed.nextSeq
+	change.Time = time.Now().UTC()
+
+	err := s.writeJSON(ctx, pendingChangePk(change.Seq), change)
+	if err != nil {
+		return change, fmt.Errorf("error writing pending change: %w", err)
+	}
+	s.feed.nextSeq++
+
+	return change, nil
+}
+
+// abortChange drops the pending change of a mutation that failed
+func (s SIS) abortChange(ctx context.Context, change Change) error {
+	if s.feed == nil {
+		return nil
+	}
+
+	err := s.crud.Delete(context.WithoutCancel(ctx), pendingChangePk(change.Seq))
+	if err != nil {
+		return fmt.Errorf("error deleting pending change %d: %w", change.Seq, err)
+	}
+	return nil
+}
+
+// commitChange appends the change of a finished mutation to the log. The mutation already
+// happened, so a failure here is not returned: the change stays pending and is written again by
+// the next commit, or by recoverChanges when the store is opened
+func (s SIS) commitChange(ctx context.Context, change Change) {
+	if s.feed == nil {
+		return
+	}
+
+	var failed []Change
+	for _, pending := range append(s.feed.uncommitted, change) {
+		err := s.writeChange(ctx, pending)
+		if err != nil {
+			failed = append(failed, pending)
+			continue
+		}
+		s.feed.count++
+		s.feed.sinceCompaction++
+	}
+	s.feed.uncommitted = failed
+	s.feed.broadcast()
+
+	// compaction and retention are run again by the next commit if they fail
+	if s.feed.options.CompactEvery > 0 && s.feed.sinceCompaction >= s.feed.options.CompactEvery {
+		_, err := s.compactChanges(ctx)
+		if err != nil {
+			return
+		}
+	}
+	_ = s.applyChangeRetention(ctx)
+}
+
+// writeChange moves a pending change to the log. A zero size is filled from the new blob
+func (s SIS) writeChange(ctx context.Context, change Change) error {
+
+	if change.Size == 0 && change.NewDigest != "" {
+		size, err := s.crud.SizeOf(ctx, blobPk(change.NewDigest))
+		if err != nil {
+			return fmt.Errorf("error on blob s.crud.SizeOf: %w", err)
+		}
+		change.Size = size
+	}
+
+	err := s.writeJSON(ctx, changePk(change.Seq), change)
+	if err != nil {
+		return fmt.Errorf("error writing change: %w", err)
+	}
+
+	err = s.crud.Delete(ctx, pendingChangePk(change.Seq))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("error deleting pending change %d: %w", change.Seq, err)
+	}
+
+	return nil
+}
+
+// recoverChanges settles the changes left pending by a crash: the change of a mutation that
+// reached the store is written to the log, the others are dropped. It runs before loadFeed, so
+// that the numbering continues after the changes kept
+func (s SIS) recoverChanges(ctx context.Context) error {
+
+	entries, err := s.crud.List(ctx, constants.SystemChangesPending)
+	if err != nil {
+		return fmt.Errorf("error listing pending changes: %w", err)
+	}
+
+	for _, entry := range entries {
+		seq, err := strconv.ParseUint(entry.Name, 10, 64)
+		if err != nil || entry.IsDir {
+			continue
+		}
+
+		var change Change
+		err = s.readJSON(ctx, pendingChangePk(seq), &change)
+		if err != nil {
+			return fmt.Errorf("error reading pending change %d: %w", seq, err)
+		}
+
+		applied, err := s.changeApplied(ctx, change)
+		if err != nil {
+			return fmt.Errorf("error on s.changeApplied: %w", err)
+		}
+
+		if applied {
+			err = s.writeChange(ctx, change)
+		} else {
+			err = s.crud.Delete(ctx, pendingChangePk(seq))
+		}
+		if err != nil {
+			return fmt.Errorf("error settling pending change %d: %w", seq, err)
+		}
+	}
+
+	return nil
+}
+
+// changeApplied reports whether the store holds the state change leads to. Only the last
+// mutation of a key can be pending, so that state was not reached otherwise
+func (s SIS) changeApplied(ctx context.Context, change Change) (bool, error) {
+
+	pkExists, err := s.pkExists(ctx, change.PK)
+	if err != nil {
+		return false, fmt.Errorf("error on s.pkExists: %w", err)
+	}
+	if change.Kind == ChangeDelete || !pkExists {
+		return change.Kind == ChangeDelete && !pkExists, nil
+	}
+
+	header, err := s.readDataHeader(ctx, change.PK)
+	if err != nil {
+		return false, fmt.Errorf("error on data header read: %w", err)
+	}
+	digest, err := s.resolveDigest(ctx, header.Digest)
+	if err != nil {
+		return false, fmt.Errorf("error on s.resolveDigest: %w", err)
+	}
+	if digest != change.NewDigest {
+		return false, nil
+	}
+
+	return s.digestExists(ctx, digest)
+}
+
+// applyChangeRetention drops the oldest changes beyond MaxEvents or older than MaxAge, and records
+// the first sequence number kept so that readers can tell they missed changes
+func (s SIS) applyChangeRetention(ctx context.Context) error {
+	options := s.feed.options
+	if options.MaxEvents <= 0 && options.MaxAge <= 0 {
+		return nil
+	}
+
+	dropped := false
+	for s.feed.count > 0 {
+		oldest, found, err := s.readChange(ctx, s.feed.firstSeq)
+		if err != nil {
+			return err
+		}
+		if !found {
+			// a hole left by compaction
+			s.feed.firstSeq++
+			continue
+		}
+
+		tooMany := options.MaxEvents > 0 && s.feed.count > options.MaxEvents
+		tooOld := options.MaxAge > 0 && time.Since(oldest.Time) > options.MaxAge
+		if !tooMany && !tooOld {
+			break
+		}
+
+		err = s.crud.Delete(ctx, changePk(oldest.Seq))
+		if err != nil {
+			return fmt.Errorf("error deleting change %d: %w", oldest.Seq, err)
+		}
+		s.feed.count--
+		s.feed.firstSeq++
+		dropped = true
+	}
+
+	if !dropped {
+		return nil
+	}
+	return s.writeFile(ctx, constants.SystemChangesTruncated, []byte(strconv.FormatUint(s.feed.firstSeq, 10)))
+}
+
+func (s SIS) compactChanges(ctx context.Context) (int, error) {
+
+	seqs, _, err := s.changeSeqs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	latest := make(map[string]uint64)
+	for _, seq := range seqs {
+		change, found, err := s.readChange(ctx, seq)
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			latest[change.PK.Path()] = seq
+		}
+	}
+
+	kept := make(map[uint64]bool, len(latest))
+	for seq := range maps.Values(latest) {
+		kept[seq] = true
+	}
+
+	removed := 0
+	for _, seq := range seqs {
+		if kept[seq] {
+			continue
+		}
+		err = s.crud.Delete(ctx, changePk(seq))
+		if err != nil {
+			return removed, fmt.Errorf("error deleting change %d: %w", seq, err)
+		}
+		removed++
+	}
+
+	if s.feed != nil {
+		s.feed.count -= removed
+		s.feed.sinceCompaction = 0
+	}
+	return removed, nil
+}
+
+// changeSeqs lists the sequence numbers in the log, sorted, along with the first one not dropped
+// by retention
+func (s SIS) changeSeqs(ctx context.Context) ([]uint64, uint64, error) {
+
+	entries, err := s.crud.List(ctx, constants.SystemChangesSpace)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error listing changes: %w", err)
+	}
+
+	var seqs []uint64
+	for _, entry := range entries {
+		seq, err := strconv.ParseUint(entry.Name, 10, 64)
+		if err != nil || entry.IsDir {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	slices.Sort(seqs)
+
+	var truncated uint64
+	exists, err := s.crud.Exists(ctx, constants.SystemChangesTruncated)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error checking truncation mark: %w", err)
+	}
+	if exists {
+		mark, err := s.crud.Read(ctx, constants.SystemChangesTruncated)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error reading truncation mark: %w", err)
+		}
+		truncated, err = strconv.ParseUint(string(mark), 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error parsing truncation mark: %w", err)
+		}
+	}
+
+	return seqs, truncated, nil
+}
+
+func (s SIS) readChange(ctx context.Context, seq uint64) (Change, bool, error) {
+
+	exists, err := s.crud.Exists(ctx, changePk(seq))
+	if err != nil {
+		return Change{}, false, fmt.Errorf("error checking change %d: %w", seq, err)
+	}
+	if !exists {
+		return Change{}, false, nil
+	}
+
+	var change Change
+	err = s.readJSON(ctx, changePk(seq), &change)
+	if err != nil {
+		return Change{}, false, fmt.Errorf("error reading change %d: %w", seq, err)
+	}
+	return change, true, nil
+}
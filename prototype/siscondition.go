@@ -0,0 +1,105 @@
+package sis
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sis/internal/crud"
+	"sis/internal/pk"
+)
+
+// a Condition decides whether a conditional write goes ahead, given the current digest of its key.
+// exists is false for keys that do not exist. It is checked under the write lock, right before the
+// write, so that concurrent conditional writers cannot both pass
+type Condition func(digest string, exists bool) bool
+
+// IfDigest holds when the key exists with digest, for compare-and-swap
+func IfDigest(digest string) Condition {
+	return func(current string, exists bool) bool {
+		return exists && current == digest
+	}
+}
+
+// IfAbsent holds when the key does not exist
+func IfAbsent() Condition {
+	return func(current string, exists bool) bool {
+		return !exists
+	}
+}
+
+// PutIf creates key, or replaces it if it exists, with the blob read from r, provided cond holds.
+// It reports whether key was created, and fails with ErrPreconditionFailed when cond does not hold
+func (s *SIS) PutIf(ctx context.Context, key pk.PK, r io.Reader, cond Condition) (bool, error) {
+	created := false
+	put := func(ctx context.Context, key pk.PK, digest string, persistBlob func() error) error {
+		current, exists, err := s.currentDigest(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !cond(current, exists) {
+			return &KeyError{Op: "put", Key: key, Err: ErrPreconditionFailed}
+		}
+		if exists {
+			return s.update(ctx, key, digest, persistBlob)
+		}
+		created = true
+		return s.create(ctx, key, digest, persistBlob)
+	}
+
+	streamer, ok := s.crud.(crud.Streamer)
+	if ok {
+		err := s.publishStaged(ctx, streamer, r, put, key)
+		return created, err
+	}
+
+	blob, err := io.ReadAll(r)
+	if err != nil {
+		return false, fmt.Errorf("error reading blob: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	digest := s.digest(blob)
+	err = put(ctx, key, digest, func() error {
+		return s.persistBlob(ctx, digest, blob)
+	})
+	return created, err
+}
+
+// DeleteIf deletes key provided cond holds, failing with ErrPreconditionFailed otherwise
+func (s *SIS) DeleteIf(ctx context.Context, key pk.PK, cond Condition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, exists, err := s.currentDigest(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !cond(current, exists) {
+		return &KeyError{Op: "delete", Key: key, Err: ErrPreconditionFailed}
+	}
+
+	return s.delete(ctx, key)
+}
+
+// currentDigest returns the digest key points at, if it exists
+func (s SIS) currentDigest(ctx context.Context, key pk.PK) (string, bool, error) {
+
+	exists, err := s.pkExists(ctx, key)
+	if err != nil || !exists {
+		return "", false, err
+	}
+
+	header, err := s.readDataHeader(ctx, key)
+	if err != nil {
+		return "", false, fmt.Errorf("error on data header read: %w", err)
+	}
+
+	digest, err := s.resolveDigest(ctx, header.Digest)
+	if err != nil {
+		return "", false, fmt.Errorf("error on s.resolveDigest: %w", err)
+	}
+
+	return digest, true, nil
+}
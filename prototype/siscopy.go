@@ -0,0 +1,126 @@
+package sis
+
+import (
+	"context"
+	"fmt"
+	"sis/internal/pk"
+	"slices"
+)
+
+// Copy makes dst point at the contents and metadata of src. Only a header and a reference are
+// written, the blob is shared
+func (s *SIS) Copy(ctx context.Context, src, dst pk.PK) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ops, err := s.relinkOps(ctx, []pk.PK{src}, []pk.PK{dst}, false)
+	if err != nil {
+		return err
+	}
+	return s.runIntent(ctx, ops)
+}
+
+// Move is Copy followed by the deletion of src, both done or redone after a crash
+func (s *SIS) Move(ctx context.Context, src, dst pk.PK) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ops, err := s.relinkOps(ctx, []pk.PK{src}, []pk.PK{dst}, true)
+	if err != nil {
+		return err
+	}
+	return s.runIntent(ctx, ops)
+}
+
+// CopyPrefix copies every key under src to the same path under dst, as a whole. It returns the
+// number of keys copied
+func (s *SIS) CopyPrefix(ctx context.Context, src, dst pk.PK) (int, error) {
+	return s.relinkPrefix(ctx, src, dst, false)
+}
+
+// MovePrefix moves every key under src to the same path under dst, as a whole. It returns the
+// number of keys moved
+func (s *SIS) MovePrefix(ctx context.Context, src, dst pk.PK) (int, error) {
+	return s.relinkPrefix(ctx, src, dst, true)
+}
+
+func (s *SIS) relinkPrefix(ctx context.Context, src, dst pk.PK, move bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if isPrefixOf(src, dst) || isPrefixOf(dst, src) {
+		return 0, fmt.Errorf("prefixes '%s' and '%s' overlap: %w", src.Path(), dst.Path(), ErrConflict)
+	}
+
+	var srcKeys, dstKeys []pk.PK
+	err := s.walkKeys(ctx, src, func(key pk.PK) error {
+		srcKeys = append(srcKeys, key)
+		dstKeys = append(dstKeys, dst.Suffix(key[len(src):]))
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error on s.walkKeys: %w", err)
+	}
+
+	ops, err := s.relinkOps(ctx, srcKeys, dstKeys, move)
+	if err != nil {
+		return 0, err
+	}
+	err = s.runIntent(ctx, ops)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(srcKeys), nil
+}
+
+// relinkOps builds the intent of copying or moving every srcKeys[i] to dstKeys[i]. All puts come
+// before the deletes, so that a blob is always held by some key
+func (s SIS) relinkOps(ctx context.Context, srcKeys, dstKeys []pk.PK, move bool) ([]intentOp, error) {
+
+	var puts, deletes []intentOp
+	for i, src := range srcKeys {
+		dst := dstKeys[i]
+
+		// checked before the intent is written, since a journaled put that cannot apply is replayed forever
+		err := checkKey("copy", dst)
+		if err != nil {
+			return nil, err
+		}
+
+		exists, err := s.pkExists(ctx, src)
+		if err != nil {
+			return nil, fmt.Errorf("error on s.pkExists: %w", err)
+		}
+		if !exists {
+			return nil, &KeyError{Op: "copy", Key: src, Err: ErrNotFound}
+		}
+		exists, err = s.pkExists(ctx, dst)
+		if err != nil {
+			return nil, fmt.Errorf("error on s.pkExists: %w", err)
+		}
+		if exists {
+			return nil, &KeyError{Op: "copy", Key: dst, Err: ErrAlreadyExists}
+		}
+
+		header, err := s.readDataHeader(ctx, src)
+		if err != nil {
+			return nil, fmt.Errorf("error on data header read: %w", err)
+		}
+		digest, err := s.resolveDigest(ctx, header.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("error on s.resolveDigest: %w", err)
+		}
+
+		puts = append(puts, intentOp{Kind: intentPut, PK: dst, Digest: digest, Metadata: header.Metadata})
+		if move {
+			deletes = append(deletes, intentOp{Kind: intentDelete, PK: src})
+		}
+	}
+
+	return slices.Concat(puts, deletes), nil
+}
+
+func isPrefixOf(prefix, key pk.PK) bool {
+	return len(prefix) <= len(key) && slices.Equal(prefix, key[:len(prefix)])
+}
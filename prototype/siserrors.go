@@ -0,0 +1,40 @@
+package sis
+
+import (
+	"errors"
+	"fmt"
+	"sis/internal/crud"
+	"sis/internal/pk"
+)
+
+// errors returned by SIS and its backends, to be checked with errors.Is. Failures on a single key
+// are *KeyError values wrapping one of them
+var (
+	ErrNotFound      = crud.ErrNotFound
+	ErrAlreadyExists = crud.ErrAlreadyExists
+	ErrCorrupted     = crud.ErrCorrupted
+	ErrInvalidKey    = crud.ErrInvalidKey
+	ErrConflict      = crud.ErrConflict
+	ErrReadOnly      = crud.ErrReadOnly
+	// ErrPreconditionFailed is returned by conditional writes whose Condition does not hold
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+type KeyError = crud.KeyError
+
+// checkKey rejects keys that cannot name a user key, see pk.Parse
+func checkKey(op string, key pk.PK) error {
+	if err := key.Validate(); err != nil {
+		return &KeyError{Op: op, Key: key, Err: err}
+	}
+	return nil
+}
+
+// missingAsCorrupted reports a system file missing under an existing key as corruption, so that
+// callers do not take it for a missing key
+func missingAsCorrupted(err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+	return err
+}
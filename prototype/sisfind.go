@@ -0,0 +1,96 @@
+package sis
+
+import (
+	"context"
+	"fmt"
+	"sis/internal/constants"
+	"sis/internal/pk"
+	"slices"
+)
+
+// Find returns the info of every key matching pattern, see pk.Glob, sorted by key. Only the
+// directories that can hold a match are listed: literal segments are followed without listing,
+// and '**' is the only segment that walks a whole subtree
+func (s *SIS) Find(ctx context.Context, pattern string) ([]ObjectInfo, error) {
+	glob, err := pk.ParseGlob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("error on pk.ParseGlob: %w", err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []pk.PK
+	seen := map[string]bool{}
+	err = s.findKeys(ctx, glob, 0, pk.PK{}, func(key pk.PK) error {
+		// keys reachable through more than one '**' are found more than once
+		if !seen[key.String()] {
+			seen[key.String()] = true
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error on s.findKeys: %w", err)
+	}
+	slices.SortFunc(keys, slices.Compare)
+
+	infos := make([]ObjectInfo, len(keys))
+	for i, key := range keys {
+		infos[i], err = s.stat(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("error on s.stat: %w", err)
+		}
+	}
+
+	return infos, nil
+}
+
+// findKeys calls fn with the keys under dir matching the segments of glob from i on
+func (s SIS) findKeys(ctx context.Context, glob pk.Glob, i int, dir pk.PK, fn func(key pk.PK) error) error {
+
+	literal := glob.Literal(i)
+	dir = dir.Suffix(literal)
+	i += len(literal)
+
+	if i == glob.Len() {
+		exists, err := s.crud.Exists(ctx, dataHeaderPk(dir))
+		if err != nil {
+			return fmt.Errorf("error on s.crud.Exists: %w", err)
+		}
+		if !exists {
+			return nil
+		}
+		return fn(slices.Clone(dir))
+	}
+
+	entries, err := s.crud.List(ctx, dir.Prefix(constants.UserDataSpace))
+	if err != nil {
+		return fmt.Errorf("error listing '%s': %w", dir.Path(), err)
+	}
+
+	if glob.IsRecursive(i) {
+		// '**' matching no segment
+		err = s.findKeys(ctx, glob, i+1, dir, fn)
+		if err != nil {
+			return err
+		}
+	}
+	for _, entry := range entries {
+		if !entry.IsDir {
+			continue
+		}
+		next := i + 1
+		if glob.IsRecursive(i) {
+			next = i
+		} else if !glob.MatchSegment(i, entry.Name) {
+			continue
+		}
+		err = s.findKeys(ctx, glob, next, dir.Suffix(pk.PK{entry.Name}), fn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package sis
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	sishash "sis/internal/hash"
+	"sync"
+)
+
+// a sharedHash is the hash of a SIS instance. Copies of the instance share it like mu, and it has
+// its own lock, so that blobs can be hashed without holding the store lock
+type sharedHash struct {
+	mu sync.Mutex
+	h  hash.Hash
+	// generation changes whenever h is switched, so that digests computed before can be told apart
+	generation int
+}
+
+// sum hashes everything read from r, returning the digest and the generation of the hash used
+func (sh *sharedHash) sum(r io.Reader) (string, int, error) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	sh.h.Reset()
+	defer sh.h.Reset()
+	_, err := io.Copy(sh.h, r)
+	if err != nil {
+		return "", 0, err
+	}
+	return fmt.Sprintf("%x", sh.h.Sum(nil)), sh.generation, nil
+}
+
+func (sh *sharedHash) probe() string {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sishash.Probe(sh.h)
+}
+
+func (sh *sharedHash) info() HashInfo {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return newHashInfo(sh.h)
+}
+
+func (sh *sharedHash) currentGeneration() int {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.generation
+}
+
+// swap makes h the hash of every copy of the instance
+func (sh *sharedHash) swap(h hash.Hash) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.h = h
+	sh.generation++
+}
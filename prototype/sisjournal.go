@@ -0,0 +1,156 @@
+package sis
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"reflect"
+	"sis/internal/constants"
+	"sis/internal/pk"
+	"time"
+)
+
+// multi-key operations are written to sys/journal as an intent before being applied, and the intent
+// is deleted once they are. New applies the intents left by a crash again, so that the operations
+// complete as a whole. Applying an op twice has the same effect as applying it once
+
+type intentOpKind string
+
+const (
+	// intentPut points a key at a digest the store already holds, creating the key if needed
+	intentPut intentOpKind = "put"
+	// intentDelete deletes a key if it exists
+	intentDelete intentOpKind = "delete"
+)
+
+type intentOp struct {
+	Kind     intentOpKind   `json:"kind"`
+	PK       pk.PK          `json:"pk"`
+	Digest   string         `json:"digest,omitempty"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+type intent struct {
+	ID  string     `json:"id"`
+	Ops []intentOp `json:"ops"`
+}
+
+func intentPk(id string) pk.PK {
+	return constants.SystemJournalSpace.Suffix(pk.PK{id})
+}
+
+// runIntent journals ops, applies them in order and drops the intent. Callers must hold the write
+// lock, and every digest put must be held by the store until the intent is applied
+func (s SIS) runIntent(ctx context.Context, ops []intentOp) error {
+
+	idBytes := make([]byte, 8)
+	_, err := rand.Read(idBytes)
+	if err != nil {
+		return fmt.Errorf("error generating intent id: %w", err)
+	}
+	// ids sort in creation order, so that recovery replays intents in the order they were made
+	in := intent{
+		ID:  fmt.Sprintf("%020d-%x", time.Now().UnixNano(), idBytes),
+		Ops: ops,
+	}
+
+	err = s.writeJSON(ctx, intentPk(in.ID), in)
+	if err != nil {
+		return fmt.Errorf("error writing intent: %w", err)
+	}
+
+	// a journaled intent is applied to the end even if ctx is done, so readers never see it halfway
+	return s.applyIntent(context.WithoutCancel(ctx), in)
+}
+
+func (s SIS) applyIntent(ctx context.Context, in intent) error {
+
+	for _, op := range in.Ops {
+		var err error
+		switch op.Kind {
+		case intentPut:
+			err = s.applyPut(ctx, op)
+		case intentDelete:
+			err = s.applyDelete(ctx, op)
+		default:
+			err = fmt.Errorf("unknown intent op '%s'", op.Kind)
+		}
+		if err != nil {
+			return fmt.Errorf("error applying %s of '%s': %w", op.Kind, op.PK.Path(), err)
+		}
+	}
+
+	err := s.crud.Delete(ctx, intentPk(in.ID))
+	if err != nil {
+		return fmt.Errorf("error deleting intent: %w", err)
+	}
+
+	return nil
+}
+
+func (s SIS) applyPut(ctx context.Context, op intentOp) error {
+
+	// puts only reuse blobs, which the intent keeps from being deleted
+	blobHeld := func() error {
+		return fmt.Errorf("blob '%s' is missing", op.Digest)
+	}
+
+	exists, err := s.pkExists(ctx, op.PK)
+	if err != nil {
+		return fmt.Errorf("error on s.pkExists: %w", err)
+	}
+	if exists {
+		err = s.update(ctx, op.PK, op.Digest, blobHeld)
+	} else {
+		err = s.create(ctx, op.PK, op.Digest, blobHeld)
+	}
+	if err != nil {
+		return err
+	}
+
+	header, err := s.readDataHeader(ctx, op.PK)
+	if err != nil {
+		return fmt.Errorf("error on data header read: %w", err)
+	}
+	if reflect.DeepEqual(header.Metadata, op.Metadata) {
+		return nil
+	}
+	return s.updateMetadata(ctx, header, op.Metadata)
+}
+
+func (s SIS) applyDelete(ctx context.Context, op intentOp) error {
+	exists, err := s.pkExists(ctx, op.PK)
+	if err != nil {
+		return fmt.Errorf("error on s.pkExists: %w", err)
+	}
+	if !exists {
+		return nil
+	}
+	return s.delete(ctx, op.PK)
+}
+
+// recoverIntents applies again the intents left by an interrupted operation
+func (s SIS) recoverIntents(ctx context.Context) error {
+
+	entries, err := s.crud.List(ctx, constants.SystemJournalSpace)
+	if err != nil {
+		return fmt.Errorf("error listing journal: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir {
+			continue
+		}
+		var in intent
+		err = s.readJSON(ctx, intentPk(entry.Name), &in)
+		if err != nil {
+			return fmt.Errorf("error reading intent '%s': %w", entry.Name, err)
+		}
+		err = s.applyIntent(ctx, in)
+		if err != nil {
+			return fmt.Errorf("error recovering intent '%s': %w", entry.Name, err)
+		}
+	}
+
+	return nil
+}
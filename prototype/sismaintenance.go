@@ -0,0 +1,464 @@
+package sis
+
+import (
+	"context"
+	"fmt"
+	"sis/internal/metrics"
+	"sis/internal/pk"
+	"slices"
+	"strings"
+)
+
+// Usage compares how much data the user namespace holds with how much is actually stored
+type Usage struct {
+	Keys  int `json:"keys"`
+	Blobs int `json:"blobs"`
+	// LogicalBytes is what the keys would take without deduplication
+	LogicalBytes metrics.Byte `json:"logicalBytes"`
+	// BlobBytes is the space taken by unique blobs
+	BlobBytes metrics.Byte `json:"blobBytes"`
+	// MetadataBytes is the space taken by blob metadata files
+	MetadataBytes metrics.Byte `json:"metadataBytes"`
+	// HeaderBytes is the space taken by data headers
+	HeaderBytes metrics.Byte `json:"headerBytes"`
+}
+
+// PhysicalBytes is everything SIS wrote to hold the user data
+func (u Usage) PhysicalBytes() metrics.Byte {
+	return u.BlobBytes + u.MetadataBytes + u.HeaderBytes
+}
+
+// DedupRatio is how many logical bytes each stored blob byte serves
+func (u Usage) DedupRatio() float64 {
+	if u.BlobBytes == 0 {
+		return 0
+	}
+	return float64(u.LogicalBytes) / float64(u.BlobBytes)
+}
+
+// ProblemKind classifies the inconsistencies found by Check
+type ProblemKind string
+
+const (
+	// a header points at a digest with no blob
+	MissingBlob ProblemKind = "missing-blob"
+	// a header is not listed on its digest metadata
+	MissingRef ProblemKind = "missing-ref"
+	// a digest metadata lists a key whose header does not point at it, or a ref to a deleted snapshot or version
+	DanglingRef ProblemKind = "dangling-ref"
+	// a blob is referenced by no key, snapshot or version
+	OrphanBlob ProblemKind = "orphan-blob"
+	// a blob or its metadata is missing from a digest directory
+	IncompleteDigest ProblemKind = "incomplete-digest"
+	// a blob no longer hashes to its digest
+	CorruptedBlob ProblemKind = "corrupted-blob"
+)
+
+type Problem struct {
+	Kind   ProblemKind `json:"kind"`
+	PK     pk.PK       `json:"pk,omitempty"`
+	Digest string      `json:"digest,omitempty"`
+	// Ref is set for dangling refs that do not name a key
+	Ref string `json:"ref,omitempty"`
+}
+
+func (p Problem) String() string {
+	if p.Ref != "" {
+		return fmt.Sprintf("%s: ref '%s' digest '%s'", p.Kind, p.Ref, p.Digest)
+	}
+	if p.PK != nil {
+		return fmt.Sprintf("%s: pk '%s' digest '%s'", p.Kind, p.PK.Path(), p.Digest)
+	}
+	return fmt.Sprintf("%s: digest '%s'", p.Kind, p.Digest)
+}
+
+// GCResult reports what GC repaired and removed
+type GCResult struct {
+	RestoredRefs int `json:"restoredRefs"`
+	// RemovedSnapshots counts the snapshots left incomplete by an interruption
+	RemovedSnapshots int          `json:"removedSnapshots"`
+	RemovedRefs      int          `json:"removedRefs"`
+	RemovedBlobs     int          `json:"removedBlobs"`
+	FreedBytes       metrics.Byte `json:"freedBytes"`
+}
+
+// Usage walks the whole store to measure logical and physical sizes
+func (s *SIS) Usage(ctx context.Context) (Usage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var usage Usage
+
+	digests, err := s.listDigests(ctx)
+	if err != nil {
+		return Usage{}, fmt.Errorf("error on s.listDigests: %w", err)
+	}
+
+	for _, digest := range digests {
+		complete, err := s.digestComplete(ctx, digest)
+		if err != nil {
+			return Usage{}, fmt.Errorf("error on s.digestComplete: %w", err)
+		}
+		if !complete {
+			continue
+		}
+
+		blobSize, err := s.crud.SizeOf(ctx, blobPk(digest))
+		if err != nil {
+			return Usage{}, fmt.Errorf("error measuring blob '%s': %w", digest, err)
+		}
+		metadataSize, err := s.crud.SizeOf(ctx, metadataPk(digest))
+		if err != nil {
+			return Usage{}, fmt.Errorf("error measuring metadata '%s': %w", digest, err)
+		}
+		metadata, err := s.readBlobMetadata(ctx, digest)
+		if err != nil {
+			return Usage{}, fmt.Errorf("error on blob metadata read: %w", err)
+		}
+
+		usage.Blobs++
+		usage.BlobBytes += blobSize
+		usage.MetadataBytes += metadataSize
+		usage.LogicalBytes += blobSize * metrics.Byte(len(metadata.PkList))
+	}
+
+	err = s.walkKeys(ctx, pk.PK{}, func(key pk.PK) error {
+		headerSize, err := s.crud.SizeOf(ctx, dataHeaderPk(key))
+		if err != nil {
+			return fmt.Errorf("error measuring header of '%s': %w", key.Path(), err)
+		}
+		usage.Keys++
+		usage.HeaderBytes += headerSize
+		return nil
+	})
+	if err != nil {
+		return Usage{}, fmt.Errorf("error on s.walkKeys: %w", err)
+	}
+
+	return usage, nil
+}
+
+// Check verifies that headers, digest metadata and blobs agree with each other, and that every
+// blob still hashes to its digest. It only reports, GC repairs what can be repaired
+func (s *SIS) Check(ctx context.Context) ([]Problem, error) {
+	// hashing blobs needs the shared hash, so Check excludes writers like they exclude each other
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var problems []Problem
+
+	// during a rehash, the digests not migrated yet were made with the hash of the manifest
+	pending, oldHash, err := s.pendingRehash(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error on s.pendingRehash: %w", err)
+	}
+
+	err = s.walkKeys(ctx, pk.PK{}, func(key pk.PK) error {
+		header, err := s.readDataHeader(ctx, key)
+		if err != nil {
+			return fmt.Errorf("error reading header of '%s': %w", key.Path(), err)
+		}
+		digest, err := s.resolveDigest(ctx, header.Digest)
+		if err != nil {
+			return fmt.Errorf("error on s.resolveDigest: %w", err)
+		}
+		complete, err := s.digestComplete(ctx, digest)
+		if err != nil {
+			return fmt.Errorf("error on s.digestComplete: %w", err)
+		}
+		if !complete {
+			problems = append(problems, Problem{Kind: MissingBlob, PK: key, Digest: digest})
+			return nil
+		}
+		metadata, err := s.readBlobMetadata(ctx, digest)
+		if err != nil {
+			return fmt.Errorf("error on blob metadata read: %w", err)
+		}
+		if !containsKey(metadata.PkList, key) {
+			problems = append(problems, Problem{Kind: MissingRef, PK: key, Digest: digest})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error on s.walkKeys: %w", err)
+	}
+
+	digests, err := s.listDigests(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error on s.listDigests: %w", err)
+	}
+
+	for _, digest := range digests {
+		complete, err := s.digestComplete(ctx, digest)
+		if err != nil {
+			return nil, fmt.Errorf("error on s.digestComplete: %w", err)
+		}
+		if !complete {
+			problems = append(problems, Problem{Kind: IncompleteDigest, Digest: digest})
+			continue
+		}
+
+		blob, err := s.readBlob(ctx, digest)
+		if err != nil {
+			return nil, fmt.Errorf("error on blob read: %w", err)
+		}
+		if !s.digestMatches(blob, digest, pending[digest], oldHash) {
+			problems = append(problems, Problem{Kind: CorruptedBlob, Digest: digest})
+		}
+
+		liveKeys, danglingKeys, err := s.splitDigestRefs(ctx, digest)
+		if err != nil {
+			return nil, fmt.Errorf("error on s.splitDigestRefs: %w", err)
+		}
+		for _, key := range danglingKeys {
+			problems = append(problems, Problem{Kind: DanglingRef, PK: key, Digest: digest})
+		}
+		liveRefs, danglingRefs, err := s.splitBlobRefs(ctx, digest)
+		if err != nil {
+			return nil, fmt.Errorf("error on s.splitBlobRefs: %w", err)
+		}
+		for _, ref := range danglingRefs {
+			problems = append(problems, Problem{Kind: DanglingRef, Digest: digest, Ref: ref})
+		}
+		if len(liveKeys) == 0 && len(liveRefs) == 0 {
+			problems = append(problems, Problem{Kind: OrphanBlob, Digest: digest})
+		}
+	}
+
+	return problems, nil
+}
+
+// GC restores references missing for existing headers, drops references to keys that no longer
+// point at a digest, and deletes the blobs left without references
+func (s *SIS) GC(ctx context.Context) (GCResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result GCResult
+
+	// headers are the source of truth, so their references are restored before anything is deleted
+	err := s.walkKeys(ctx, pk.PK{}, func(key pk.PK) error {
+		header, err := s.readDataHeader(ctx, key)
+		if err != nil {
+			return fmt.Errorf("error reading header of '%s': %w", key.Path(), err)
+		}
+		digest, err := s.resolveDigest(ctx, header.Digest)
+		if err != nil {
+			return fmt.Errorf("error on s.resolveDigest: %w", err)
+		}
+		complete, err := s.digestComplete(ctx, digest)
+		if err != nil {
+			return fmt.Errorf("error on s.digestComplete: %w", err)
+		}
+		if !complete {
+			return nil
+		}
+		metadata, err := s.readBlobMetadata(ctx, digest)
+		if err != nil {
+			return fmt.Errorf("error on blob metadata read: %w", err)
+		}
+		if containsKey(metadata.PkList, key) {
+			return nil
+		}
+		err = s.addKeyToDigestMetadata(ctx, digest, key)
+		if err != nil {
+			return fmt.Errorf("error on s.addKeyToDigestMetadata: %w", err)
+		}
+		result.RestoredRefs++
+		return nil
+	})
+	if err != nil {
+		return GCResult{}, fmt.Errorf("error on s.walkKeys: %w", err)
+	}
+
+	result.RemovedSnapshots, err = s.removeIncompleteSnapshots(ctx)
+	if err != nil {
+		return GCResult{}, fmt.Errorf("error on s.removeIncompleteSnapshots: %w", err)
+	}
+
+	digests, err := s.listDigests(ctx)
+	if err != nil {
+		return GCResult{}, fmt.Errorf("error on s.listDigests: %w", err)
+	}
+
+	for _, digest := range digests {
+		complete, err := s.digestComplete(ctx, digest)
+		if err != nil {
+			return GCResult{}, fmt.Errorf("error on s.digestComplete: %w", err)
+		}
+		if !complete {
+			// incomplete digests are left by interrupted creates. Nothing can be read through them
+			freed, err := s.deleteIncompleteDigest(ctx, digest)
+			if err != nil {
+				return GCResult{}, fmt.Errorf("error deleting incomplete digest '%s': %w", digest, err)
+			}
+			result.RemovedBlobs++
+			result.FreedBytes += freed
+			continue
+		}
+
+		liveKeys, danglingKeys, err := s.splitDigestRefs(ctx, digest)
+		if err != nil {
+			return GCResult{}, fmt.Errorf("error on s.splitDigestRefs: %w", err)
+		}
+		liveRefs, danglingRefs, err := s.splitBlobRefs(ctx, digest)
+		if err != nil {
+			return GCResult{}, fmt.Errorf("error on s.splitBlobRefs: %w", err)
+		}
+
+		if len(liveKeys) == 0 && len(liveRefs) == 0 {
+			size, err := s.crud.SizeOf(ctx, blobPk(digest))
+			if err != nil {
+				return GCResult{}, fmt.Errorf("error measuring blob '%s': %w", digest, err)
+			}
+			err = s.deleteBlob(ctx, digest)
+			if err != nil {
+				return GCResult{}, fmt.Errorf("error on blob deletion: %w", err)
+			}
+			err = s.deleteBlobMetadata(ctx, digest)
+			if err != nil {
+				return GCResult{}, fmt.Errorf("error on blob metadata deletion: %w", err)
+			}
+			result.RemovedRefs += len(danglingKeys) + len(danglingRefs)
+			result.RemovedBlobs++
+			result.FreedBytes += size
+			continue
+		}
+
+		if len(danglingKeys) > 0 || len(danglingRefs) > 0 {
+			metadata, err := s.readBlobMetadata(ctx, digest)
+			if err != nil {
+				return GCResult{}, fmt.Errorf("error on blob metadata read: %w", err)
+			}
+			metadata.PkList = liveKeys
+			metadata.Refs = liveRefs
+			err = s.updateBlobMetadata(ctx, digest, metadata)
+			if err != nil {
+				return GCResult{}, fmt.Errorf("error on blob metadata update: %w", err)
+			}
+			result.RemovedRefs += len(danglingKeys) + len(danglingRefs)
+		}
+	}
+
+	return result, nil
+}
+
+// digestComplete reports whether both the blob and the metadata of digest exist
+func (s SIS) digestComplete(ctx context.Context, digest string) (bool, error) {
+
+	blobExists, err := s.crud.Exists(ctx, blobPk(digest))
+	if err != nil {
+		return false, fmt.Errorf("error checking blob existence: %w", err)
+	}
+
+	metadataExists, err := s.crud.Exists(ctx, metadataPk(digest))
+	if err != nil {
+		return false, fmt.Errorf("error checking metadata existence: %w", err)
+	}
+
+	return blobExists && metadataExists, nil
+}
+
+// splitDigestRefs separates the keys listed on the metadata of digest whose header points at it
+// from those whose header is gone or points elsewhere
+func (s SIS) splitDigestRefs(ctx context.Context, digest string) (liveKeys, danglingKeys []pk.PK, err error) {
+
+	metadata, err := s.readBlobMetadata(ctx, digest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error on blob metadata read: %w", err)
+	}
+
+	liveKeys = make([]pk.PK, 0, len(metadata.PkList))
+	for _, key := range metadata.PkList {
+		exists, err := s.dataHeaderExists(ctx, key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error on s.dataHeaderExists: %w", err)
+		}
+		if !exists {
+			danglingKeys = append(danglingKeys, key)
+			continue
+		}
+		header, err := s.readDataHeader(ctx, key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading header of '%s': %w", key.Path(), err)
+		}
+		headerDigest, err := s.resolveDigest(ctx, header.Digest)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error on s.resolveDigest: %w", err)
+		}
+		if headerDigest != digest {
+			danglingKeys = append(danglingKeys, key)
+			continue
+		}
+		liveKeys = append(liveKeys, key)
+	}
+
+	return liveKeys, danglingKeys, nil
+}
+
+// splitBlobRefs separates the refs on the metadata of digest that still hold it from those whose
+// snapshot or version is gone
+func (s SIS) splitBlobRefs(ctx context.Context, digest string) (liveRefs, danglingRefs []string, err error) {
+
+	metadata, err := s.readBlobMetadata(ctx, digest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error on blob metadata read: %w", err)
+	}
+
+	for _, ref := range metadata.Refs {
+		live, err := s.refIsLive(ctx, ref)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error on s.refIsLive: %w", err)
+		}
+		if live {
+			liveRefs = append(liveRefs, ref)
+		} else {
+			danglingRefs = append(danglingRefs, ref)
+		}
+	}
+
+	return liveRefs, danglingRefs, nil
+}
+
+// refIsLive reports whether what ref stands for still exists. Unknown kinds of refs are kept
+func (s SIS) refIsLive(ctx context.Context, ref string) (bool, error) {
+	switch {
+	case strings.HasPrefix(ref, snapshotRefPrefix):
+		return s.snapshotRefIsLive(ctx, ref)
+	case strings.HasPrefix(ref, versionRefPrefix):
+		return s.versionRefIsLive(ctx, ref)
+	default:
+		return true, nil
+	}
+}
+
+func (s SIS) deleteIncompleteDigest(ctx context.Context, digest string) (metrics.Byte, error) {
+
+	var freed metrics.Byte
+	for _, key := range []pk.PK{blobPk(digest), metadataPk(digest)} {
+		exists, err := s.crud.Exists(ctx, key)
+		if err != nil {
+			return 0, fmt.Errorf("error on s.crud.Exists: %w", err)
+		}
+		if !exists {
+			continue
+		}
+		size, err := s.crud.SizeOf(ctx, key)
+		if err != nil {
+			return 0, fmt.Errorf("error on s.crud.SizeOf: %w", err)
+		}
+		err = s.crud.Delete(ctx, key)
+		if err != nil {
+			return 0, fmt.Errorf("error on s.crud.Delete: %w", err)
+		}
+		freed += size
+	}
+
+	return freed, nil
+}
+
+func containsKey(keys []pk.PK, key pk.PK) bool {
+	return slices.ContainsFunc(keys, func(listed pk.PK) bool {
+		return listed.Path() == key.Path()
+	})
+}
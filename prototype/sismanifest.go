@@ -0,0 +1,177 @@
+package sis
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"hash"
+	"sis/internal/constants"
+	sishash "sis/internal/hash"
+	"sis/internal/pk"
+	"time"
+)
+
+// FormatVersion is the on-disk layout written by this version of SIS. Stores with an older version
+// are upgraded on open through formatMigrations, stores with a newer one are refused
+const FormatVersion = 3
+
+// ChunkingNone stores every blob whole, which is the only chunking mode so far
+const ChunkingNone = "none"
+
+// a Manifest records how a store was laid out, so that it is never opened with incompatible settings.
+// It lives at sys/manifest
+type Manifest struct {
+	FormatVersion int      `json:"formatVersion"`
+	StoreID       string   `json:"storeId"`
+	Hash          HashInfo `json:"hash"`
+	// NextHash is set while a Rehasher is moving the store to another hash
+	NextHash  *HashInfo `json:"nextHash,omitempty"`
+	Chunking  string    `json:"chunking"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type HashInfo struct {
+	// Algorithm is the registered name of the hash, or empty for unregistered hashes
+	Algorithm string `json:"algorithm,omitempty"`
+	Size      int    `json:"size"`
+	// Probe is the digest of a fixed input, which identifies the hash even without a name
+	Probe string `json:"probe"`
+}
+
+// a formatMigration upgrades a store from one format version to the next
+type formatMigration func(s SIS, m *Manifest) error
+
+// formatMigrations[v] upgrades a store from version v to v+1
+var formatMigrations = []formatMigration{
+	// version 0 is the layout from before the manifest existed, which is otherwise unchanged
+	func(s SIS, m *Manifest) error { return nil },
+	// version 2 adds refs to blob metadata, which older versions would drop when rewriting it.
+	// Version 1 stores have none, so nothing changes on disk
+	func(s SIS, m *Manifest) error { return nil },
+	// version 3 writes the keys of headers and blob metadata as '/' joined strings, which older
+	// versions cannot read. Arrays are still read, so version 2 stores are left as they are
+	func(s SIS, m *Manifest) error { return nil },
+}
+
+func newHashInfo(h hash.Hash) HashInfo {
+	algorithm, _ := sishash.Identify(h)
+	return HashInfo{
+		Algorithm: algorithm,
+		Size:      h.Size(),
+		Probe:     sishash.Probe(h),
+	}
+}
+
+// Manifest returns the manifest of the store
+func (s *SIS) Manifest(ctx context.Context) (Manifest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.readManifest(ctx)
+}
+
+// open checks the manifest against h, writing it on first open and upgrading old formats
+func (s SIS) open(ctx context.Context) error {
+
+	exists, err := s.crud.Exists(ctx, constants.SystemManifest)
+	if err != nil {
+		return fmt.Errorf("error checking manifest existence: %w", err)
+	}
+
+	var manifest Manifest
+	if exists {
+		manifest, err = s.readManifest(ctx)
+		if err != nil {
+			return fmt.Errorf("error on s.readManifest: %w", err)
+		}
+	} else {
+		manifest, err = s.newManifest(ctx)
+		if err != nil {
+			return fmt.Errorf("error on s.newManifest: %w", err)
+		}
+	}
+
+	if manifest.FormatVersion > FormatVersion {
+		return fmt.Errorf("store format version %d is newer than the supported version %d", manifest.FormatVersion, FormatVersion)
+	}
+
+	if manifest.Chunking != ChunkingNone {
+		return fmt.Errorf("unsupported chunking mode '%s'", manifest.Chunking)
+	}
+
+	// during a migration only the new hash is accepted, so that no writer adds old digests
+	probe := s.h.probe()
+	if manifest.NextHash != nil && manifest.NextHash.Probe != probe {
+		return fmt.Errorf("store is being moved to hash '%s', which does not match the given hash", manifest.NextHash.Algorithm)
+	}
+	if manifest.NextHash == nil && manifest.Hash.Probe != probe {
+		return fmt.Errorf("store uses hash '%s', which does not match the given hash", manifest.Hash.Algorithm)
+	}
+
+	for manifest.FormatVersion < FormatVersion {
+		migrate := formatMigrations[manifest.FormatVersion]
+		err = migrate(s, &manifest)
+		if err != nil {
+			return fmt.Errorf("error migrating store from format version %d: %w", manifest.FormatVersion, err)
+		}
+		manifest.FormatVersion++
+		err = s.writeManifest(ctx, manifest)
+		if err != nil {
+			return fmt.Errorf("error on s.writeManifest: %w", err)
+		}
+	}
+
+	if !exists {
+		return s.writeManifest(ctx, manifest)
+	}
+	return nil
+}
+
+// newManifest describes a store that has no manifest yet. Empty stores get the current format
+// version, while stores holding data predate the manifest and get version 0
+func (s SIS) newManifest(ctx context.Context) (Manifest, error) {
+
+	idBytes := make([]byte, 16)
+	_, err := rand.Read(idBytes)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("error generating store id: %w", err)
+	}
+
+	manifest := Manifest{
+		FormatVersion: FormatVersion,
+		StoreID:       fmt.Sprintf("%x", idBytes),
+		Hash:          s.h.info(),
+		Chunking:      ChunkingNone,
+		CreatedAt:     time.Now().UTC(),
+	}
+
+	for _, space := range []pk.PK{constants.SystemDataSpace, constants.UserDataSpace} {
+		entries, err := s.crud.List(ctx, space)
+		if err != nil {
+			return Manifest{}, fmt.Errorf("error listing '%s': %w", space, err)
+		}
+		if len(entries) > 0 {
+			manifest.FormatVersion = 0
+			break
+		}
+	}
+
+	return manifest, nil
+}
+
+func (s SIS) readManifest(ctx context.Context) (Manifest, error) {
+	var manifest Manifest
+	err := s.readJSON(ctx, constants.SystemManifest, &manifest)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("error reading manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+func (s SIS) writeManifest(ctx context.Context, manifest Manifest) error {
+	err := s.writeJSON(ctx, constants.SystemManifest, manifest)
+	if err != nil {
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+	return nil
+}
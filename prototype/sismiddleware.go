@@ -0,0 +1,510 @@
+package sis
+
+import (
+	"context"
+	"io"
+	"sis/internal/metrics"
+	"sis/internal/pk"
+)
+
+// a Middleware wraps a Store to add behaviour around its operations
+type Middleware func(next Store) Store
+
+// Chain wraps store with middlewares. The first middleware is the outermost, so it sees every
+// call first and every result last
+func Chain(store Store, middlewares ...Middleware) Store {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		store = middlewares[i](store)
+	}
+	return store
+}
+
+// Op names a Store operation
+type Op string
+
+const (
+	OpCreate             Op = "create"
+	OpCreateFrom         Op = "createFrom"
+	OpCreateWithMetadata Op = "createWithMetadata"
+	OpUpdate             Op = "update"
+	OpUpdateFrom         Op = "updateFrom"
+	OpRead               Op = "read"
+	OpOpen               Op = "open"
+	OpDelete             Op = "delete"
+	OpPutIf              Op = "putIf"
+	OpDeleteIf           Op = "deleteIf"
+	OpExists             Op = "exists"
+	OpStat               Op = "stat"
+	OpSetMetadata        Op = "setMetadata"
+	OpCopy               Op = "copy"
+	OpMove               Op = "move"
+	OpCopyPrefix         Op = "copyPrefix"
+	OpMovePrefix         Op = "movePrefix"
+	OpBatchAdd           Op = "batchAdd"
+	OpBatch              Op = "batch"
+	OpList               Op = "list"
+	OpFind               Op = "find"
+	OpSnapshot           Op = "snapshot"
+	OpListSnapshots      Op = "listSnapshots"
+	OpReadAt             Op = "readAt"
+	OpRestoreSnapshot    Op = "restoreSnapshot"
+	OpDeleteSnapshot     Op = "deleteSnapshot"
+	OpListVersions       Op = "listVersions"
+	OpReadVersion        Op = "readVersion"
+	OpPruneVersions      Op = "pruneVersions"
+	OpCompactChanges     Op = "compactChanges"
+	OpUsage              Op = "usage"
+	OpCheck              Op = "check"
+	OpGC                 Op = "gc"
+)
+
+// a Call is a single Store operation seen by an interceptor
+type Call struct {
+	// Ctx is the context the operation runs with. Interceptors may replace it before calling Next
+	Ctx context.Context
+	Op  Op
+	// PK is the key or prefix the operation acts on, nil for store-wide operations
+	PK pk.PK
+	// Write is set for operations that modify the store
+	Write bool
+	// Bytes is the blob size moved by the operation. For reads it is only known once Next returns
+	Bytes metrics.Byte
+	next  func() error
+}
+
+// Next runs the operation on the wrapped store
+func (c *Call) Next() error {
+	return c.next()
+}
+
+// Intercept turns fn into a Middleware. fn sees every operation as a Call and decides whether and
+// when to run it through Call.Next
+func Intercept(fn func(call *Call) error) Middleware {
+	return func(next Store) Store {
+		return interceptedStore{next: next, fn: fn}
+	}
+}
+
+type interceptedStore struct {
+	next Store
+	fn   func(call *Call) error
+}
+
+func (i interceptedStore) Create(ctx context.Context, key pk.PK, blob []byte) error {
+	call := &Call{Ctx: ctx, Op: OpCreate, PK: key, Write: true, Bytes: metrics.Byte(len(blob))}
+	call.next = func() error {
+		return i.next.Create(call.Ctx, key, blob)
+	}
+	return i.fn(call)
+}
+
+func (i interceptedStore) CreateFrom(ctx context.Context, key pk.PK, r io.Reader) error {
+	call := &Call{Ctx: ctx, Op: OpCreateFrom, PK: key, Write: true}
+	call.next = func() error {
+		counter := &countingReader{r: r}
+		err := i.next.CreateFrom(call.Ctx, key, counter)
+		call.Bytes = counter.n
+		return err
+	}
+	return i.fn(call)
+}
+
+func (i interceptedStore) CreateWithMetadata(ctx context.Context, key pk.PK, r io.Reader, metadata map[string]any) (bool, error) {
+	var stored bool
+	call := &Call{Ctx: ctx, Op: OpCreateWithMetadata, PK: key, Write: true}
+	call.next = func() error {
+		counter := &countingReader{r: r}
+		var err error
+		stored, err = i.next.CreateWithMetadata(call.Ctx, key, counter, metadata)
+		call.Bytes = counter.n
+		return err
+	}
+	err := i.fn(call)
+	return stored, err
+}
+
+func (i interceptedStore) Update(ctx context.Context, key pk.PK, blob []byte) error {
+	call := &Call{Ctx: ctx, Op: OpUpdate, PK: key, Write: true, Bytes: metrics.Byte(len(blob))}
+	call.next = func() error {
+		return i.next.Update(call.Ctx, key, blob)
+	}
+	return i.fn(call)
+}
+
+func (i interceptedStore) UpdateFrom(ctx context.Context, key pk.PK, r io.Reader) error {
+	call := &Call{Ctx: ctx, Op: OpUpdateFrom, PK: key, Write: true}
+	call.next = func() error {
+		counter := &countingReader{r: r}
+		err := i.next.UpdateFrom(call.Ctx, key, counter)
+		call.Bytes = counter.n
+		return err
+	}
+	return i.fn(call)
+}
+
+func (i interceptedStore) Read(ctx context.Context, key pk.PK) ([]byte, error) {
+	var blob []byte
+	call := &Call{Ctx: ctx, Op: OpRead, PK: key}
+	call.next = func() error {
+		var err error
+		blob, err = i.next.Read(call.Ctx, key)
+		call.Bytes = metrics.Byte(len(blob))
+		return err
+	}
+	err := i.fn(call)
+	if err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+func (i interceptedStore) Open(ctx context.Context, key pk.PK) (io.ReadSeekCloser, ObjectInfo, error) {
+	var reader io.ReadSeekCloser
+	var info ObjectInfo
+	call := &Call{Ctx: ctx, Op: OpOpen, PK: key}
+	call.next = func() error {
+		var err error
+		reader, info, err = i.next.Open(call.Ctx, key)
+		call.Bytes = info.Size
+		return err
+	}
+	err := i.fn(call)
+	if err != nil {
+		if reader != nil {
+			reader.Close()
+		}
+		return nil, ObjectInfo{}, err
+	}
+	return reader, info, nil
+}
+
+func (i interceptedStore) Delete(ctx context.Context, key pk.PK) error {
+	call := &Call{Ctx: ctx, Op: OpDelete, PK: key, Write: true}
+	call.next = func() error {
+		return i.next.Delete(call.Ctx, key)
+	}
+	return i.fn(call)
+}
+
+func (i interceptedStore) PutIf(ctx context.Context, key pk.PK, r io.Reader, cond Condition) (bool, error) {
+	var created bool
+	call := &Call{Ctx: ctx, Op: OpPutIf, PK: key, Write: true}
+	call.next = func() error {
+		counter := &countingReader{r: r}
+		var err error
+		created, err = i.next.PutIf(call.Ctx, key, counter, cond)
+		call.Bytes = counter.n
+		return err
+	}
+	err := i.fn(call)
+	return created, err
+}
+
+func (i interceptedStore) DeleteIf(ctx context.Context, key pk.PK, cond Condition) error {
+	call := &Call{Ctx: ctx, Op: OpDeleteIf, PK: key, Write: true}
+	call.next = func() error {
+		return i.next.DeleteIf(call.Ctx, key, cond)
+	}
+	return i.fn(call)
+}
+
+func (i interceptedStore) Exists(ctx context.Context, key pk.PK) (bool, error) {
+	var exists bool
+	call := &Call{Ctx: ctx, Op: OpExists, PK: key}
+	call.next = func() error {
+		var err error
+		exists, err = i.next.Exists(call.Ctx, key)
+		return err
+	}
+	err := i.fn(call)
+	return exists, err
+}
+
+func (i interceptedStore) Stat(ctx context.Context, key pk.PK) (ObjectInfo, error) {
+	var info ObjectInfo
+	call := &Call{Ctx: ctx, Op: OpStat, PK: key}
+	call.next = func() error {
+		var err error
+		info, err = i.next.Stat(call.Ctx, key)
+		return err
+	}
+	err := i.fn(call)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return info, nil
+}
+
+func (i interceptedStore) SetMetadata(ctx context.Context, key pk.PK, metadata map[string]any) error {
+	call := &Call{Ctx: ctx, Op: OpSetMetadata, PK: key, Write: true}
+	call.next = func() error {
+		return i.next.SetMetadata(call.Ctx, key, metadata)
+	}
+	return i.fn(call)
+}
+
+func (i interceptedStore) Copy(ctx context.Context, src, dst pk.PK) error {
+	call := &Call{Ctx: ctx, Op: OpCopy, PK: dst, Write: true}
+	call.next = func() error {
+		return i.next.Copy(call.Ctx, src, dst)
+	}
+	return i.fn(call)
+}
+
+func (i interceptedStore) Move(ctx context.Context, src, dst pk.PK) error {
+	call := &Call{Ctx: ctx, Op: OpMove, PK: dst, Write: true}
+	call.next = func() error {
+		return i.next.Move(call.Ctx, src, dst)
+	}
+	return i.fn(call)
+}
+
+func (i interceptedStore) CopyPrefix(ctx context.Context, src, dst pk.PK) (int, error) {
+	var n int
+	call := &Call{Ctx: ctx, Op: OpCopyPrefix, PK: dst, Write: true}
+	call.next = func() error {
+		var err error
+		n, err = i.next.CopyPrefix(call.Ctx, src, dst)
+		return err
+	}
+	err := i.fn(call)
+	return n, err
+}
+
+func (i interceptedStore) MovePrefix(ctx context.Context, src, dst pk.PK) (int, error) {
+	var n int
+	call := &Call{Ctx: ctx, Op: OpMovePrefix, PK: dst, Write: true}
+	call.next = func() error {
+		var err error
+		n, err = i.next.MovePrefix(call.Ctx, src, dst)
+		return err
+	}
+	err := i.fn(call)
+	return n, err
+}
+
+// Batch starts a batch whose operations are each seen as a call when added, and whose Commit is
+// seen as a single call
+func (i interceptedStore) Batch() *Batch {
+	b := i.next.Batch()
+	add := b.add
+	b.add = func(ctx context.Context, key pk.PK, size metrics.Byte, next func(ctx context.Context) error) error {
+		call := &Call{Ctx: ctx, Op: OpBatchAdd, PK: key, Write: true, Bytes: size}
+		call.next = func() error {
+			return add(call.Ctx, key, size, next)
+		}
+		return i.fn(call)
+	}
+	apply := b.apply
+	b.apply = func(ctx context.Context) error {
+		call := &Call{Ctx: ctx, Op: OpBatch, Write: true}
+		call.next = func() error {
+			return apply(call.Ctx)
+		}
+		return i.fn(call)
+	}
+	return b
+}
+
+func (i interceptedStore) List(ctx context.Context, prefix pk.PK) ([]pk.PK, error) {
+	var keys []pk.PK
+	call := &Call{Ctx: ctx, Op: OpList, PK: prefix}
+	call.next = func() error {
+		var err error
+		keys, err = i.next.List(call.Ctx, prefix)
+		return err
+	}
+	err := i.fn(call)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (i interceptedStore) Find(ctx context.Context, pattern string) ([]ObjectInfo, error) {
+	var infos []ObjectInfo
+	call := &Call{Ctx: ctx, Op: OpFind}
+	call.next = func() error {
+		var err error
+		infos, err = i.next.Find(call.Ctx, pattern)
+		return err
+	}
+	err := i.fn(call)
+	if err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+func (i interceptedStore) Snapshot(ctx context.Context, name string) (SnapshotInfo, error) {
+	var info SnapshotInfo
+	call := &Call{Ctx: ctx, Op: OpSnapshot, Write: true}
+	call.next = func() error {
+		var err error
+		info, err = i.next.Snapshot(call.Ctx, name)
+		return err
+	}
+	err := i.fn(call)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	return info, nil
+}
+
+func (i interceptedStore) ListSnapshots(ctx context.Context) ([]SnapshotInfo, error) {
+	var infos []SnapshotInfo
+	call := &Call{Ctx: ctx, Op: OpListSnapshots}
+	call.next = func() error {
+		var err error
+		infos, err = i.next.ListSnapshots(call.Ctx)
+		return err
+	}
+	err := i.fn(call)
+	if err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+func (i interceptedStore) ReadAt(ctx context.Context, snapshot string, key pk.PK) ([]byte, error) {
+	var blob []byte
+	call := &Call{Ctx: ctx, Op: OpReadAt, PK: key}
+	call.next = func() error {
+		var err error
+		blob, err = i.next.ReadAt(call.Ctx, snapshot, key)
+		call.Bytes = metrics.Byte(len(blob))
+		return err
+	}
+	err := i.fn(call)
+	if err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+func (i interceptedStore) RestoreSnapshot(ctx context.Context, name string) error {
+	call := &Call{Ctx: ctx, Op: OpRestoreSnapshot, Write: true}
+	call.next = func() error {
+		return i.next.RestoreSnapshot(call.Ctx, name)
+	}
+	return i.fn(call)
+}
+
+func (i interceptedStore) DeleteSnapshot(ctx context.Context, name string) error {
+	call := &Call{Ctx: ctx, Op: OpDeleteSnapshot, Write: true}
+	call.next = func() error {
+		return i.next.DeleteSnapshot(call.Ctx, name)
+	}
+	return i.fn(call)
+}
+
+func (i interceptedStore) ListVersions(ctx context.Context, key pk.PK) ([]VersionInfo, error) {
+	var versions []VersionInfo
+	call := &Call{Ctx: ctx, Op: OpListVersions, PK: key}
+	call.next = func() error {
+		var err error
+		versions, err = i.next.ListVersions(call.Ctx, key)
+		return err
+	}
+	err := i.fn(call)
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (i interceptedStore) ReadVersion(ctx context.Context, key pk.PK, id string) ([]byte, error) {
+	var blob []byte
+	call := &Call{Ctx: ctx, Op: OpReadVersion, PK: key}
+	call.next = func() error {
+		var err error
+		blob, err = i.next.ReadVersion(call.Ctx, key, id)
+		call.Bytes = metrics.Byte(len(blob))
+		return err
+	}
+	err := i.fn(call)
+	if err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+func (i interceptedStore) PruneVersions(ctx context.Context) (int, error) {
+	var n int
+	call := &Call{Ctx: ctx, Op: OpPruneVersions, Write: true}
+	call.next = func() error {
+		var err error
+		n, err = i.next.PruneVersions(call.Ctx)
+		return err
+	}
+	err := i.fn(call)
+	return n, err
+}
+
+func (i interceptedStore) CompactChanges(ctx context.Context) (int, error) {
+	var n int
+	call := &Call{Ctx: ctx, Op: OpCompactChanges, Write: true}
+	call.next = func() error {
+		var err error
+		n, err = i.next.CompactChanges(call.Ctx)
+		return err
+	}
+	err := i.fn(call)
+	return n, err
+}
+
+func (i interceptedStore) Usage(ctx context.Context) (Usage, error) {
+	var usage Usage
+	call := &Call{Ctx: ctx, Op: OpUsage}
+	call.next = func() error {
+		var err error
+		usage, err = i.next.Usage(call.Ctx)
+		return err
+	}
+	err := i.fn(call)
+	if err != nil {
+		return Usage{}, err
+	}
+	return usage, nil
+}
+
+func (i interceptedStore) Check(ctx context.Context) ([]Problem, error) {
+	var problems []Problem
+	call := &Call{Ctx: ctx, Op: OpCheck}
+	call.next = func() error {
+		var err error
+		problems, err = i.next.Check(call.Ctx)
+		return err
+	}
+	err := i.fn(call)
+	if err != nil {
+		return nil, err
+	}
+	return problems, nil
+}
+
+func (i interceptedStore) GC(ctx context.Context) (GCResult, error) {
+	var result GCResult
+	call := &Call{Ctx: ctx, Op: OpGC, Write: true}
+	call.next = func() error {
+		var err error
+		result, err = i.next.GC(call.Ctx)
+		return err
+	}
+	err := i.fn(call)
+	if err != nil {
+		return GCResult{}, err
+	}
+	return result, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n metrics.Byte
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += metrics.Byte(n)
+	return n, err
+}
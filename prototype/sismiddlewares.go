@@ -0,0 +1,99 @@
+package sis
+
+import (
+	"log/slog"
+	"sis/internal/metrics"
+	"sync"
+	"time"
+)
+
+// Logging logs every operation with its key, size, duration and outcome
+func Logging(logger *slog.Logger) Middleware {
+	return Intercept(func(call *Call) error {
+		start := time.Now()
+		err := call.Next()
+		attrs := []any{
+			slog.String("op", string(call.Op)),
+			slog.String("pk", call.PK.Path()),
+			slog.Int64("bytes", int64(call.Bytes)),
+			slog.Duration("duration", time.Since(start)),
+		}
+		if err != nil {
+			logger.Error("sis operation failed", append(attrs, slog.String("error", err.Error()))...)
+			return err
+		}
+		logger.Info("sis operation", attrs...)
+		return nil
+	})
+}
+
+// ReadOnly refuses every operation that would modify the store
+func ReadOnly() Middleware {
+	return Intercept(func(call *Call) error {
+		if call.Write {
+			return &KeyError{Op: string(call.Op), Key: call.PK, Err: ErrReadOnly}
+		}
+		return call.Next()
+	})
+}
+
+// Counters accumulates per-operation counts, bytes and latencies. Its Middleware can wrap
+// several stores at once
+type Counters struct {
+	mu  sync.Mutex
+	ops map[Op]OpCounters
+}
+
+type OpCounters struct {
+	Calls   int           `json:"calls"`
+	Errors  int           `json:"errors"`
+	Bytes   metrics.Byte  `json:"bytes"`
+	Latency time.Duration `json:"latency"`
+}
+
+// MeanLatency is the average duration of a call
+func (o OpCounters) MeanLatency() time.Duration {
+	if o.Calls == 0 {
+		return 0
+	}
+	return o.Latency / time.Duration(o.Calls)
+}
+
+func NewCounters() *Counters {
+	return &Counters{
+		ops: make(map[Op]OpCounters),
+	}
+}
+
+func (c *Counters) Middleware() Middleware {
+	return Intercept(func(call *Call) error {
+		start := time.Now()
+		err := call.Next()
+		elapsed := time.Since(start)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		counters := c.ops[call.Op]
+		counters.Calls++
+		counters.Bytes += call.Bytes
+		counters.Latency += elapsed
+		if err != nil {
+			counters.Errors++
+		}
+		c.ops[call.Op] = counters
+
+		return err
+	})
+}
+
+// Snapshot returns a copy of the counters of every operation called so far
+func (c *Counters) Snapshot() map[Op]OpCounters {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	snapshot := make(map[Op]OpCounters, len(c.ops))
+	for op, counters := range c.ops {
+		snapshot[op] = counters
+	}
+	return snapshot
+}
@@ -1,17 +1,309 @@
 package sis
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"path/filepath"
 	"sis/internal/constants"
 	"sis/internal/data"
+	"sis/internal/metrics"
 	"sis/internal/pk"
+	"slices"
 )
 
-func (s SIS) pkExists(key pk.PK) (bool, error) {
+func blobPk(digest string) pk.PK {
+	return constants.SystemDataSpace.Suffix(pk.PK{digest}).Suffix(constants.BlobSuffix)
+}
+
+func metadataPk(digest string) pk.PK {
+	return constants.SystemDataSpace.Suffix(pk.PK{digest}).Suffix(constants.MetadataSuffix)
+}
+
+func dataHeaderPk(key pk.PK) pk.PK {
+	return key.Prefix(constants.UserDataSpace).Suffix(constants.DataHeaderSuffix)
+}
+
+func aliasPk(digest string) pk.PK {
+	return constants.SystemAliasSpace.Suffix(pk.PK{digest})
+}
+
+// digest hashes blob with the current hash
+func (s SIS) digest(blob []byte) string {
+	digest, _, _ := s.h.sum(bytes.NewReader(blob))
+	return digest
+}
+
+// create persists the header of a new pk pointing at digest. persistBlob is only called when
+// digest is not stored yet. Existing keys are handled according to the exists policy
+func (s SIS) create(ctx context.Context, key pk.PK, digest string, persistBlob func() error) error {
+	return s.createHeader(ctx, data.Header{PK: key, Digest: digest}, persistBlob)
+}
+
+// createHeader is create with the whole header, so that metadata is written along with the key
+func (s SIS) createHeader(ctx context.Context, header data.Header, persistBlob func() error) error {
+	key, digest := header.PK, header.Digest
+
+	err := checkKey("create", key)
+	if err != nil {
+		return err
+	}
+
+	pkExists, err := s.pkExists(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error on s.pkExists: %w", err)
+	}
+
+	if pkExists {
+		if s.existsPolicy != SkipIfIdentical {
+			return &KeyError{Op: "create", Key: key, Err: ErrAlreadyExists}
+		}
+		existing, err := s.readDataHeader(ctx, key)
+		if err != nil {
+			return fmt.Errorf("error on data header read: %w", err)
+		}
+		existingDigest, err := s.resolveDigest(ctx, existing.Digest)
+		if err != nil {
+			return fmt.Errorf("error on s.resolveDigest: %w", err)
+		}
+		if existingDigest != digest {
+			return &KeyError{Op: "create", Key: key, Err: fmt.Errorf("%w with different contents", ErrAlreadyExists)}
+		}
+		return nil
+	}
+
+	digestExists, err := s.digestExists(ctx, digest)
+	if err != nil {
+		return fmt.Errorf("error on s.digestExists: %w", err)
+	}
+
+	change, err := s.beginChange(ctx, Change{Kind: ChangeCreate, PK: key, NewDigest: digest, NewBlob: !digestExists})
+	if err != nil {
+		return fmt.Errorf("error on s.beginChange: %w", err)
+	}
+
+	err = s.persistDataHeader(ctx, header)
+	if err != nil {
+		return errors.Join(fmt.Errorf("error on s.persistDataHeader: %w", err), s.abortChange(ctx, change))
+	}
+
+	if !digestExists {
+		err = persistBlob()
+		if err != nil {
+			// a failed or cancelled write must not leave the header pointing at a missing blob
+			return errors.Join(fmt.Errorf("error on persistBlob: %w", err), s.deleteDataHeader(context.WithoutCancel(ctx), key), s.abortChange(ctx, change))
+		}
+	}
+
+	// the key is visible from here on, so its bookkeeping runs to the end even if ctx is done
+	ctx = context.WithoutCancel(ctx)
+
+	err = s.addKeyToDigestMetadata(ctx, digest, key)
+	if err != nil {
+		// a header the blob does not reference would lose the blob to the next delete of its keys
+		return errors.Join(fmt.Errorf("error on s.addKeyToDigestMetadata: %w", err), s.deleteDataHeader(ctx, key), s.abortChange(ctx, change))
+	}
+	s.commitChange(ctx, change)
+
+	err = s.recordVersion(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error on s.recordVersion: %w", err)
+	}
+
+	return nil
+
+}
+
+// update points the header of an existing pk at digest. The new reference is added before the
+// header changes and the old one is dropped after, so a crash never leaves a header pointing at
+// a blob that could be deleted
+func (s SIS) update(ctx context.Context, key pk.PK, digest string, persistBlob func() error) error {
+
+	err := checkKey("update", key)
+	if err != nil {
+		return err
+	}
+
+	pkExists, err := s.pkExists(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error on s.pkExists: %w", err)
+	}
+
+	if !pkExists {
+		return &KeyError{Op: "update", Key: key, Err: ErrNotFound}
+	}
+
+	header, err := s.readDataHeader(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error on data header read: %w", err)
+	}
+
+	oldDigest, err := s.resolveDigest(ctx, header.Digest)
+	if err != nil {
+		return fmt.Errorf("error on s.resolveDigest: %w", err)
+	}
+
+	if oldDigest == digest {
+		// a replayed put finds the header already written, but its reference may not be
+		err = s.addKeyToDigestMetadata(ctx, digest, key)
+		if err != nil {
+			return fmt.Errorf("error on s.addKeyToDigestMetadata: %w", err)
+		}
+		return nil
+	}
+
+	digestExists, err := s.digestExists(ctx, digest)
+	if err != nil {
+		return fmt.Errorf("error on s.digestExists: %w", err)
+	}
+
+	change, err := s.beginChange(ctx, Change{Kind: ChangeUpdate, PK: key, OldDigest: oldDigest, NewDigest: digest, NewBlob: !digestExists})
+	if err != nil {
+		return fmt.Errorf("error on s.beginChange: %w", err)
+	}
+
+	if !digestExists {
+		err = persistBlob()
+		if err != nil {
+			return errors.Join(fmt.Errorf("error on persistBlob: %w", err), s.abortChange(ctx, change))
+		}
+	}
+
+	// once the new blob is in place the update runs to the end even if ctx is done
+	ctx = context.WithoutCancel(ctx)
+
+	err = s.addKeyToDigestMetadata(ctx, digest, key)
+	if err != nil {
+		return errors.Join(fmt.Errorf("error on s.addKeyToDigestMetadata: %w", err), s.abortChange(ctx, change))
+	}
+
+	header.Digest = digest
+	err = s.updateDataHeader(ctx, header)
+	if err != nil {
+		return errors.Join(fmt.Errorf("error on s.updateDataHeader: %w", err), s.abortChange(ctx, change))
+	}
+	s.commitChange(ctx, change)
+
+	shouldDelete, err := s.removeKeyFromDigestMetadata(ctx, oldDigest, key)
+	if err != nil {
+		return fmt.Errorf("error on key removal from metadata: %w", err)
+	}
+
+	if shouldDelete {
+		err = s.deleteBlob(ctx, oldDigest)
+		if err != nil {
+			return fmt.Errorf("error on blob deletion: %w", err)
+		}
+	}
+
+	err = s.recordVersion(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error on s.recordVersion: %w", err)
+	}
+
+	return nil
+}
+
+func (s SIS) delete(ctx context.Context, key pk.PK) error {
+
+	pkExists, err := s.pkExists(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error on s.pkExists: %w", err)
+	}
+
+	if !pkExists {
+		return &KeyError{Op: "delete", Key: key, Err: ErrNotFound}
+	}
+
+	header, err := s.readDataHeader(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error on data header read: %w", err)
+	}
+
+	header.Digest, err = s.resolveDigest(ctx, header.Digest)
+	if err != nil {
+		return fmt.Errorf("error on s.resolveDigest: %w", err)
+	}
+
+	// a delete is not interrupted once it starts changing the store
+	ctx = context.WithoutCancel(ctx)
+
+	digestExists, err := s.digestExists(ctx, header.Digest)
+	if err != nil {
+		return fmt.Errorf("error on s.digestExists: %w", err)
+	}
+
+	// the size is only known before the blob is gone
+	var size metrics.Byte
+	if s.feed != nil && digestExists {
+		size, err = s.crud.SizeOf(ctx, blobPk(header.Digest))
+		if err != nil {
+			return fmt.Errorf("error on blob s.crud.SizeOf: %w", err)
+		}
+	}
+
+	change, err := s.beginChange(ctx, Change{Kind: ChangeDelete, PK: key, OldDigest: header.Digest, Size: size})
+	if err != nil {
+		return fmt.Errorf("error on s.beginChange: %w", err)
+	}
+
+	if !digestExists {
+		// if code gets here, probably an incomplete deletion previously occured.
+		// we should complete it
+		err := s.deleteDataHeader(ctx, key)
+		if err != nil {
+			return errors.Join(fmt.Errorf("error on data header delete: %w", err), s.abortChange(ctx, change))
+		}
+	}
 
-	exists, err := s.dataHeaderExists(key)
+	err = s.deleteDataHeader(ctx, key)
+	if err != nil {
+		return errors.Join(fmt.Errorf("error on data header delete: %w", err), s.abortChange(ctx, change))
+	}
+	s.commitChange(ctx, change)
+
+	shouldDelete, err := s.removeKeyFromDigestMetadata(ctx, header.Digest, key)
+	if err != nil {
+		return fmt.Errorf("error on key removal from metadata: %w", err)
+	}
+
+	if shouldDelete {
+		err = s.deleteBlob(ctx, header.Digest)
+		if err != nil {
+			return fmt.Errorf("error on blob deletion: %w", err)
+		}
+	}
+
+	return nil
+
+}
+
+// updateMetadata writes header with metadata, recording the change in the feed
+func (s SIS) updateMetadata(ctx context.Context, header data.Header, metadata map[string]any) error {
+
+	digest, err := s.resolveDigest(ctx, header.Digest)
+	if err != nil {
+		return fmt.Errorf("error on s.resolveDigest: %w", err)
+	}
+	change, err := s.beginChange(ctx, Change{Kind: ChangeMetadata, PK: header.PK, OldDigest: digest, NewDigest: digest})
+	if err != nil {
+		return fmt.Errorf("error on s.beginChange: %w", err)
+	}
+
+	header.Metadata = metadata
+	err = s.updateDataHeader(ctx, header)
+	if err != nil {
+		return errors.Join(fmt.Errorf("error on s.updateDataHeader: %w", err), s.abortChange(ctx, change))
+	}
+	s.commitChange(ctx, change)
+
+	return nil
+}
+
+func (s SIS) pkExists(ctx context.Context, key pk.PK) (bool, error) {
+
+	exists, err := s.dataHeaderExists(ctx, key)
 	if err != nil {
 		return exists, fmt.Errorf("error on s.dataHeaderExists: %w", err)
 	}
@@ -20,13 +312,16 @@ func (s SIS) pkExists(key pk.PK) (bool, error) {
 
 }
 
-func (s SIS) dataHeaderExists(key pk.PK) (bool, error) {
-	return s.crud.Exists(key.Prefix(constants.UserDataSpace).Suffix(constants.DataHeaderSuffix))
+func (s SIS) dataHeaderExists(ctx context.Context, key pk.PK) (bool, error) {
+	return s.crud.Exists(ctx, key.Prefix(constants.UserDataSpace).Suffix(constants.DataHeaderSuffix))
 }
 
-func (s SIS) readDataHeader(key pk.PK) (data.Header, error) {
+func (s SIS) readDataHeader(ctx context.Context, key pk.PK) (data.Header, error) {
 
-	headerBlob, err := s.crud.Read(key.Prefix(constants.UserDataSpace).Suffix(constants.DataHeaderSuffix))
+	headerBlob, err := s.crud.Read(ctx, key.Prefix(constants.UserDataSpace).Suffix(constants.DataHeaderSuffix))
+	if errors.Is(err, ErrNotFound) {
+		return data.Header{}, &KeyError{Op: "read", Key: key, Err: ErrNotFound}
+	}
 	if err != nil {
 		return data.Header{}, fmt.Errorf("error on s.crud.Read: %w", err)
 	}
@@ -34,16 +329,16 @@ func (s SIS) readDataHeader(key pk.PK) (data.Header, error) {
 	var header data.Header
 	err = json.Unmarshal(headerBlob, &header)
 	if err != nil {
-		return data.Header{}, fmt.Errorf("error on header unmarshal: %w", err)
+		return data.Header{}, fmt.Errorf("error on header unmarshal: %w: %w", ErrCorrupted, err)
 	}
 
 	return header, nil
 }
 
-func (s SIS) deleteDataHeader(key pk.PK) error {
+func (s SIS) deleteDataHeader(ctx context.Context, key pk.PK) error {
 	dataHeaderPk := key.Prefix(constants.UserDataSpace).Suffix(constants.DataHeaderSuffix)
 
-	err := s.crud.Delete(dataHeaderPk)
+	err := s.crud.Delete(ctx, dataHeaderPk)
 	if err != nil {
 		return fmt.Errorf("error on s.crud.Delete: %w", err)
 	}
@@ -51,7 +346,7 @@ func (s SIS) deleteDataHeader(key pk.PK) error {
 	return nil
 }
 
-func (s SIS) persistDataHeader(header data.Header) error {
+func (s SIS) persistDataHeader(ctx context.Context, header data.Header) error {
 
 	headerBytes, err := json.Marshal(header)
 	if err != nil {
@@ -60,7 +355,7 @@ func (s SIS) persistDataHeader(header data.Header) error {
 
 	dataHeaderPk := header.PK.Prefix(constants.UserDataSpace).Suffix(constants.DataHeaderSuffix)
 
-	err = s.crud.Create(dataHeaderPk, headerBytes)
+	err = s.crud.Create(ctx, dataHeaderPk, headerBytes)
 	if err != nil {
 		return fmt.Errorf("error on s.crud.Create: %w", err)
 	}
@@ -69,26 +364,44 @@ func (s SIS) persistDataHeader(header data.Header) error {
 
 }
 
-func (s SIS) digestExists(digest string) (bool, error) {
-	blobPk := constants.SystemDataSpace.Suffix(pk.New(filepath.Join(digest, "blob")))
-	return s.crud.Exists(blobPk)
+func (s SIS) updateDataHeader(ctx context.Context, header data.Header) error {
+
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("error on header marshal: %w", err)
+	}
+
+	dataHeaderPk := header.PK.Prefix(constants.UserDataSpace).Suffix(constants.DataHeaderSuffix)
+
+	err = s.crud.Update(ctx, dataHeaderPk, headerBytes)
+	if err != nil {
+		return fmt.Errorf("error on s.crud.Update: %w", err)
+	}
+
+	return nil
+
+}
+
+func (s SIS) digestExists(ctx context.Context, digest string) (bool, error) {
+	blobPk := blobPk(digest)
+	return s.crud.Exists(ctx, blobPk)
 }
 
-func (s SIS) readBlob(digest string) ([]byte, error) {
-	blobPk := constants.SystemDataSpace.Suffix(pk.New(filepath.Join(digest, "blob")))
+func (s SIS) readBlob(ctx context.Context, digest string) ([]byte, error) {
+	blobPk := blobPk(digest)
 
-	blob, err := s.crud.Read(blobPk)
+	blob, err := s.crud.Read(ctx, blobPk)
 	if err != nil {
-		return nil, fmt.Errorf("error on blob s.crud.Read: %w", err)
+		return nil, fmt.Errorf("error on blob s.crud.Read: %w", missingAsCorrupted(err))
 	}
 
 	return blob, nil
 }
 
-func (s SIS) deleteBlob(digest string) error {
-	blobPk := constants.SystemDataSpace.Suffix(pk.New(filepath.Join(digest, "blob")))
+func (s SIS) deleteBlob(ctx context.Context, digest string) error {
+	blobPk := blobPk(digest)
 
-	err := s.crud.Delete(blobPk)
+	err := s.crud.Delete(ctx, blobPk)
 	if err != nil {
 		return fmt.Errorf("error on blob s.crud.Delete: %w", err)
 	}
@@ -96,10 +409,19 @@ func (s SIS) deleteBlob(digest string) error {
 	return nil
 }
 
-func (s SIS) persistBlob(digest string, blob []byte) error {
+func (s SIS) persistBlob(ctx context.Context, digest string, blob []byte) error {
+
+	err := s.crud.Create(ctx, blobPk(digest), blob)
+	if err != nil {
+		return fmt.Errorf("error on blob s.crud.Create: %w", err)
+	}
 
-	blobPk := constants.SystemDataSpace.Suffix(pk.New(filepath.Join(digest, "blob")))
-	metadataPk := constants.SystemDataSpace.Suffix(pk.New(filepath.Join(digest, "metadata")))
+	return s.persistEmptyBlobMetadata(ctx, digest)
+}
+
+// persistEmptyBlobMetadata writes the metadata of a blob just put in place. If it cannot, the blob is
+// removed as well, since creates take a blob as stored as soon as it exists
+func (s SIS) persistEmptyBlobMetadata(ctx context.Context, digest string) error {
 
 	metadata := data.BlobMetadata{
 		PkList: make([]pk.PK, 0),
@@ -110,28 +432,28 @@ func (s SIS) persistBlob(digest string, blob []byte) error {
 		return fmt.Errorf("error on metadata marshal: %w", err)
 	}
 
-	err = s.crud.Create(blobPk, blob)
-	if err != nil {
-		return fmt.Errorf("error on blob s.crud.Create: %w", err)
-	}
-
-	err = s.crud.Create(metadataPk, metadataBytes)
+	err = s.crud.Create(ctx, metadataPk(digest), metadataBytes)
 	if err != nil {
-		return fmt.Errorf("error on metadata s.crudCreate: %w", err)
+		return errors.Join(fmt.Errorf("error on metadata s.crudCreate: %w", err), s.deleteBlob(context.WithoutCancel(ctx), digest))
 	}
 
 	return nil
 }
 
-func (s SIS) addKeyToDigestMetadata(digest string, key pk.PK) error {
+// addKeyToDigestMetadata references digest from key. Keys already referencing it are left as they
+// are, so that operations can be applied again after a crash
+func (s SIS) addKeyToDigestMetadata(ctx context.Context, digest string, key pk.PK) error {
 
-	metadata, err := s.readBlobMetadata(digest)
+	metadata, err := s.readBlobMetadata(ctx, digest)
 	if err != nil {
 		return fmt.Errorf("error on blob metadata read: %w", err)
 	}
+	if slices.ContainsFunc(metadata.PkList, func(currKey pk.PK) bool { return currKey.Path() == key.Path() }) {
+		return nil
+	}
 	metadata.PkList = append(metadata.PkList, key)
 
-	err = s.updateBlobMetadata(digest, metadata)
+	err = s.updateBlobMetadata(ctx, digest, metadata)
 	if err != nil {
 		return fmt.Errorf("error on blob metadata update: %w", err)
 	}
@@ -139,15 +461,15 @@ func (s SIS) addKeyToDigestMetadata(digest string, key pk.PK) error {
 	return nil
 }
 
-func (s SIS) removeKeyFromDigestMetadata(digest string, key pk.PK) (shouldDelete bool, err error) {
+func (s SIS) removeKeyFromDigestMetadata(ctx context.Context, digest string, key pk.PK) (shouldDelete bool, err error) {
 
-	metadata, err := s.readBlobMetadata(digest)
+	metadata, err := s.readBlobMetadata(ctx, digest)
 	if err != nil {
 		return false, fmt.Errorf("error on blob metadata read: %w", err)
 	}
 
-	if len(metadata.PkList) == 0 {
-		err = s.deleteBlobMetadata(digest)
+	if len(metadata.PkList) == 0 && len(metadata.Refs) == 0 {
+		err = s.deleteBlobMetadata(ctx, digest)
 		if err != nil {
 			return false, fmt.Errorf("error deleting blob metadata: %w", err)
 		}
@@ -168,13 +490,13 @@ func (s SIS) removeKeyFromDigestMetadata(digest string, key pk.PK) (shouldDelete
 	metadata.PkList[foundIndex] = metadata.PkList[len(metadata.PkList)-1]
 	metadata.PkList = metadata.PkList[:len(metadata.PkList)-1]
 
-	err = s.updateBlobMetadata(digest, metadata)
+	err = s.updateBlobMetadata(ctx, digest, metadata)
 	if err != nil {
 		return false, fmt.Errorf("error on blob metadata update: %w", err)
 	}
 
-	if len(metadata.PkList) == 0 {
-		err = s.deleteBlobMetadata(digest)
+	if len(metadata.PkList) == 0 && len(metadata.Refs) == 0 {
+		err = s.deleteBlobMetadata(ctx, digest)
 		if err != nil {
 			return false, fmt.Errorf("error deleting blob metadata: %w", err)
 		}
@@ -184,41 +506,41 @@ func (s SIS) removeKeyFromDigestMetadata(digest string, key pk.PK) (shouldDelete
 	return false, nil
 }
 
-func (s SIS) updateBlobMetadata(digest string, new data.BlobMetadata) error {
+func (s SIS) updateBlobMetadata(ctx context.Context, digest string, new data.BlobMetadata) error {
 
-	metadataPk := constants.SystemDataSpace.Suffix(pk.New(filepath.Join(digest, "metadata")))
+	metadataPk := metadataPk(digest)
 
 	newMetadataBytes, err := json.Marshal(new)
 	if err != nil {
 		return fmt.Errorf("error on new metadata marshal: %w", err)
 	}
 
-	return s.crud.Update(metadataPk, newMetadataBytes)
+	return s.crud.Update(ctx, metadataPk, newMetadataBytes)
 }
 
-func (s SIS) readBlobMetadata(digest string) (data.BlobMetadata, error) {
+func (s SIS) readBlobMetadata(ctx context.Context, digest string) (data.BlobMetadata, error) {
 
-	metadataPk := constants.SystemDataSpace.Suffix(pk.New(filepath.Join(digest, "metadata")))
+	metadataPk := metadataPk(digest)
 
-	metadataBytes, err := s.crud.Read(metadataPk)
+	metadataBytes, err := s.crud.Read(ctx, metadataPk)
 	if err != nil {
-		return data.BlobMetadata{}, fmt.Errorf("error on metadata s.crud.Read: %w", err)
+		return data.BlobMetadata{}, fmt.Errorf("error on metadata s.crud.Read: %w", missingAsCorrupted(err))
 	}
 
 	var metadata data.BlobMetadata
 	err = json.Unmarshal(metadataBytes, &metadata)
 	if err != nil {
-		return data.BlobMetadata{}, fmt.Errorf("error on metadata unmarshal: %w", err)
+		return data.BlobMetadata{}, fmt.Errorf("error on metadata unmarshal: %w: %w", ErrCorrupted, err)
 	}
 
 	return metadata, nil
 
 }
 
-func (s SIS) deleteBlobMetadata(digest string) error {
-	metadataPk := constants.SystemDataSpace.Suffix(pk.New(filepath.Join(digest, "metadata")))
+func (s SIS) deleteBlobMetadata(ctx context.Context, digest string) error {
+	metadataPk := metadataPk(digest)
 
-	err := s.crud.Delete(metadataPk)
+	err := s.crud.Delete(ctx, metadataPk)
 	if err != nil {
 		return fmt.Errorf("error deleting metadata file: %w", err)
 	}
@@ -226,3 +548,144 @@ func (s SIS) deleteBlobMetadata(digest string) error {
 	return nil
 
 }
+
+// resolveDigest follows the alias table when digest has been re-hashed away.
+// Digests with neither a blob nor an alias are returned unchanged
+func (s SIS) resolveDigest(ctx context.Context, digest string) (string, error) {
+
+	exists, err := s.digestExists(ctx, digest)
+	if err != nil {
+		return "", fmt.Errorf("error on s.digestExists: %w", err)
+	}
+	if exists {
+		return digest, nil
+	}
+
+	aliased, err := s.crud.Exists(ctx, aliasPk(digest))
+	if err != nil {
+		return "", fmt.Errorf("error checking alias existence: %w", err)
+	}
+	if !aliased {
+		return digest, nil
+	}
+
+	newDigest, err := s.crud.Read(ctx, aliasPk(digest))
+	if err != nil {
+		return "", fmt.Errorf("error reading alias: %w", err)
+	}
+
+	return string(newDigest), nil
+}
+
+func (s SIS) listDigests(ctx context.Context) ([]string, error) {
+
+	entries, err := s.crud.List(ctx, constants.SystemDataSpace)
+	if err != nil {
+		return nil, fmt.Errorf("error listing system data space: %w", err)
+	}
+
+	var digests []string
+	for _, entry := range entries {
+		if entry.IsDir {
+			digests = append(digests, entry.Name)
+		}
+	}
+
+	return digests, nil
+}
+
+// writeFile creates key or replaces its contents if it already exists
+func (s SIS) writeFile(ctx context.Context, key pk.PK, blob []byte) error {
+
+	exists, err := s.crud.Exists(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error on s.crud.Exists: %w", err)
+	}
+
+	if exists {
+		return s.crud.Update(ctx, key, blob)
+	}
+	return s.crud.Create(ctx, key, blob)
+}
+
+func (s SIS) readJSON(ctx context.Context, key pk.PK, v any) error {
+
+	blob, err := s.crud.Read(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error on s.crud.Read: %w", err)
+	}
+
+	err = json.Unmarshal(blob, v)
+	if err != nil {
+		return fmt.Errorf("error on unmarshal: %w: %w", ErrCorrupted, err)
+	}
+
+	return nil
+}
+
+func (s SIS) writeJSON(ctx context.Context, key pk.PK, v any) error {
+
+	blob, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error on marshal: %w", err)
+	}
+
+	return s.writeFile(ctx, key, blob)
+}
+
+// walkKeys calls fn for every key under prefix, in order
+func (s SIS) walkKeys(ctx context.Context, prefix pk.PK, fn func(key pk.PK) error) error {
+	return s.walkHeaders(ctx, constants.UserDataSpace, prefix, fn)
+}
+
+// walkHeaders calls fn with every key under prefix that has a header in space
+func (s SIS) walkHeaders(ctx context.Context, space pk.PK, prefix pk.PK, fn func(key pk.PK) error) error {
+
+	entries, err := s.crud.List(ctx, prefix.Prefix(space))
+	if err != nil {
+		return fmt.Errorf("error listing '%s': %w", prefix.Path(), err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir {
+			if entry.Name == constants.DataHeaderSuffix.Path() {
+				err = fn(slices.Clone(prefix))
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		err = s.walkHeaders(ctx, space, prefix.Suffix(pk.PK{entry.Name}), fn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s SIS) stat(ctx context.Context, key pk.PK) (ObjectInfo, error) {
+
+	header, err := s.readDataHeader(ctx, key)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("error on data header read: %w", err)
+	}
+
+	digest, err := s.resolveDigest(ctx, header.Digest)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("error on s.resolveDigest: %w", err)
+	}
+
+	size, err := s.crud.SizeOf(ctx, blobPk(digest))
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("error on blob s.crud.SizeOf: %w", missingAsCorrupted(err))
+	}
+
+	return ObjectInfo{
+		PK:       header.PK,
+		Digest:   digest,
+		Size:     size,
+		Metadata: header.Metadata,
+	}, nil
+}
@@ -1,85 +1,144 @@
 package sis
 
 import (
+	"context"
 	"fmt"
 	"hash"
 	"sis/internal/crud"
-	"sis/internal/data"
+	"sis/internal/metrics"
 	"sis/internal/pk"
+	"sync"
 )
 
 // SIS is an instance of a Single Instance Storage system with full CRUD capabilities
 type SIS struct {
 	// main functionality
-	h    hash.Hash
+	h    *sharedHash
 	crud crud.Crud
+	// mu serializes writers and lets readers proceed concurrently.
+	// It is a pointer so that copies of a SIS share the same lock
+	mu *sync.RWMutex
+	// options
+	existsPolicy ExistsPolicy
+	// feed is nil unless the store was opened WithChangeFeed
+	feed *changeFeed
+	// versioning is nil unless the store was opened WithVersioning
+	versioning *VersionRetention
 }
 
-func New(h hash.Hash, crud crud.Crud) SIS {
-	return SIS{
-		h:    h,
-		crud: crud,
-	}
-}
-
-func (s *SIS) GetCrud() crud.Crud {
-	return s.crud
-}
-
-func (s *SIS) Create(pk pk.PK, blob []byte) error {
+// ExistsPolicy decides what Create does with keys that already exist
+type ExistsPolicy int
 
-	s.h.Write(blob)
-	digestSum := s.h.Sum(nil)
-	digest := fmt.Sprintf("%x", digestSum)
-	s.h.Reset()
+const (
+	// FailIfExists makes Create refuse every existing key. It is the default
+	FailIfExists ExistsPolicy = iota
+	// SkipIfIdentical makes Create succeed without changes when the key already holds the same
+	// digest, so that imports can be re-run safely
+	SkipIfIdentical
+)
 
-	header := data.Header{
-		PK:     pk,
-		Digest: digest,
-	}
+// an Option configures a SIS instance on New
+type Option func(s *SIS)
 
-	pkExists, err := s.pkExists(pk)
-	if err != nil {
-		return fmt.Errorf("error on s.pkExists: %w", err)
+func WithExistsPolicy(policy ExistsPolicy) Option {
+	return func(s *SIS) {
+		s.existsPolicy = policy
 	}
+}
 
-	if pkExists {
-		return fmt.Errorf("pk already exists")
+// New opens the store kept by crud, writing its manifest on first open. It refuses stores written
+// with another hash or with a newer format version, and upgrades older formats in place
+func New(ctx context.Context, h hash.Hash, crud crud.Crud, options ...Option) (SIS, error) {
+	s := SIS{
+		h:    &sharedHash{h: h},
+		crud: crud,
+		mu:   &sync.RWMutex{},
 	}
-
-	err = s.persistDataHeader(header)
-	if err != nil {
-		return fmt.Errorf("error on s.persistDataHeader: %w", err)
+	for _, option := range options {
+		option(&s)
 	}
 
-	digestExists, err := s.digestExists(digest)
+	err := s.open(ctx)
 	if err != nil {
-		return fmt.Errorf("error on s.digestExists: %w", err)
+		return SIS{}, fmt.Errorf("error opening store: %w", err)
 	}
 
-	if !digestExists {
-		err = s.persistBlob(digest, blob)
+	if s.feed != nil {
+		err = s.recoverChanges(ctx)
 		if err != nil {
-			return fmt.Errorf("error on s.persistBlob: %w", err)
+			return SIS{}, fmt.Errorf("error recovering pending changes: %w", err)
+		}
+		err = s.loadFeed(ctx)
+		if err != nil {
+			return SIS{}, fmt.Errorf("error loading change feed: %w", err)
 		}
 	}
 
-	err = s.addKeyToDigestMetadata(digest, pk)
+	err = s.recoverIntents(ctx)
 	if err != nil {
-		return fmt.Errorf("error on s.updateDigestMetadata: %w", err)
+		return SIS{}, fmt.Errorf("error recovering interrupted operations: %w", err)
 	}
 
-	return nil
+	return s, nil
+}
+
+// ObjectInfo describes a user key without reading its blob
+type ObjectInfo struct {
+	PK       pk.PK          `json:"pk"`
+	Digest   string         `json:"digest"`
+	Size     metrics.Byte   `json:"size"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+func (s *SIS) GetCrud() crud.Crud {
+	return s.crud
+}
+
+func (s *SIS) Create(ctx context.Context, pk pk.PK, blob []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	digest := s.digest(blob)
+
+	return s.create(ctx, pk, digest, func() error {
+		return s.persistBlob(ctx, digest, blob)
+	})
 }
 
-func (s *SIS) Read(pk pk.PK) ([]byte, error) {
-	header, err := s.readDataHeader(pk)
+// Update replaces the contents of an existing pk
+func (s *SIS) Update(ctx context.Context, pk pk.PK, blob []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	digest := s.digest(blob)
+
+	return s.update(ctx, pk, digest, func() error {
+		return s.persistBlob(ctx, digest, blob)
+	})
+}
+
+func (s *SIS) Exists(ctx context.Context, pk pk.PK) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.pkExists(ctx, pk)
+}
+
+func (s *SIS) Read(ctx context.Context, pk pk.PK) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	header, err := s.readDataHeader(ctx, pk)
 	if err != nil {
 		return nil, fmt.Errorf("error on data header read: %w", err)
 	}
 
-	blob, err := s.readBlob(header.Digest)
+	digest, err := s.resolveDigest(ctx, header.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("error on s.resolveDigest: %w", err)
+	}
+
+	blob, err := s.readBlob(ctx, digest)
 	if err != nil {
 		return nil, fmt.Errorf("error on blob read: %w", err)
 	}
@@ -87,52 +146,51 @@ func (s *SIS) Read(pk pk.PK) ([]byte, error) {
 	return blob, nil
 }
 
-func (s *SIS) Delete(pk pk.PK) error {
+func (s *SIS) Delete(ctx context.Context, pk pk.PK) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	pkExists, err := s.pkExists(pk)
-	if err != nil {
-		return fmt.Errorf("error on s.pkExists: %w", err)
-	}
+	return s.delete(ctx, pk)
+}
 
-	if !pkExists {
-		return fmt.Errorf("pk does not exist")
-	}
+// List returns every key under prefix, sorted. An empty prefix lists the whole store
+func (s *SIS) List(ctx context.Context, prefix pk.PK) ([]pk.PK, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	header, err := s.readDataHeader(pk)
+	var keys []pk.PK
+	err := s.walkKeys(ctx, prefix, func(key pk.PK) error {
+		keys = append(keys, key)
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("error on data header read: %w", err)
+		return nil, fmt.Errorf("error on s.walkKeys: %w", err)
 	}
 
-	digestExists, err := s.digestExists(header.Digest)
-	if err != nil {
-		return fmt.Errorf("error on s.digestExists: %w", err)
-	}
+	return keys, nil
+}
 
-	if !digestExists {
-		// if code gets here, probably an incomplete deletion previously occured.
-		// we should complete it
-		err := s.deleteDataHeader(pk)
-		if err != nil {
-			return fmt.Errorf("error on data header delete: %w", err)
-		}
-	}
+func (s *SIS) Stat(ctx context.Context, pk pk.PK) (ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.stat(ctx, pk)
+}
+
+// SetMetadata replaces the metadata of an existing pk. A nil metadata clears it
+func (s *SIS) SetMetadata(ctx context.Context, pk pk.PK, metadata map[string]any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	err = s.deleteDataHeader(pk)
+	header, err := s.readDataHeader(ctx, pk)
 	if err != nil {
-		return fmt.Errorf("error on data header delete: %w", err)
+		return fmt.Errorf("error on data header read: %w", err)
 	}
 
-	shouldDelete, err := s.removeKeyFromDigestMetadata(header.Digest, pk)
+	err = s.updateMetadata(ctx, header, metadata)
 	if err != nil {
-		return fmt.Errorf("error on key removal from metadata: %w", err)
+		return fmt.Errorf("error on s.updateMetadata: %w", err)
 	}
 
-	if shouldDelete {
-		err = s.deleteBlob(header.Digest)
-		if err != nil {
-			return fmt.Errorf("error on blob deletion: %w", err)
-		}
-	}
 	return nil
-
 }
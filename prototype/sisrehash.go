@@ -0,0 +1,388 @@
+package sis
+
+import (
+	"context"
+	"fmt"
+	"hash"
+	"sis/internal/constants"
+	"sis/internal/data"
+	sishash "sis/internal/hash"
+	"sis/internal/pk"
+	"slices"
+)
+
+// a Rehasher moves every blob of a SIS instance from its current hash to a new one, one digest
+// at a time, while the instance keeps serving reads and writes. Old digests stay readable through
+// the alias table until the whole migration is done
+type Rehasher struct {
+	sisInstance *SIS
+	newHash     hash.Hash
+	progress    func(RehashProgress)
+}
+
+// RehashProgress is reported after every migrated digest
+type RehashProgress struct {
+	Done      int
+	Total     int
+	OldDigest string
+	NewDigest string
+}
+
+// the plan is the list of digests present when the migration started. Anything written after
+// that already uses the new hash
+type rehashPlan struct {
+	Digests []string `json:"digests"`
+}
+
+type rehashCheckpoint struct {
+	Done int `json:"done"`
+}
+
+var rehashPlanPk = constants.SystemMigrationSpace.Suffix(pk.New("rehash-plan"))
+var rehashCheckpointPk = constants.SystemMigrationSpace.Suffix(pk.New("rehash-checkpoint"))
+
+// NewRehasher prepares a migration of s to newHash. progress may be nil
+func (s *SIS) NewRehasher(newHash hash.Hash, progress func(RehashProgress)) *Rehasher {
+	return &Rehasher{
+		sisInstance: s,
+		newHash:     newHash,
+		progress:    progress,
+	}
+}
+
+// Run migrates every digest in the plan. If a previous run was interrupted, it resumes from the
+// last checkpoint. As soon as Run starts, new writes on the instance are hashed with the new hash
+func (r *Rehasher) Run(ctx context.Context) error {
+
+	plan, done, err := r.start(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting rehash: %w", err)
+	}
+
+	for i := done; i < len(plan.Digests); i++ {
+		oldDigest := plan.Digests[i]
+		newDigest, err := r.rehashDigest(ctx, oldDigest)
+		if err != nil {
+			return fmt.Errorf("error rehashing digest '%s': %w", oldDigest, err)
+		}
+
+		err = r.saveCheckpoint(ctx, rehashCheckpoint{Done: i + 1})
+		if err != nil {
+			return fmt.Errorf("error saving checkpoint: %w", err)
+		}
+
+		if r.progress != nil {
+			r.progress(RehashProgress{
+				Done:      i + 1,
+				Total:     len(plan.Digests),
+				OldDigest: oldDigest,
+				NewDigest: newDigest,
+			})
+		}
+	}
+
+	err = r.finish(ctx, plan)
+	if err != nil {
+		return fmt.Errorf("error finishing rehash: %w", err)
+	}
+
+	return nil
+}
+
+// start loads the plan and checkpoint of an interrupted run, or creates new ones, and switches
+// the instance, along with every copy of it, to the new hash. An interrupted run can only be
+// resumed with the hash it started with
+func (r *Rehasher) start(ctx context.Context) (rehashPlan, int, error) {
+	s := r.sisInstance
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	manifest, err := s.readManifest(ctx)
+	if err != nil {
+		return rehashPlan{}, 0, fmt.Errorf("error on s.readManifest: %w", err)
+	}
+	nextHash := newHashInfo(r.newHash)
+	if manifest.NextHash != nil && manifest.NextHash.Probe != nextHash.Probe {
+		return rehashPlan{}, 0, fmt.Errorf("store is being moved to hash '%s', which does not match the given hash", manifest.NextHash.Algorithm)
+	}
+	if manifest.NextHash == nil {
+		manifest.NextHash = &nextHash
+		err = s.writeManifest(ctx, manifest)
+		if err != nil {
+			return rehashPlan{}, 0, fmt.Errorf("error on s.writeManifest: %w", err)
+		}
+	}
+
+	s.h.swap(r.newHash)
+
+	planExists, err := s.crud.Exists(ctx, rehashPlanPk)
+	if err != nil {
+		return rehashPlan{}, 0, fmt.Errorf("error checking plan existence: %w", err)
+	}
+
+	if planExists {
+		var plan rehashPlan
+		err = s.readJSON(ctx, rehashPlanPk, &plan)
+		if err != nil {
+			return rehashPlan{}, 0, fmt.Errorf("error reading plan: %w", err)
+		}
+		var checkpoint rehashCheckpoint
+		err = s.readJSON(ctx, rehashCheckpointPk, &checkpoint)
+		if err != nil {
+			return rehashPlan{}, 0, fmt.Errorf("error reading checkpoint: %w", err)
+		}
+		return plan, checkpoint.Done, nil
+	}
+
+	digests, err := s.listDigests(ctx)
+	if err != nil {
+		return rehashPlan{}, 0, fmt.Errorf("error on s.listDigests: %w", err)
+	}
+	plan := rehashPlan{Digests: digests}
+
+	err = s.writeJSON(ctx, rehashPlanPk, plan)
+	if err != nil {
+		return rehashPlan{}, 0, fmt.Errorf("error saving plan: %w", err)
+	}
+
+	err = s.writeJSON(ctx, rehashCheckpointPk, rehashCheckpoint{Done: 0})
+	if err != nil {
+		return rehashPlan{}, 0, fmt.Errorf("error saving checkpoint: %w", err)
+	}
+
+	return plan, 0, nil
+}
+
+// rehashDigest rewrites blob and metadata of oldDigest under the new digest, aliases the old
+// digest to the new one, points every header at the new digest and finally drops the old blob.
+// Every step is safe to repeat, so a crash anywhere is fixed by running the same digest again
+func (r *Rehasher) rehashDigest(ctx context.Context, oldDigest string) (string, error) {
+	s := r.sisInstance
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldExists, err := s.digestExists(ctx, oldDigest)
+	if err != nil {
+		return "", fmt.Errorf("error on s.digestExists: %w", err)
+	}
+	if !oldExists {
+		// already migrated before the checkpoint could be saved, or deleted since the plan was made
+		return s.resolveDigest(ctx, oldDigest)
+	}
+
+	blob, err := s.readBlob(ctx, oldDigest)
+	if err != nil {
+		return "", fmt.Errorf("error on blob read: %w", err)
+	}
+
+	oldMetadata, err := s.readBlobMetadata(ctx, oldDigest)
+	if err != nil {
+		return "", fmt.Errorf("error on blob metadata read: %w", err)
+	}
+
+	newDigest := s.digest(blob)
+	if newDigest == oldDigest {
+		return newDigest, nil
+	}
+
+	newExists, err := s.digestExists(ctx, newDigest)
+	if err != nil {
+		return "", fmt.Errorf("error on s.digestExists: %w", err)
+	}
+
+	if !newExists {
+		err = s.persistBlob(ctx, newDigest, blob)
+		if err != nil {
+			return "", fmt.Errorf("error on s.persistBlob: %w", err)
+		}
+	}
+
+	newMetadata, err := s.readBlobMetadata(ctx, newDigest)
+	if err != nil {
+		return "", fmt.Errorf("error on new blob metadata read: %w", err)
+	}
+	newMetadata = mergeBlobMetadata(newMetadata, oldMetadata)
+
+	err = s.updateBlobMetadata(ctx, newDigest, newMetadata)
+	if err != nil {
+		return "", fmt.Errorf("error on blob metadata update: %w", err)
+	}
+
+	err = s.writeFile(ctx, aliasPk(oldDigest), []byte(newDigest))
+	if err != nil {
+		return "", fmt.Errorf("error writing alias: %w", err)
+	}
+
+	for _, key := range oldMetadata.PkList {
+		header, err := s.readDataHeader(ctx, key)
+		if err != nil {
+			return "", fmt.Errorf("error reading header of '%s': %w", key.Path(), err)
+		}
+		if header.Digest != oldDigest {
+			continue
+		}
+		header.Digest = newDigest
+		err = s.updateDataHeader(ctx, header)
+		if err != nil {
+			return "", fmt.Errorf("error updating header of '%s': %w", key.Path(), err)
+		}
+	}
+
+	err = s.deleteBlob(ctx, oldDigest)
+	if err != nil {
+		return "", fmt.Errorf("error on old blob deletion: %w", err)
+	}
+
+	err = s.deleteBlobMetadata(ctx, oldDigest)
+	if err != nil {
+		return "", fmt.Errorf("error on old blob metadata deletion: %w", err)
+	}
+
+	return newDigest, nil
+}
+
+// finish resolves snapshot and version headers, drops the aliases, which no header points through
+// anymore, and the migration state, and records the new hash on the manifest
+func (r *Rehasher) finish(ctx context.Context, plan rehashPlan) error {
+	s := r.sisInstance
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	manifest, err := s.readManifest(ctx)
+	if err != nil {
+		return fmt.Errorf("error on s.readManifest: %w", err)
+	}
+	if manifest.NextHash != nil {
+		manifest.Hash = *manifest.NextHash
+		manifest.NextHash = nil
+		err = s.writeManifest(ctx, manifest)
+		if err != nil {
+			return fmt.Errorf("error on s.writeManifest: %w", err)
+		}
+	}
+
+	err = s.resolveSnapshotHeaders(ctx)
+	if err != nil {
+		return fmt.Errorf("error on s.resolveSnapshotHeaders: %w", err)
+	}
+	err = s.resolveVersionHeaders(ctx)
+	if err != nil {
+		return fmt.Errorf("error on s.resolveVersionHeaders: %w", err)
+	}
+
+	for _, digest := range plan.Digests {
+		aliased, err := s.crud.Exists(ctx, aliasPk(digest))
+		if err != nil {
+			return fmt.Errorf("error checking alias existence: %w", err)
+		}
+		if !aliased {
+			continue
+		}
+		err = s.crud.Delete(ctx, aliasPk(digest))
+		if err != nil {
+			return fmt.Errorf("error deleting alias of '%s': %w", digest, err)
+		}
+	}
+
+	err = s.crud.Delete(ctx, rehashCheckpointPk)
+	if err != nil {
+		return fmt.Errorf("error deleting checkpoint: %w", err)
+	}
+
+	err = s.crud.Delete(ctx, rehashPlanPk)
+	if err != nil {
+		return fmt.Errorf("error deleting plan: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Rehasher) saveCheckpoint(ctx context.Context, checkpoint rehashCheckpoint) error {
+	s := r.sisInstance
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.writeJSON(ctx, rehashCheckpointPk, checkpoint)
+}
+
+// mergeBlobMetadata adds the keys and refs of from missing on into
+func mergeBlobMetadata(into, from data.BlobMetadata) data.BlobMetadata {
+	for _, key := range from.PkList {
+		if !containsKey(into.PkList, key) {
+			into.PkList = append(into.PkList, key)
+		}
+	}
+	for _, ref := range from.Refs {
+		if !slices.Contains(into.Refs, ref) {
+			into.Refs = append(into.Refs, ref)
+		}
+	}
+	return into
+}
+
+// pendingRehash returns the digests an unfinished rehash has not migrated yet, along with the hash
+// they were made with, which is nil when it is not registered. Both are empty outside of a rehash
+func (s SIS) pendingRehash(ctx context.Context) (map[string]bool, hash.Hash, error) {
+
+	manifest, err := s.readManifest(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error on s.readManifest: %w", err)
+	}
+	if manifest.NextHash == nil {
+		return nil, nil, nil
+	}
+
+	oldHash, err := sishash.New(manifest.Hash.Algorithm)
+	if err != nil || sishash.Probe(oldHash) != manifest.Hash.Probe {
+		oldHash = nil
+	}
+
+	planExists, err := s.crud.Exists(ctx, rehashPlanPk)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error checking plan existence: %w", err)
+	}
+
+	var digests []string
+	if planExists {
+		var plan rehashPlan
+		err = s.readJSON(ctx, rehashPlanPk, &plan)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading plan: %w", err)
+		}
+		var checkpoint rehashCheckpoint
+		err = s.readJSON(ctx, rehashCheckpointPk, &checkpoint)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading checkpoint: %w", err)
+		}
+		digests = plan.Digests[min(checkpoint.Done, len(plan.Digests)):]
+	} else {
+		// interrupted before the plan was saved, so any digest may still be an old one
+		digests, err = s.listDigests(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error on s.listDigests: %w", err)
+		}
+	}
+
+	pending := make(map[string]bool, len(digests))
+	for _, digest := range digests {
+		pending[digest] = true
+	}
+	return pending, oldHash, nil
+}
+
+// digestMatches reports whether blob hashes to digest with the current hash, or, for digests a
+// rehash has not migrated yet, with the old one. Those are not verified when the old hash is unknown
+func (s SIS) digestMatches(blob []byte, digest string, pending bool, oldHash hash.Hash) bool {
+	if s.digest(blob) == digest {
+		return true
+	}
+	if !pending {
+		return false
+	}
+	if oldHash == nil {
+		return true
+	}
+	oldHash.Reset()
+	oldHash.Write(blob)
+	return fmt.Sprintf("%x", oldHash.Sum(nil)) == digest
+}
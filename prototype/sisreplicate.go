@@ -0,0 +1,401 @@
+package sis
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"sis/internal/crud"
+	"sis/internal/metrics"
+	"sis/internal/pk"
+	"slices"
+)
+
+// a ReplicationCursor is the sequence number of the last change of the source replicated, so that
+// the next replication only replays the changes recorded after it. It can be saved as JSON
+type ReplicationCursor struct {
+	Seq uint64 `json:"seq"`
+}
+
+type ReplicatedKey struct {
+	Digest   string         `json:"digest"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+type ReplicationOptions struct {
+	// Cursor, if set, makes the replication incremental: only the keys changed in the source after
+	// the cursor are replicated, which needs the source to be opened WithChangeFeed. When those
+	// changes were dropped by retention, Replicate fails with ErrChangesDropped and a replication
+	// without cursor is needed. Without it, the destination is compared key by key
+	Cursor *ReplicationCursor
+}
+
+type ReplicationResult struct {
+	// Keys is the number of keys created or updated in the destination
+	Keys    int `json:"keys"`
+	Deleted int `json:"deleted"`
+	// Blobs and Bytes count the blobs transferred, which are only those the destination lacked
+	Blobs int          `json:"blobs"`
+	Bytes metrics.Byte `json:"bytes"`
+	// Cursor is where the next incremental replication starts from. It is zero when the source has
+	// no change feed
+	Cursor ReplicationCursor `json:"-"`
+}
+
+// Replicate makes dst hold the same keys, contents and metadata as src, deleting keys src does not
+// have. Only blobs missing from dst are transferred, without being rehashed, so both stores must
+// use the same hash. Writes to src during the replication may or may not be replicated, and are
+// replicated again by the next incremental replication
+func Replicate(ctx context.Context, src, dst *SIS, options ReplicationOptions) (ReplicationResult, error) {
+
+	err := checkSameHash(ctx, src, dst)
+	if err != nil {
+		return ReplicationResult{}, err
+	}
+
+	if options.Cursor != nil {
+		return replicateChanges(ctx, src, dst, *options.Cursor)
+	}
+
+	// taken first, so that changes made while the keys are read are replayed by the next run
+	res := ReplicationResult{Cursor: src.changeCursor()}
+
+	srcState, err := src.replicationState(ctx)
+	if err != nil {
+		return ReplicationResult{}, fmt.Errorf("error reading source keys: %w", err)
+	}
+	dstState, err := dst.replicationState(ctx)
+	if err != nil {
+		return ReplicationResult{}, fmt.Errorf("error reading destination keys: %w", err)
+	}
+
+	paths := slices.Collect(maps.Keys(srcState))
+	for path := range dstState {
+		if _, ok := srcState[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+
+	for _, path := range paths {
+		state, ok := srcState[path]
+		err = dst.replicatePath(ctx, src, path, state, ok, &res)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	return res, nil
+}
+
+// replicateChanges replicates the keys changed in src after cursor, each to its current state
+func replicateChanges(ctx context.Context, src, dst *SIS, cursor ReplicationCursor) (ReplicationResult, error) {
+	if src.feed == nil {
+		return ReplicationResult{}, fmt.Errorf("incremental replication needs the change feed of the source")
+	}
+
+	res := ReplicationResult{Cursor: cursor}
+	var paths []string
+	seen := make(map[string]bool)
+	for change, err := range src.Changes(ctx, cursor.Seq) {
+		if err != nil {
+			return res, fmt.Errorf("error reading source changes: %w", err)
+		}
+		path := change.PK.Path()
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+		res.Cursor.Seq = change.Seq
+	}
+
+	for _, path := range paths {
+		state, ok, err := src.replicatedKey(ctx, pk.New(path))
+		if err != nil {
+			return res, fmt.Errorf("error reading source key '%s': %w", path, err)
+		}
+		err = dst.replicatePath(ctx, src, path, state, ok, &res)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	return res, nil
+}
+
+// replicatePath brings the key at path to state, or deletes it when the source does not have it,
+// counting what was done in res
+func (s *SIS) replicatePath(ctx context.Context, src *SIS, path string, state ReplicatedKey, inSrc bool, res *ReplicationResult) error {
+	key := pk.New(path)
+
+	dstState, inDst, err := s.replicatedKey(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error reading destination key '%s': %w", path, err)
+	}
+
+	if !inSrc {
+		if !inDst {
+			return nil
+		}
+		err = s.Delete(ctx, key)
+		if err != nil {
+			return fmt.Errorf("error deleting '%s': %w", path, err)
+		}
+		res.Deleted++
+		return nil
+	}
+
+	if inDst && dstState.Digest == state.Digest && reflect.DeepEqual(dstState.Metadata, state.Metadata) {
+		return nil
+	}
+	transferred, err := s.replicateKey(ctx, src, key, state)
+	if err != nil {
+		return fmt.Errorf("error replicating '%s': %w", path, err)
+	}
+	res.Keys++
+	if transferred != nil {
+		res.Blobs++
+		res.Bytes += *transferred
+	}
+	return nil
+}
+
+// a ReplicaMismatch is a key that does not resolve to the same digest in both stores. A digest is
+// empty when the key is missing from that store
+type ReplicaMismatch struct {
+	PK        pk.PK  `json:"pk"`
+	SrcDigest string `json:"srcDigest"`
+	DstDigest string `json:"dstDigest"`
+}
+
+// VerifyReplica compares every key of src and dst, returning those that differ
+func VerifyReplica(ctx context.Context, src, dst *SIS) ([]ReplicaMismatch, error) {
+
+	srcState, err := src.replicationState(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading source keys: %w", err)
+	}
+	dstState, err := dst.replicationState(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading destination keys: %w", err)
+	}
+
+	paths := slices.Collect(maps.Keys(srcState))
+	for path := range dstState {
+		if _, ok := srcState[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+
+	var mismatches []ReplicaMismatch
+	for _, path := range paths {
+		srcDigest, dstDigest := srcState[path].Digest, dstState[path].Digest
+		if srcDigest != dstDigest {
+			mismatches = append(mismatches, ReplicaMismatch{
+				PK:        pk.New(path),
+				SrcDigest: srcDigest,
+				DstDigest: dstDigest,
+			})
+		}
+	}
+
+	return mismatches, nil
+}
+
+func checkSameHash(ctx context.Context, src, dst *SIS) error {
+	srcManifest, err := src.Manifest(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading source manifest: %w", err)
+	}
+	dstManifest, err := dst.Manifest(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading destination manifest: %w", err)
+	}
+	if srcManifest.Hash.Probe != dstManifest.Hash.Probe {
+		return fmt.Errorf("stores use different hashes: %w", ErrConflict)
+	}
+	return nil
+}
+
+// changeCursor is the cursor of the last change recorded by s, zero without a change feed
+func (s *SIS) changeCursor() ReplicationCursor {
+	if s.feed == nil {
+		return ReplicationCursor{}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return ReplicationCursor{Seq: s.feed.nextSeq - 1}
+}
+
+// replicatedKey reads the resolved digest and metadata of key, reporting whether it exists
+func (s *SIS) replicatedKey(ctx context.Context, key pk.PK) (ReplicatedKey, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	exists, err := s.pkExists(ctx, key)
+	if err != nil || !exists {
+		return ReplicatedKey{}, false, err
+	}
+	state, err := s.keyState(ctx, key)
+	if err != nil {
+		return ReplicatedKey{}, false, err
+	}
+	return state, true, nil
+}
+
+// replicationState reads the resolved digest and metadata of every key at once
+func (s *SIS) replicationState(ctx context.Context) (map[string]ReplicatedKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state := make(map[string]ReplicatedKey)
+	err := s.walkKeys(ctx, pk.PK{}, func(key pk.PK) error {
+		keyState, err := s.keyState(ctx, key)
+		if err != nil {
+			return err
+		}
+		state[key.Path()] = keyState
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error on s.walkKeys: %w", err)
+	}
+
+	return state, nil
+}
+
+func (s SIS) keyState(ctx context.Context, key pk.PK) (ReplicatedKey, error) {
+	header, err := s.readDataHeader(ctx, key)
+	if err != nil {
+		return ReplicatedKey{}, fmt.Errorf("error on data header read: %w", err)
+	}
+	digest, err := s.resolveDigest(ctx, header.Digest)
+	if err != nil {
+		return ReplicatedKey{}, fmt.Errorf("error on s.resolveDigest: %w", err)
+	}
+	return ReplicatedKey{Digest: digest, Metadata: header.Metadata}, nil
+}
+
+// replicateKey points key at the digest of state, fetching the blob from src if s lacks it. The
+// blob is fetched before locking s, so that the two stores are never locked together. transferred
+// is nil when no blob was fetched
+func (s *SIS) replicateKey(ctx context.Context, src *SIS, key pk.PK, state ReplicatedKey) (transferred *metrics.Byte, err error) {
+
+	s.mu.RLock()
+	hasDigest, err := s.digestExists(ctx, state.Digest)
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("error on s.digestExists: %w", err)
+	}
+
+	var fetched fetchedBlob
+	if !hasDigest {
+		fetched, err = s.fetchBlob(ctx, src, state.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching blob: %w", err)
+		}
+		if fetched.stagedPk != nil {
+			defer s.dropStaged(ctx, fetched.stagedPk)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	persistBlob := func() error {
+		if !fetched.ok {
+			return fmt.Errorf("blob was deleted from the destination during replication: %w", ErrConflict)
+		}
+		if fetched.stagedPk == nil {
+			return s.persistBlob(ctx, state.Digest, fetched.blob)
+		}
+		err := s.crud.(crud.Streamer).Rename(ctx, fetched.stagedPk, blobPk(state.Digest))
+		if err != nil {
+			return fmt.Errorf("error moving staged blob: %w", err)
+		}
+		return s.persistEmptyBlobMetadata(ctx, state.Digest)
+	}
+
+	pkExists, err := s.pkExists(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("error on s.pkExists: %w", err)
+	}
+	if pkExists {
+		err = s.update(ctx, key, state.Digest, persistBlob)
+	} else {
+		err = s.create(ctx, key, state.Digest, persistBlob)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := s.readDataHeader(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("error on data header read: %w", err)
+	}
+	if !reflect.DeepEqual(header.Metadata, state.Metadata) {
+		err = s.updateMetadata(ctx, header, state.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("error on s.updateMetadata: %w", err)
+		}
+	}
+
+	if fetched.ok {
+		return &fetched.size, nil
+	}
+	return nil, nil
+}
+
+// a fetchedBlob is a blob copied from another store, either staged in this one or held in memory
+type fetchedBlob struct {
+	ok       bool
+	stagedPk pk.PK
+	blob     []byte
+	size     metrics.Byte
+}
+
+func (s *SIS) fetchBlob(ctx context.Context, src *SIS, digest string) (fetchedBlob, error) {
+	src.mu.RLock()
+	defer src.mu.RUnlock()
+
+	var reader io.Reader
+	if srcStreamer, ok := src.crud.(crud.Streamer); ok {
+		blobReader, err := srcStreamer.Open(ctx, blobPk(digest))
+		if err != nil {
+			return fetchedBlob{}, fmt.Errorf("error on blob streamer.Open: %w", err)
+		}
+		defer blobReader.Close()
+		reader = blobReader
+	} else {
+		blob, err := src.readBlob(ctx, digest)
+		if err != nil {
+			return fetchedBlob{}, fmt.Errorf("error on blob read: %w", err)
+		}
+		reader = bytes.NewReader(blob)
+	}
+
+	dstStreamer, ok := s.crud.(crud.Streamer)
+	if !ok {
+		blob, err := io.ReadAll(reader)
+		if err != nil {
+			return fetchedBlob{}, fmt.Errorf("error reading blob: %w", err)
+		}
+		return fetchedBlob{ok: true, blob: blob, size: metrics.Byte(len(blob))}, nil
+	}
+
+	stagedPk, err := s.stage(ctx, dstStreamer, reader)
+	if err != nil {
+		return fetchedBlob{}, fmt.Errorf("error staging blob: %w", err)
+	}
+	size, err := s.crud.SizeOf(ctx, stagedPk)
+	if err != nil {
+		s.dropStaged(ctx, stagedPk)
+		return fetchedBlob{}, fmt.Errorf("error on staged blob s.crud.SizeOf: %w", err)
+	}
+	return fetchedBlob{ok: true, stagedPk: stagedPk, size: size}, nil
+}
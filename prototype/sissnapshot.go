@@ -0,0 +1,492 @@
+package sis
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"reflect"
+	"sis/internal/constants"
+	"sis/internal/data"
+	"sis/internal/pk"
+	"slices"
+	"strings"
+	"time"
+)
+
+// a snapshot is a copy of every user header under sys/snapshots/<name>/keys, plus a ref on the
+// metadata of each blob it uses, so that the blobs outlive the keys. Its info is written last, so a
+// snapshot without info is an interrupted one, which GC removes
+const snapshotRefPrefix = "snapshot:"
+
+type SnapshotInfo struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	Keys      int       `json:"keys"`
+}
+
+func snapshotPk(name string) pk.PK {
+	return constants.SystemSnapshotSpace.Suffix(pk.PK{name})
+}
+
+func snapshotInfoPk(name string) pk.PK {
+	return snapshotPk(name).Suffix(pk.PK{"info"})
+}
+
+func snapshotKeysSpace(name string) pk.PK {
+	return snapshotPk(name).Suffix(pk.PK{"keys"})
+}
+
+func snapshotHeaderPk(name string, key pk.PK) pk.PK {
+	return key.Prefix(snapshotKeysSpace(name)).Suffix(constants.DataHeaderSuffix)
+}
+
+func snapshotRef(name string) string {
+	return snapshotRefPrefix + name
+}
+
+// Snapshot captures the current user keys under name. Only headers are copied, blobs are shared
+func (s *SIS) Snapshot(ctx context.Context, name string) (SnapshotInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the name is a segment of sys/snapshots, with the rules of the segments of keys
+	err := checkKey("snapshot", pk.PK{name})
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	exists, err := s.crud.Exists(ctx, snapshotInfoPk(name))
+	if err != nil {
+		return SnapshotInfo{}, fmt.Errorf("error checking snapshot existence: %w", err)
+	}
+	if exists {
+		return SnapshotInfo{}, fmt.Errorf("snapshot '%s': %w", name, ErrAlreadyExists)
+	}
+
+	// an interrupted Snapshot of the same name leaves header copies and refs that would outlive it
+	err = s.dropSnapshotKeys(ctx, name)
+	if err != nil {
+		return SnapshotInfo{}, fmt.Errorf("error on s.dropSnapshotKeys: %w", err)
+	}
+
+	info := SnapshotInfo{Name: name, CreatedAt: time.Now().UTC()}
+	digests := make(map[string]bool)
+	err = s.walkKeys(ctx, pk.PK{}, func(key pk.PK) error {
+		header, err := s.readDataHeader(ctx, key)
+		if err != nil {
+			return fmt.Errorf("error reading header of '%s': %w", key.Path(), err)
+		}
+		header.Digest, err = s.resolveDigest(ctx, header.Digest)
+		if err != nil {
+			return fmt.Errorf("error on s.resolveDigest: %w", err)
+		}
+		err = s.writeJSON(ctx, snapshotHeaderPk(name, key), header)
+		if err != nil {
+			return fmt.Errorf("error copying header of '%s': %w", key.Path(), err)
+		}
+		digests[header.Digest] = true
+		info.Keys++
+		return nil
+	})
+	if err != nil {
+		return SnapshotInfo{}, fmt.Errorf("error on s.walkKeys: %w", err)
+	}
+
+	for _, digest := range slices.Sorted(maps.Keys(digests)) {
+		err = s.addRef(ctx, digest, snapshotRef(name))
+		if err != nil {
+			return SnapshotInfo{}, fmt.Errorf("error on s.addRef: %w", err)
+		}
+	}
+
+	err = s.writeJSON(ctx, snapshotInfoPk(name), info)
+	if err != nil {
+		return SnapshotInfo{}, fmt.Errorf("error writing snapshot info: %w", err)
+	}
+
+	return info, nil
+}
+
+// ListSnapshots returns the snapshots sorted by name
+func (s *SIS) ListSnapshots(ctx context.Context) ([]SnapshotInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names, err := s.listSnapshotNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []SnapshotInfo
+	for _, name := range names {
+		info, found, err := s.readSnapshotInfo(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			snapshots = append(snapshots, info)
+		}
+	}
+
+	return snapshots, nil
+}
+
+// ReadAt reads key as it was when snapshot was taken
+func (s *SIS) ReadAt(ctx context.Context, snapshot string, key pk.PK) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// a snapshot interrupted while being taken has headers but no info, and is not readable
+	_, found, err := s.readSnapshotInfo(ctx, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("snapshot '%s': %w", snapshot, ErrNotFound)
+	}
+
+	header, err := s.readSnapshotHeader(ctx, snapshot, key)
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := s.resolveDigest(ctx, header.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("error on s.resolveDigest: %w", err)
+	}
+
+	blob, err := s.readBlob(ctx, digest)
+	if err != nil {
+		return nil, fmt.Errorf("error on blob read: %w", err)
+	}
+
+	return blob, nil
+}
+
+// RestoreSnapshot brings the user keys back to the state of snapshot: keys it holds are recreated
+// or pointed back at their old contents and metadata, and keys created after it are deleted.
+// The snapshot itself is kept
+func (s *SIS) RestoreSnapshot(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, found, err := s.readSnapshotInfo(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("snapshot '%s': %w", name, ErrNotFound)
+	}
+
+	// every key is checked before the first one is restored, so that a bad key does not stop the
+	// restore halfway
+	var keys []pk.PK
+	snapshotKeys := make(map[string]bool)
+	err = s.walkHeaders(ctx, snapshotKeysSpace(name), pk.PK{}, func(key pk.PK) error {
+		snapshotKeys[key.Path()] = true
+		keys = append(keys, key)
+		return checkKey("restore", key)
+	})
+	if err != nil {
+		return fmt.Errorf("error listing snapshot keys: %w", err)
+	}
+	for _, key := range keys {
+		err = s.restoreKey(ctx, name, key)
+		if err != nil {
+			return fmt.Errorf("error restoring keys: %w", err)
+		}
+	}
+
+	var extraKeys []pk.PK
+	err = s.walkKeys(ctx, pk.PK{}, func(key pk.PK) error {
+		if !snapshotKeys[key.Path()] {
+			extraKeys = append(extraKeys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error on s.walkKeys: %w", err)
+	}
+	for _, key := range extraKeys {
+		err = s.delete(ctx, key)
+		if err != nil {
+			return fmt.Errorf("error deleting '%s': %w", key.Path(), err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteSnapshot removes snapshot, deleting the blobs only it was holding
+func (s *SIS) DeleteSnapshot(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, found, err := s.readSnapshotInfo(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("snapshot '%s': %w", name, ErrNotFound)
+	}
+
+	// without its info the snapshot is gone, and GC can finish an interrupted deletion
+	err = s.crud.Delete(ctx, snapshotInfoPk(name))
+	if err != nil {
+		return fmt.Errorf("error deleting snapshot info: %w", err)
+	}
+
+	return s.dropSnapshotKeys(ctx, name)
+}
+
+// restoreKey points the user key at the digest and metadata it has in snapshot
+func (s SIS) restoreKey(ctx context.Context, snapshot string, key pk.PK) error {
+
+	saved, err := s.readSnapshotHeader(ctx, snapshot, key)
+	if err != nil {
+		return err
+	}
+	saved.Digest, err = s.resolveDigest(ctx, saved.Digest)
+	if err != nil {
+		return fmt.Errorf("error on s.resolveDigest: %w", err)
+	}
+
+	// the snapshot ref keeps the blob, so it never has to be written again
+	blobKept := func() error {
+		return fmt.Errorf("blob '%s' of snapshot '%s' is missing", saved.Digest, snapshot)
+	}
+
+	exists, err := s.pkExists(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error on s.pkExists: %w", err)
+	}
+	if exists {
+		err = s.update(ctx, key, saved.Digest, blobKept)
+	} else {
+		err = s.create(ctx, key, saved.Digest, blobKept)
+	}
+	if err != nil {
+		return fmt.Errorf("error restoring '%s': %w", key.Path(), err)
+	}
+
+	header, err := s.readDataHeader(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error on data header read: %w", err)
+	}
+	if reflect.DeepEqual(header.Metadata, saved.Metadata) {
+		return nil
+	}
+	return s.updateMetadata(ctx, header, saved.Metadata)
+}
+
+// dropSnapshotKeys removes the header copies of a snapshot and its refs. Blobs already gone are
+// skipped, as an interrupted snapshot may not hold a ref on them
+func (s SIS) dropSnapshotKeys(ctx context.Context, name string) error {
+
+	var keys []pk.PK
+	digests := make(map[string]bool)
+	err := s.walkHeaders(ctx, snapshotKeysSpace(name), pk.PK{}, func(key pk.PK) error {
+		header, err := s.readSnapshotHeader(ctx, name, key)
+		if err != nil {
+			return err
+		}
+		digest, err := s.resolveDigest(ctx, header.Digest)
+		if err != nil {
+			return fmt.Errorf("error on s.resolveDigest: %w", err)
+		}
+		keys = append(keys, key)
+		digests[digest] = true
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error listing snapshot keys: %w", err)
+	}
+
+	for _, digest := range slices.Sorted(maps.Keys(digests)) {
+		exists, err := s.digestExists(ctx, digest)
+		if err != nil {
+			return fmt.Errorf("error on s.digestExists: %w", err)
+		}
+		if !exists {
+			continue
+		}
+		err = s.removeRef(ctx, digest, snapshotRef(name))
+		if err != nil {
+			return fmt.Errorf("error on s.removeRef: %w", err)
+		}
+	}
+
+	for _, key := range keys {
+		err = s.crud.Delete(ctx, snapshotHeaderPk(name, key))
+		if err != nil {
+			return fmt.Errorf("error deleting snapshot header of '%s': %w", key.Path(), err)
+		}
+	}
+
+	return nil
+}
+
+func (s SIS) listSnapshotNames(ctx context.Context) ([]string, error) {
+	entries, err := s.crud.List(ctx, constants.SystemSnapshotSpace)
+	if err != nil {
+		return nil, fmt.Errorf("error listing snapshots: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir {
+			names = append(names, entry.Name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func (s SIS) readSnapshotInfo(ctx context.Context, name string) (SnapshotInfo, bool, error) {
+	exists, err := s.crud.Exists(ctx, snapshotInfoPk(name))
+	if err != nil {
+		return SnapshotInfo{}, false, fmt.Errorf("error checking snapshot existence: %w", err)
+	}
+	if !exists {
+		return SnapshotInfo{}, false, nil
+	}
+
+	var info SnapshotInfo
+	err = s.readJSON(ctx, snapshotInfoPk(name), &info)
+	if err != nil {
+		return SnapshotInfo{}, false, fmt.Errorf("error reading snapshot info: %w", err)
+	}
+	return info, true, nil
+}
+
+func (s SIS) readSnapshotHeader(ctx context.Context, snapshot string, key pk.PK) (data.Header, error) {
+	exists, err := s.crud.Exists(ctx, snapshotHeaderPk(snapshot, key))
+	if err != nil {
+		return data.Header{}, fmt.Errorf("error checking snapshot header: %w", err)
+	}
+	if !exists {
+		return data.Header{}, fmt.Errorf("pk '%s' in snapshot '%s': %w", key.Path(), snapshot, ErrNotFound)
+	}
+
+	var header data.Header
+	err = s.readJSON(ctx, snapshotHeaderPk(snapshot, key), &header)
+	if err != nil {
+		return data.Header{}, fmt.Errorf("error reading snapshot header: %w", err)
+	}
+	return header, nil
+}
+
+// addRef adds ref to the metadata of digest, once
+func (s SIS) addRef(ctx context.Context, digest, ref string) error {
+
+	metadata, err := s.readBlobMetadata(ctx, digest)
+	if err != nil {
+		return fmt.Errorf("error on blob metadata read: %w", err)
+	}
+	if slices.Contains(metadata.Refs, ref) {
+		return nil
+	}
+	metadata.Refs = append(metadata.Refs, ref)
+
+	return s.updateBlobMetadata(ctx, digest, metadata)
+}
+
+// removeRef drops ref from the metadata of digest, deleting the blob if nothing holds it anymore
+func (s SIS) removeRef(ctx context.Context, digest, ref string) error {
+
+	metadata, err := s.readBlobMetadata(ctx, digest)
+	if err != nil {
+		return fmt.Errorf("error on blob metadata read: %w", err)
+	}
+	metadata.Refs = slices.DeleteFunc(metadata.Refs, func(r string) bool { return r == ref })
+
+	if len(metadata.PkList) > 0 || len(metadata.Refs) > 0 {
+		return s.updateBlobMetadata(ctx, digest, metadata)
+	}
+
+	err = s.deleteBlob(ctx, digest)
+	if err != nil {
+		return fmt.Errorf("error on blob deletion: %w", err)
+	}
+	err = s.deleteBlobMetadata(ctx, digest)
+	if err != nil {
+		return fmt.Errorf("error on blob metadata deletion: %w", err)
+	}
+	return nil
+}
+
+// resolveSnapshotHeaders points snapshot headers at the digests their aliases lead to, as user
+// headers are while rehashing
+func (s SIS) resolveSnapshotHeaders(ctx context.Context) error {
+
+	names, err := s.listSnapshotNames(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		err = s.walkHeaders(ctx, snapshotKeysSpace(name), pk.PK{}, func(key pk.PK) error {
+			header, err := s.readSnapshotHeader(ctx, name, key)
+			if err != nil {
+				return err
+			}
+			digest, err := s.resolveDigest(ctx, header.Digest)
+			if err != nil {
+				return fmt.Errorf("error on s.resolveDigest: %w", err)
+			}
+			if digest == header.Digest {
+				return nil
+			}
+			header.Digest = digest
+			return s.writeJSON(ctx, snapshotHeaderPk(name, key), header)
+		})
+		if err != nil {
+			return fmt.Errorf("error resolving headers of snapshot '%s': %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// snapshotRefIsLive reports whether the snapshot a "snapshot:" ref stands for still exists
+func (s SIS) snapshotRefIsLive(ctx context.Context, ref string) (bool, error) {
+	_, found, err := s.readSnapshotInfo(ctx, strings.TrimPrefix(ref, snapshotRefPrefix))
+	return found, err
+}
+
+// removeIncompleteSnapshots drops the header copies of snapshots without info, left by an
+// interrupted Snapshot or DeleteSnapshot. Their refs are left for GC to drop as dangling
+func (s SIS) removeIncompleteSnapshots(ctx context.Context) (int, error) {
+
+	names, err := s.listSnapshotNames(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, name := range names {
+		_, found, err := s.readSnapshotInfo(ctx, name)
+		if err != nil {
+			return removed, err
+		}
+		if found {
+			continue
+		}
+		var keys []pk.PK
+		err = s.walkHeaders(ctx, snapshotKeysSpace(name), pk.PK{}, func(key pk.PK) error {
+			keys = append(keys, key)
+			return nil
+		})
+		if err != nil {
+			return removed, fmt.Errorf("error listing snapshot keys: %w", err)
+		}
+		for _, key := range keys {
+			err = s.crud.Delete(ctx, snapshotHeaderPk(name, key))
+			if err != nil {
+				return removed, fmt.Errorf("error deleting snapshot header of '%s': %w", key.Path(), err)
+			}
+		}
+		removed++
+	}
+
+	return removed, nil
+}
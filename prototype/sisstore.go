@@ -0,0 +1,48 @@
+package sis
+
+import (
+	"context"
+	"io"
+	"sis/internal/pk"
+)
+
+// Store is the set of operations offered by a SIS instance. Code that only uses a store should
+// depend on Store rather than on SIS, so that middlewares can be placed around it with Chain.
+// Operations stop with the context error once ctx is done. A write cancelled before its key changes
+// is rolled back, and one cancelled after runs to completion, so the store is never left halfway
+type Store interface {
+	Create(ctx context.Context, key pk.PK, blob []byte) error
+	CreateFrom(ctx context.Context, key pk.PK, r io.Reader) error
+	CreateWithMetadata(ctx context.Context, key pk.PK, r io.Reader, metadata map[string]any) (bool, error)
+	Update(ctx context.Context, key pk.PK, blob []byte) error
+	UpdateFrom(ctx context.Context, key pk.PK, r io.Reader) error
+	Read(ctx context.Context, key pk.PK) ([]byte, error)
+	Open(ctx context.Context, key pk.PK) (io.ReadSeekCloser, ObjectInfo, error)
+	Delete(ctx context.Context, key pk.PK) error
+	PutIf(ctx context.Context, key pk.PK, r io.Reader, cond Condition) (bool, error)
+	DeleteIf(ctx context.Context, key pk.PK, cond Condition) error
+	Exists(ctx context.Context, key pk.PK) (bool, error)
+	Stat(ctx context.Context, key pk.PK) (ObjectInfo, error)
+	SetMetadata(ctx context.Context, key pk.PK, metadata map[string]any) error
+	Copy(ctx context.Context, src, dst pk.PK) error
+	Move(ctx context.Context, src, dst pk.PK) error
+	CopyPrefix(ctx context.Context, src, dst pk.PK) (int, error)
+	MovePrefix(ctx context.Context, src, dst pk.PK) (int, error)
+	Batch() *Batch
+	List(ctx context.Context, prefix pk.PK) ([]pk.PK, error)
+	Find(ctx context.Context, pattern string) ([]ObjectInfo, error)
+	Snapshot(ctx context.Context, name string) (SnapshotInfo, error)
+	ListSnapshots(ctx context.Context) ([]SnapshotInfo, error)
+	ReadAt(ctx context.Context, snapshot string, key pk.PK) ([]byte, error)
+	RestoreSnapshot(ctx context.Context, name string) error
+	DeleteSnapshot(ctx context.Context, name string) error
+	ListVersions(ctx context.Context, key pk.PK) ([]VersionInfo, error)
+	ReadVersion(ctx context.Context, key pk.PK, id string) ([]byte, error)
+	PruneVersions(ctx context.Context) (int, error)
+	CompactChanges(ctx context.Context) (int, error)
+	Usage(ctx context.Context) (Usage, error)
+	Check(ctx context.Context) ([]Problem, error)
+	GC(ctx context.Context) (GCResult, error)
+}
+
+var _ Store = (*SIS)(nil)
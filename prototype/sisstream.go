@@ -0,0 +1,180 @@
+package sis
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sis/internal/constants"
+	"sis/internal/crud"
+	"sis/internal/data"
+	"sis/internal/pk"
+)
+
+// CreateFrom is Create with the blob read from r. On backends implementing crud.Streamer the blob
+// is staged under sys/staging without being held in memory, and the store is only locked while the
+// staged blob is hashed and published
+func (s *SIS) CreateFrom(ctx context.Context, key pk.PK, r io.Reader) error {
+	streamer, ok := s.crud.(crud.Streamer)
+	if !ok {
+		blob, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("error reading blob: %w", err)
+		}
+		return s.Create(ctx, key, blob)
+	}
+
+	return s.publishStaged(ctx, streamer, r, s.create, key)
+}
+
+// CreateWithMetadata is CreateFrom with metadata written in the same header as the key, so that the
+// key never exists without it. It reports whether the blob was new to the store
+func (s *SIS) CreateWithMetadata(ctx context.Context, key pk.PK, r io.Reader, metadata map[string]any) (bool, error) {
+	stored := false
+	publish := func(ctx context.Context, key pk.PK, digest string, persistBlob func() error) error {
+		return s.createHeader(ctx, data.Header{PK: key, Digest: digest, Metadata: metadata}, func() error {
+			stored = true
+			return persistBlob()
+		})
+	}
+
+	streamer, ok := s.crud.(crud.Streamer)
+	if !ok {
+		blob, err := io.ReadAll(r)
+		if err != nil {
+			return false, fmt.Errorf("error reading blob: %w", err)
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		digest := s.digest(blob)
+		err = publish(ctx, key, digest, func() error {
+			return s.persistBlob(ctx, digest, blob)
+		})
+		return stored, err
+	}
+
+	err := s.publishStaged(ctx, streamer, r, publish, key)
+	return stored, err
+}
+
+// UpdateFrom is Update with the blob read from r, staged like in CreateFrom
+func (s *SIS) UpdateFrom(ctx context.Context, key pk.PK, r io.Reader) error {
+	streamer, ok := s.crud.(crud.Streamer)
+	if !ok {
+		blob, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("error reading blob: %w", err)
+		}
+		return s.Update(ctx, key, blob)
+	}
+
+	return s.publishStaged(ctx, streamer, r, s.update, key)
+}
+
+// Open returns a reader over the blob of key along with its info. The reader must be closed
+func (s *SIS) Open(ctx context.Context, key pk.PK) (io.ReadSeekCloser, ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	info, err := s.stat(ctx, key)
+	if err != nil {
+		return nil, ObjectInfo{}, fmt.Errorf("error on s.stat: %w", err)
+	}
+
+	streamer, ok := s.crud.(crud.Streamer)
+	if !ok {
+		blob, err := s.readBlob(ctx, info.Digest)
+		if err != nil {
+			return nil, ObjectInfo{}, fmt.Errorf("error on blob read: %w", err)
+		}
+		return bytesReadSeekCloser{bytes.NewReader(blob)}, info, nil
+	}
+
+	reader, err := streamer.Open(ctx, blobPk(info.Digest))
+	if err != nil {
+		return nil, ObjectInfo{}, fmt.Errorf("error on blob streamer.Open: %w", err)
+	}
+
+	return reader, info, nil
+}
+
+// publishStaged stages r, hashes it and hands the digest to publish, which is s.create or s.update
+func (s *SIS) publishStaged(ctx context.Context, streamer crud.Streamer, r io.Reader, publish func(context.Context, pk.PK, string, func() error) error, key pk.PK) error {
+
+	stagedPk, err := s.stage(ctx, streamer, r)
+	if err != nil {
+		return fmt.Errorf("error staging blob: %w", err)
+	}
+	defer s.dropStaged(ctx, stagedPk)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	digest, _, err := s.digestStaged(ctx, streamer, stagedPk)
+	if err != nil {
+		return fmt.Errorf("error hashing staged blob: %w", err)
+	}
+
+	return publish(ctx, key, digest, func() error {
+		err := streamer.Rename(ctx, stagedPk, blobPk(digest))
+		if err != nil {
+			return fmt.Errorf("error moving staged blob: %w", err)
+		}
+		return s.persistEmptyBlobMetadata(ctx, digest)
+	})
+}
+
+func (s *SIS) stage(ctx context.Context, streamer crud.Streamer, r io.Reader) (pk.PK, error) {
+
+	idBytes := make([]byte, 16)
+	_, err := rand.Read(idBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error generating staging id: %w", err)
+	}
+	stagedPk := constants.SystemStagingSpace.Suffix(pk.PK{fmt.Sprintf("%x", idBytes)})
+
+	_, err = streamer.CreateFrom(ctx, stagedPk, r)
+	if err != nil {
+		s.dropStaged(ctx, stagedPk)
+		return nil, fmt.Errorf("error on streamer.CreateFrom: %w", err)
+	}
+
+	return stagedPk, nil
+}
+
+// digestStaged hashes a staged blob with the current hash, returning its generation along
+func (s SIS) digestStaged(ctx context.Context, streamer crud.Streamer, stagedPk pk.PK) (string, int, error) {
+
+	reader, err := streamer.Open(ctx, stagedPk)
+	if err != nil {
+		return "", 0, fmt.Errorf("error on streamer.Open: %w", err)
+	}
+	defer reader.Close()
+
+	digest, generation, err := s.h.sum(reader)
+	if err != nil {
+		return "", 0, fmt.Errorf("error reading staged blob: %w", err)
+	}
+
+	return digest, generation, nil
+}
+
+// dropStaged deletes a staged blob that was not moved into sys/data. It runs even if ctx is done
+func (s *SIS) dropStaged(ctx context.Context, stagedPk pk.PK) {
+	ctx = context.WithoutCancel(ctx)
+	exists, err := s.crud.Exists(ctx, stagedPk)
+	if err == nil && exists {
+		s.crud.Delete(ctx, stagedPk)
+	}
+}
+
+type bytesReadSeekCloser struct {
+	*bytes.Reader
+}
+
+func (b bytesReadSeekCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,311 @@
+package sis
+
+import (
+	"context"
+	"fmt"
+	"sis/internal/constants"
+	"sis/internal/pk"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// versions of a key live under sys/versions/<key>/data-versions/<id>, and hold their blob with a
+// "version:<key>@<id>" ref. Ids increase with every write of the key
+const versionRefPrefix = "version:"
+
+var versionsSuffix = pk.PK{"data-versions"}
+
+// VersionRetention decides which versions are pruned. The latest version of a key is always kept
+type VersionRetention struct {
+	// KeepLast is the number of versions kept per key, unlimited when zero
+	KeepLast int
+	// KeepFor is how long versions are kept, forever when zero
+	KeepFor time.Duration
+}
+
+// WithVersioning keeps a version of a key on every create and update, pruned by retention. Old
+// versions stay readable after the key is updated or deleted
+func WithVersioning(retention VersionRetention) Option {
+	return func(s *SIS) {
+		s.versioning = &retention
+	}
+}
+
+type VersionInfo struct {
+	ID        string         `json:"id"`
+	Digest    string         `json:"digest"`
+	Metadata  map[string]any `json:"metadata,omitempty"`
+	CreatedAt time.Time      `json:"createdAt"`
+}
+
+func versionsPk(key pk.PK) pk.PK {
+	return key.Prefix(constants.SystemVersionSpace).Suffix(versionsSuffix)
+}
+
+func versionPk(key pk.PK, id string) pk.PK {
+	return versionsPk(key).Suffix(pk.PK{id})
+}
+
+func versionRef(key pk.PK, id string) string {
+	return versionRefPrefix + key.Path() + "@" + id
+}
+
+// ListVersions returns the versions kept for key, oldest first. The key does not need to exist anymore
+func (s *SIS) ListVersions(ctx context.Context, key pk.PK) ([]VersionInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.listVersions(ctx, key)
+}
+
+// ReadVersion reads key as it was at version id, or the current contents when id is empty
+func (s *SIS) ReadVersion(ctx context.Context, key pk.PK, id string) ([]byte, error) {
+	if id == "" {
+		return s.Read(ctx, key)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	version, found, err := s.readVersion(ctx, key, id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("version '%s' of '%s': %w", id, key.Path(), ErrNotFound)
+	}
+
+	digest, err := s.resolveDigest(ctx, version.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("error on s.resolveDigest: %w", err)
+	}
+
+	blob, err := s.readBlob(ctx, digest)
+	if err != nil {
+		return nil, fmt.Errorf("error on blob read: %w", err)
+	}
+
+	return blob, nil
+}
+
+// PruneVersions applies the retention to every key with versions, which writes only do for the
+// key they write. It returns the number of versions pruned
+func (s *SIS) PruneVersions(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.versioning == nil {
+		return 0, fmt.Errorf("versioning is not enabled")
+	}
+
+	var keys []pk.PK
+	err := s.walkVersionedKeys(ctx, pk.PK{}, func(key pk.PK) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for _, key := range keys {
+		n, err := s.pruneVersions(ctx, key)
+		if err != nil {
+			return pruned, err
+		}
+		pruned += n
+	}
+
+	return pruned, nil
+}
+
+// recordVersion keeps the current header of key as a new version, if versioning is enabled.
+// Callers must hold the write lock
+func (s SIS) recordVersion(ctx context.Context, key pk.PK) error {
+	if s.versioning == nil {
+		return nil
+	}
+
+	header, err := s.readDataHeader(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error on data header read: %w", err)
+	}
+	digest, err := s.resolveDigest(ctx, header.Digest)
+	if err != nil {
+		return fmt.Errorf("error on s.resolveDigest: %w", err)
+	}
+
+	versions, err := s.listVersions(ctx, key)
+	if err != nil {
+		return err
+	}
+	var next uint64 = 1
+	if len(versions) > 0 {
+		last, err := strconv.ParseUint(versions[len(versions)-1].ID, 10, 64)
+		if err != nil {
+			return fmt.Errorf("error parsing version id: %w", err)
+		}
+		next = last + 1
+	}
+
+	version := VersionInfo{
+		ID:        fmt.Sprintf("%020d", next),
+		Digest:    digest,
+		Metadata:  header.Metadata,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	// the ref goes first, so that a version never points at a blob it does not hold
+	err = s.addRef(ctx, digest, versionRef(key, version.ID))
+	if err != nil {
+		return fmt.Errorf("error on s.addRef: %w", err)
+	}
+	err = s.writeJSON(ctx, versionPk(key, version.ID), version)
+	if err != nil {
+		return fmt.Errorf("error writing version: %w", err)
+	}
+
+	_, err = s.pruneVersions(ctx, key)
+	return err
+}
+
+// pruneVersions drops the versions of key the retention no longer keeps, and their refs
+func (s SIS) pruneVersions(ctx context.Context, key pk.PK) (int, error) {
+
+	versions, err := s.listVersions(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	retention := s.versioning
+	pruned := 0
+	for i, version := range versions[:max(len(versions)-1, 0)] {
+		tooMany := retention.KeepLast > 0 && len(versions)-i > retention.KeepLast
+		tooOld := retention.KeepFor > 0 && time.Since(version.CreatedAt) > retention.KeepFor
+		if !tooMany && !tooOld {
+			continue
+		}
+
+		err = s.crud.Delete(ctx, versionPk(key, version.ID))
+		if err != nil {
+			return pruned, fmt.Errorf("error deleting version '%s' of '%s': %w", version.ID, key.Path(), err)
+		}
+		digest, err := s.resolveDigest(ctx, version.Digest)
+		if err != nil {
+			return pruned, fmt.Errorf("error on s.resolveDigest: %w", err)
+		}
+		err = s.removeRef(ctx, digest, versionRef(key, version.ID))
+		if err != nil {
+			return pruned, fmt.Errorf("error on s.removeRef: %w", err)
+		}
+		pruned++
+	}
+
+	return pruned, nil
+}
+
+func (s SIS) listVersions(ctx context.Context, key pk.PK) ([]VersionInfo, error) {
+
+	entries, err := s.crud.List(ctx, versionsPk(key))
+	if err != nil {
+		return nil, fmt.Errorf("error listing versions of '%s': %w", key.Path(), err)
+	}
+
+	// ids are zero padded, so listing order is version order
+	var versions []VersionInfo
+	for _, entry := range entries {
+		if entry.IsDir {
+			continue
+		}
+		version, found, err := s.readVersion(ctx, key, entry.Name)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			versions = append(versions, version)
+		}
+	}
+
+	return versions, nil
+}
+
+func (s SIS) readVersion(ctx context.Context, key pk.PK, id string) (VersionInfo, bool, error) {
+	exists, err := s.crud.Exists(ctx, versionPk(key, id))
+	if err != nil {
+		return VersionInfo{}, false, fmt.Errorf("error checking version existence: %w", err)
+	}
+	if !exists {
+		return VersionInfo{}, false, nil
+	}
+
+	var version VersionInfo
+	err = s.readJSON(ctx, versionPk(key, id), &version)
+	if err != nil {
+		return VersionInfo{}, false, fmt.Errorf("error reading version '%s' of '%s': %w", id, key.Path(), err)
+	}
+	return version, true, nil
+}
+
+// walkVersionedKeys calls fn with every key under prefix that has versions
+func (s SIS) walkVersionedKeys(ctx context.Context, prefix pk.PK, fn func(key pk.PK) error) error {
+
+	entries, err := s.crud.List(ctx, prefix.Prefix(constants.SystemVersionSpace))
+	if err != nil {
+		return fmt.Errorf("error listing versions under '%s': %w", prefix.Path(), err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir {
+			continue
+		}
+		if entry.Name == versionsSuffix[0] {
+			err = fn(prefix)
+		} else {
+			err = s.walkVersionedKeys(ctx, prefix.Suffix(pk.PK{entry.Name}), fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// versionRefIsLive reports whether the version a "version:" ref stands for still exists
+func (s SIS) versionRefIsLive(ctx context.Context, ref string) (bool, error) {
+	rest := strings.TrimPrefix(ref, versionRefPrefix)
+	// keys may contain '@', ids never do
+	at := strings.LastIndex(rest, "@")
+	if at < 0 {
+		return false, nil
+	}
+	_, found, err := s.readVersion(ctx, pk.New(rest[:at]), rest[at+1:])
+	return found, err
+}
+
+// resolveVersionHeaders points versions at the digests their aliases lead to, like
+// resolveSnapshotHeaders
+func (s SIS) resolveVersionHeaders(ctx context.Context) error {
+	return s.walkVersionedKeys(ctx, pk.PK{}, func(key pk.PK) error {
+		versions, err := s.listVersions(ctx, key)
+		if err != nil {
+			return err
+		}
+		for _, version := range versions {
+			digest, err := s.resolveDigest(ctx, version.Digest)
+			if err != nil {
+				return fmt.Errorf("error on s.resolveDigest: %w", err)
+			}
+			if digest == version.Digest {
+				continue
+			}
+			version.Digest = digest
+			err = s.writeJSON(ctx, versionPk(key, version.ID), version)
+			if err != nil {
+				return fmt.Errorf("error updating version '%s' of '%s': %w", version.ID, key.Path(), err)
+			}
+		}
+		return nil
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"log"
@@ -10,42 +11,47 @@ import (
 )
 
 func main() {
+	ctx := context.Background()
 	h := sha256.New()
 	crudOs, err := crudos.New("./root")
 	if err != nil {
 		log.Fatalf("error creating crudos instance: %s", err.Error())
 		return
 	}
-	sisInstance := sis.New(h, crudOs)
+	sisInstance, err := sis.New(ctx, h, crudOs)
+	if err != nil {
+		log.Fatalf("error creating sis instance: %s", err.Error())
+		return
+	}
 
 	content1 := []byte("hello")
 	content2 := []byte("byebye")
 
-	err = sisInstance.Create(pk.New("hello/world"), content1)
+	err = sisInstance.Create(ctx, pk.New("hello/world"), content1)
 	if err != nil {
 		log.Fatalf("error creating 'hello/world': %s", err.Error())
 		return
 	}
 
-	err = sisInstance.Create(pk.New("hello/world2"), content1)
+	err = sisInstance.Create(ctx, pk.New("hello/world2"), content1)
 	if err != nil {
 		log.Fatalf("error creating 'hello/world2': %s", err.Error())
 		return
 	}
 
-	err = sisInstance.Create(pk.New("bye/world"), content2)
+	err = sisInstance.Create(ctx, pk.New("bye/world"), content2)
 	if err != nil {
 		log.Fatalf("error creating 'bye/world': %s", err.Error())
 		return
 	}
 
-	blob, err := sisInstance.Read(pk.New("hello/world"))
+	blob, err := sisInstance.Read(ctx, pk.New("hello/world"))
 	if err != nil {
 		log.Fatalf("error reading 'hello/world': %s", err.Error())
 		return
 	}
 
-	blob2, err := sisInstance.Read(pk.New("hello/world2"))
+	blob2, err := sisInstance.Read(ctx, pk.New("hello/world2"))
 	if err != nil {
 		log.Fatalf("error reading 'hello/world2': %s", err.Error())
 		return
@@ -53,19 +59,19 @@ func main() {
 
 	fmt.Println(string(blob), string(blob2))
 
-	err = sisInstance.Delete(pk.New("hello/world"))
+	err = sisInstance.Delete(ctx, pk.New("hello/world"))
 	if err != nil {
 		log.Fatalf("error deleting 'hello/world': %s", err.Error())
 		return
 	}
 
-	err = sisInstance.Delete(pk.New("hello/world2"))
+	err = sisInstance.Delete(ctx, pk.New("hello/world2"))
 	if err != nil {
 		log.Fatalf("error deleting 'hello/world2': %s", err.Error())
 		return
 	}
 
-	blob3, err := sisInstance.Read(pk.New("bye/world"))
+	blob3, err := sisInstance.Read(ctx, pk.New("bye/world"))
 	if err != nil {
 		log.Fatalf("error reading 'bye/world': %s", err.Error())
 		return
@@ -73,7 +79,7 @@ func main() {
 
 	fmt.Println(string(blob3))
 
-	err = sisInstance.Delete(pk.New("bye/world"))
+	err = sisInstance.Delete(ctx, pk.New("bye/world"))
 	if err != nil {
 		log.Fatalf("error deleting 'bye/world': %s", err.Error())
 		return